@@ -0,0 +1,286 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultNonceLifetime is how long the nonce of a challenge may be used by default.
+const DefaultNonceLifetime = 5 * time.Minute
+
+// The reasons why Authenticate turns a request down.
+var (
+	ErrUnauthorized = errors.New("auth: bad credentials")
+	// the credentials were right, but the nonce has expired; the client is to try again
+	// with a new nonce, without asking its user
+	ErrStaleNonce = errors.New("auth: stale nonce")
+	// the nonce count of the request isn't greater than that of a previous one
+	ErrReplayed = errors.New("auth: replayed request")
+)
+
+// the largest number of nonces whose nonce counts are remembered
+const maxNonceCounts = 4096
+
+// Authenticator checks the digest credentials of requests (RFC 7616, and optionally the RFC 2069
+// digests of older clients) against a store. The nonces of its challenges carry their time, signed with
+// a key of the authenticator, so that it can tell its own and expired ones without remembering
+// them. It remembers the nonce counts of the nonces that were authenticated with, so that it can
+// tell replayed requests.
+type Authenticator struct {
+	store Store
+	// how long a nonce may be used
+	NonceLifetime time.Duration
+	// whether the RFC 2069 digests of older clients, which have no "qop", are accepted. They have
+	// no nonce count either, so a captured one can be replayed until its nonce expires.
+	AllowLegacyDigests bool
+	key                []byte
+	// the greatest nonce counts of the authenticated requests, by nonce
+	nonceCounts map[string]uint64
+	// the time of the newest nonce whose nonce count was forgotten to make room for others;
+	// such nonces are stale
+	forgottenUntil time.Time
+	mutex          sync.Mutex
+}
+
+// NewAuthenticator returns an authenticator of the users of a store.
+func NewAuthenticator(store Store) *Authenticator {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return &Authenticator{
+		store:         store,
+		NonceLifetime: DefaultNonceLifetime,
+		key:           key,
+		nonceCounts:   make(map[string]uint64),
+	}
+}
+
+// Store returns the store of the users.
+func (a *Authenticator) Store() Store {
+	return a.store
+}
+
+// Challenges returns the values of the "WWW-Authenticate:" headers of a "401 Unauthorized"
+// response: a challenge for each digest algorithm that the store has credentials for, with a
+// new nonce. The MD5 one comes first, for the clients that only understand the first one.
+func (a *Authenticator) Challenges(stale bool) []string {
+	nonce := a.newNonce(time.Now())
+
+	algorithms := []string{AlgorithmMD5, AlgorithmSHA256}
+	if store, ok := a.store.(algorithmStore); ok {
+		algorithms = store.Algorithms()
+	}
+
+	var challenges []string
+	for _, algorithm := range algorithms {
+		challenge := &Challenge{
+			Scheme:    "Digest",
+			Realm:     a.store.Realm(),
+			Nonce:     nonce,
+			Algorithm: algorithm,
+			QOP:       []string{"auth"},
+			Stale:     stale,
+		}
+		challenges = append(challenges, challenge.String())
+	}
+	return challenges
+}
+
+// newNonce returns a nonce of a time: the time, and its HMAC.
+func (a *Authenticator) newNonce(created time.Time) string {
+	timestamp := make([]byte, 8)
+	binary.BigEndian.PutUint64(timestamp, uint64(created.UnixNano()))
+	return hex.EncodeToString(timestamp) + hex.EncodeToString(a.nonceMAC(timestamp))
+}
+
+func (a *Authenticator) nonceMAC(timestamp []byte) []byte {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write(timestamp)
+	return mac.Sum(nil)[:16]
+}
+
+// nonceTime returns the time of one of our nonces.
+func (a *Authenticator) nonceTime(nonce string) (created time.Time, ok bool) {
+	decoded, err := hex.DecodeString(nonce)
+	if err != nil || len(decoded) != 8+16 || !hmac.Equal(decoded[8:], a.nonceMAC(decoded[:8])) {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(decoded[:8]))), true
+}
+
+// Authenticate checks the credentials of a request to a URL, whose "Authorization:" header has
+// been parsed. It returns nil if they're right.
+func (a *Authenticator) Authenticate(method, requestURL string, header *AuthorizationHeader) error {
+	if header == nil || header.Username == "" || header.Realm != a.store.Realm() ||
+		!SupportedAlgorithm(header.Algorithm) || !digestURIMatches(header.URI, requestURL) {
+		return ErrUnauthorized
+	}
+	algorithm := AlgorithmMD5
+	if strings.EqualFold(header.Algorithm, AlgorithmSHA256) {
+		algorithm = AlgorithmSHA256
+	}
+
+	ha1 := a.store.LookupHA1(header.Username, algorithm)
+	if ha1 == "" {
+		return ErrUnauthorized
+	}
+
+	var response string
+	switch header.QOP {
+	case "":
+		if !a.AllowLegacyDigests {
+			return ErrUnauthorized
+		}
+		response = computeResponse(algorithm, ha1, header.Nonce, "", "", "", method, header.URI)
+	case "auth":
+		response = computeResponse(algorithm, ha1, header.Nonce, header.NC, header.CNonce, header.QOP,
+			method, header.URI)
+	default:
+		return ErrUnauthorized
+	}
+	if subtle.ConstantTimeCompare([]byte(response), []byte(strings.ToLower(header.Response))) != 1 {
+		return ErrUnauthorized
+	}
+
+	// The credentials are right; now the nonce has to be one of ours, and fresh:
+	created, ok := a.nonceTime(header.Nonce)
+	if !ok {
+		return ErrUnauthorized
+	}
+	now := time.Now()
+	if now.Sub(created) > a.NonceLifetime {
+		return ErrStaleNonce
+	}
+
+	// With "qop", every request has a greater nonce count than the previous ones.
+	// (The RFC 2069 digests of older clients have no nonce count, so they reuse the nonce
+	// until it expires, if they're allowed at all.)
+	if header.QOP != "" {
+		nonceCount, err := strconv.ParseUint(header.NC, 16, 32)
+		if err != nil {
+			return ErrReplayed
+		}
+		return a.noteNonceCount(header.Nonce, created, nonceCount, now)
+	}
+	return nil
+}
+
+// noteNonceCount checks that the nonce count of an authenticated request is greater than those
+// of the previous requests with its nonce, and remembers it.
+func (a *Authenticator) noteNonceCount(nonce string, created time.Time, nonceCount uint64, now time.Time) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	previous, existed := a.nonceCounts[nonce]
+	if !existed && !created.After(a.forgottenUntil) {
+		// (Its nonce count was forgotten, so it can't be told from a replay.)
+		return ErrStaleNonce
+	}
+	if nonceCount <= previous {
+		return ErrReplayed
+	}
+
+	if !existed && len(a.nonceCounts) >= maxNonceCounts {
+		a.forgetNonceCounts(now)
+	}
+	a.nonceCounts[nonce] = nonceCount
+	return nil
+}
+
+// forgetNonceCounts makes room for more nonce counts: it forgets those of the expired nonces,
+// or else those of the older half of the nonces.
+func (a *Authenticator) forgetNonceCounts(now time.Time) {
+	var times []time.Time
+	for nonce := range a.nonceCounts {
+		created, _ := a.nonceTime(nonce)
+		if now.Sub(created) > a.NonceLifetime {
+			delete(a.nonceCounts, nonce)
+		} else {
+			times = append(times, created)
+		}
+	}
+	if len(a.nonceCounts) < maxNonceCounts {
+		return
+	}
+
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	a.forgottenUntil = times[len(times)/2]
+	for nonce := range a.nonceCounts {
+		if created, _ := a.nonceTime(nonce); !created.After(a.forgottenUntil) {
+			delete(a.nonceCounts, nonce)
+		}
+	}
+}
+
+// digestURIMatches reports whether the "uri" of a digest is the URL of its request: the URL
+// itself, or its path (and query), which some clients send instead.
+func digestURIMatches(uri, requestURL string) bool {
+	if uri == requestURL {
+		return true
+	}
+	if !strings.HasPrefix(uri, "/") {
+		return false
+	}
+	u, err := url.Parse(requestURL)
+	return err == nil && uri == u.RequestURI()
+}
+
+// BasicChallenge returns the value of the "WWW-Authenticate:" header that offers "Basic"
+// authentication. Basic sends the password itself, so it belongs on TLS connections only.
+func (a *Authenticator) BasicChallenge() string {
+	challenge := &Challenge{Scheme: "Basic", Realm: a.store.Realm()}
+	return challenge.String()
+}
+
+// AuthenticateBasic checks the password of a "Basic" "Authorization:" header against the
+// MD5 credentials of the store, or its password hashes, e.g. those of a htpasswd file.
+func (a *Authenticator) AuthenticateBasic(username, password string) error {
+	if store, ok := a.store.(passwordStore); ok {
+		if username == "" || !store.CheckPassword(username, password) {
+			return ErrUnauthorized
+		}
+		return nil
+	}
+
+	ha1 := a.store.LookupHA1(username, AlgorithmMD5)
+	if username == "" || ha1 == "" {
+		return ErrUnauthorized
+	}
+
+	computed := ComputeHA1(AlgorithmMD5, username, a.store.Realm(), password)
+	if subtle.ConstantTimeCompare([]byte(computed), []byte(ha1)) != 1 {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// ParseBasicAuthorization parses the value of a "Basic" "Authorization:" header.
+func ParseBasicAuthorization(value string) (username, password string, ok bool) {
+	const prefix = "Basic "
+	if len(value) < len(prefix) || !strings.EqualFold(value[:len(prefix)], prefix) {
+		return "", "", false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[len(prefix):]))
+	if err != nil {
+		return "", "", false
+	}
+	i := strings.IndexByte(string(decoded), ':')
+	if i == -1 {
+		return "", "", false
+	}
+	return string(decoded[:i]), string(decoded[i+1:]), true
+}
@@ -1,29 +1,42 @@
 package auth
 
-// Database stores username and password to implement access control
+import "sync"
+
+// DefaultRealm is the realm of the stores that aren't given one.
+const DefaultRealm = "dorsvr streaming server"
+
+// Database stores username and password to implement access control.
+// It's a Store that is kept in memory.
 type Database struct {
-	Realm    string
-	username string
-	password string
-	records  map[string]string
+	realm   string
+	records map[string]string
+	mutex   sync.RWMutex
 }
 
 // NewAuthDatabase returns a pointer to a new instance of authorization database
 func NewAuthDatabase(realm string) *Database {
 	if realm == "" {
-		realm = "dorsvr streaming server"
+		realm = DefaultRealm
 	}
 	return &Database{
-		Realm: realm,
+		realm:   realm,
+		records: make(map[string]string),
 	}
 }
 
+// Realm returns the realm of the database.
+func (d *Database) Realm() string {
+	return d.realm
+}
+
 // InsertUserRecord inserts user record, it contains username and password fields
 func (d *Database) InsertUserRecord(username, password string) {
 	if username == "" || password == "" {
 		return
 	}
 
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
 	_, existed := d.records[username]
 	if !existed {
 		d.records[username] = password
@@ -32,6 +45,8 @@ func (d *Database) InsertUserRecord(username, password string) {
 
 // RemoveUserRecord removes user record
 func (d *Database) RemoveUserRecord(username string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
 	_, existed := d.records[username]
 	if existed {
 		delete(d.records, username)
@@ -40,6 +55,17 @@ func (d *Database) RemoveUserRecord(username string) {
 
 // LookupPassword lookups the password by username
 func (d *Database) LookupPassword(username string) (password string) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
 	password, _ = d.records[username]
 	return
 }
+
+// LookupHA1 implements Store.
+func (d *Database) LookupHA1(username, algorithm string) string {
+	password := d.LookupPassword(username)
+	if password == "" {
+		return ""
+	}
+	return ComputeHA1(algorithm, username, d.realm, password)
+}
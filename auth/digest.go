@@ -2,14 +2,21 @@ package auth
 
 import (
 	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"strings"
-	sys "syscall"
 )
 
-var counter = 0
+// The digest algorithms (RFC 7616) that we support.
+const (
+	AlgorithmMD5    = "MD5"
+	AlgorithmSHA256 = "SHA-256"
+)
 
 // Digest is a struct used for digest authentication.
 // The "realm", and "nonce" fields are supplied by the server
@@ -20,6 +27,13 @@ type Digest struct {
 	Nonce    string
 	Username string
 	Password string
+	// the algorithm, the "qop" and the "opaque" value of the server's challenge;
+	// no algorithm means MD5, and no "qop" means a RFC 2069 digest
+	Algorithm string
+	QOP       string
+	Opaque    string
+	// the number of requests that we have sent with the nonce
+	nonceCount uint32
 }
 
 // NewDigest returns a pointer to a new instance of authorization digest
@@ -27,95 +41,278 @@ func NewDigest() *Digest {
 	return &Digest{}
 }
 
-// RandomNonce returns a random nonce
+// RandomNonce sets a new nonce, from a cryptographically secure source
 func (d *Digest) RandomNonce() {
-	var timeNow sys.Timeval
-	sys.Gettimeofday(&timeNow)
-
-	counter++
-	seedData := fmt.Sprintf("%d.%06d%d", timeNow.Sec, timeNow.Usec, counter)
+	d.Nonce = randomHex(16)
+	d.nonceCount = 0
+}
 
-	// Use MD5 to compute a 'random' nonce from this seed data:
-	h := md5.New()
-	io.WriteString(h, seedData)
-	d.Nonce = hex.EncodeToString(h.Sum(nil))
+func randomHex(size int) string {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
 }
 
 // ComputeResponse represents generating the response using cmd and url value
 func (d *Digest) ComputeResponse(cmd, url string) string {
-	ha1Data := fmt.Sprintf("%s:%s:%s", d.Username, d.Realm, d.Password)
-	ha2Data := fmt.Sprintf("%s:%s", cmd, url)
+	return d.ComputeResponseHA1(HA1(d.Username, d.Realm, d.Password), cmd, url)
+}
+
+// ComputeResponseHA1 generates the response from the "HA1" of the user's credentials,
+// rather than from the password
+func (d *Digest) ComputeResponseHA1(ha1, cmd, url string) string {
+	return computeResponse(AlgorithmMD5, ha1, d.Nonce, "", "", "", cmd, url)
+}
+
+// SetChallenge takes the server's "Digest" challenge. The nonce count starts over
+// when the nonce is a new one.
+func (d *Digest) SetChallenge(challenge *Challenge) {
+	if challenge.Nonce != d.Nonce {
+		d.nonceCount = 0
+	}
+	d.Realm = challenge.Realm
+	d.Nonce = challenge.Nonce
+	d.Algorithm = challenge.Algorithm
+	d.Opaque = challenge.Opaque
+	d.QOP = ""
+	for _, qop := range challenge.QOP {
+		if qop == "auth" {
+			d.QOP = qop
+		}
+	}
+}
+
+// Authorization returns the value of the "Authorization:" header of a request that a client
+// sends: a RFC 7616 digest if the server asked for one, or else a RFC 2069 one.
+func (d *Digest) Authorization(cmd, url string) string {
+	algorithm := d.Algorithm
+	if algorithm == "" {
+		algorithm = AlgorithmMD5
+	}
+	ha1 := ComputeHA1(algorithm, d.Username, d.Realm, d.Password)
+
+	s := fmt.Sprintf("Digest username=%s, realm=%s, nonce=%s, uri=%s",
+		quote(d.Username), quote(d.Realm), quote(d.Nonce), quote(url))
+	if d.QOP == "" {
+		response := computeResponse(algorithm, ha1, d.Nonce, "", "", "", cmd, url)
+		s += fmt.Sprintf(", response=%s", quote(response))
+	} else {
+		d.nonceCount++
+		nc := fmt.Sprintf("%08x", d.nonceCount)
+		cnonce := randomHex(8)
+		response := computeResponse(algorithm, ha1, d.Nonce, nc, cnonce, d.QOP, cmd, url)
+		s += fmt.Sprintf(", qop=%s, nc=%s, cnonce=%s, response=%s", d.QOP, nc, quote(cnonce), quote(response))
+	}
+	if d.Algorithm != "" {
+		s += ", algorithm=" + d.Algorithm
+	}
+	if d.Opaque != "" {
+		s += ", opaque=" + quote(d.Opaque)
+	}
+	return s
+}
+
+// BasicAuthorization returns the value of a "Basic" "Authorization:" header.
+func (d *Digest) BasicAuthorization() string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(d.Username+":"+d.Password))
+}
+
+func newHash(algorithm string) hash.Hash {
+	if strings.EqualFold(algorithm, AlgorithmSHA256) {
+		return sha256.New()
+	}
+	return md5.New()
+}
 
-	h1 := md5.New()
-	h2 := md5.New()
-	io.WriteString(h1, ha1Data)
-	io.WriteString(h2, ha2Data)
+func hashHex(algorithm, data string) string {
+	h := newHash(algorithm)
+	io.WriteString(h, data)
+	return hex.EncodeToString(h.Sum(nil))
+}
 
-	digestData := fmt.Sprintf("%s:%s:%s", hex.EncodeToString(h1.Sum(nil)), d.Nonce, hex.EncodeToString(h2.Sum(nil)))
+// SupportedAlgorithm reports whether the algorithm of a challenge, or of a request, is one
+// that we can compute; no algorithm means MD5.
+func SupportedAlgorithm(algorithm string) bool {
+	return algorithm == "" || strings.EqualFold(algorithm, AlgorithmMD5) || strings.EqualFold(algorithm, AlgorithmSHA256)
+}
 
-	h3 := md5.New()
-	io.WriteString(h3, digestData)
+// ComputeHA1 computes the "HA1" of a user's credentials with a digest algorithm.
+func ComputeHA1(algorithm, username, realm, password string) string {
+	return hashHex(algorithm, username+":"+realm+":"+password)
+}
 
-	return hex.EncodeToString(h3.Sum(nil))
+// computeResponse computes the response of a digest; a "qop" of "" means a RFC 2069 one.
+func computeResponse(algorithm, ha1, nonce, nc, cnonce, qop, cmd, url string) string {
+	ha2 := hashHex(algorithm, cmd+":"+url)
+	if qop == "" {
+		return hashHex(algorithm, ha1+":"+nonce+":"+ha2)
+	}
+	return hashHex(algorithm, ha1+":"+nonce+":"+nc+":"+cnonce+":"+qop+":"+ha2)
 }
 
 // AuthorizationHeader is a struct stored the infomation of parsing "Authorization:" line
 type AuthorizationHeader struct {
-	URI      string
-	Realm    string
-	Nonce    string
-	Username string
-	Response string
+	URI       string
+	Realm     string
+	Nonce     string
+	Username  string
+	Response  string
+	Algorithm string
+	QOP       string
+	NC        string
+	CNonce    string
+	Opaque    string
 }
 
 // ParseAuthorizationHeader represents the parsing of "Authorization:" line,
-// Authorization Header contains uri, realm, nonce, Username, response fields
+// Authorization Header contains uri, realm, nonce, Username, response fields,
+// and the algorithm, qop, nc, cnonce and opaque fields of a RFC 7616 digest
 func ParseAuthorizationHeader(buf string) *AuthorizationHeader {
 	if buf == "" {
 		return nil
 	}
 
-	// First, find "Authorization:"
-	index := strings.Index(buf, "Authorization: Digest ")
-	if -1 == index {
+	// First, find "Authorization:", and its "Digest" scheme (both of any case)
+	value, found := "", false
+	for _, line := range strings.Split(buf, "\n") {
+		i := strings.IndexByte(line, ':')
+		if i != -1 && strings.EqualFold(strings.TrimSpace(line[:i]), "Authorization") {
+			value, found = strings.TrimSpace(line[i+1:]), true
+			break
+		}
+	}
+	const scheme = "Digest "
+	if !found || len(value) < len(scheme) || !strings.EqualFold(value[:len(scheme)], scheme) {
 		return nil
 	}
 
 	// Then, run through each of the fields, looking for ones we handle:
-	var n1, n2 int
-	var parameter, value, username, realm, nonce, uri, response string
-	fields := buf[index+22:]
-	for {
-		n1, _ = fmt.Sscanf(fields, "%[^=]=\"%[^\"]\"", &parameter, &value)
-		n2, _ = fmt.Sscanf(fields, "%[^=]=\"\"", &parameter)
-		if n1 != 2 && n2 != 1 {
-			break
+	params := parseParams(value[len(scheme):])
+	return &AuthorizationHeader{
+		URI:       params["uri"],
+		Realm:     params["realm"],
+		Nonce:     params["nonce"],
+		Username:  params["username"],
+		Response:  params["response"],
+		Algorithm: params["algorithm"],
+		QOP:       params["qop"],
+		NC:        params["nc"],
+		CNonce:    params["cnonce"],
+		Opaque:    params["opaque"],
+	}
+}
+
+// Challenge is a "WWW-Authenticate:" header of a server.
+type Challenge struct {
+	// "Digest" or "Basic"
+	Scheme    string
+	Realm     string
+	Nonce     string
+	Algorithm string
+	QOP       []string
+	Opaque    string
+	// the nonce of the previous request has expired, but its credentials were right
+	Stale bool
+}
+
+// ParseChallenge parses the value of a "WWW-Authenticate:" header.
+func ParseChallenge(value string) (*Challenge, error) {
+	value = strings.TrimSpace(value)
+	i := strings.IndexByte(value, ' ')
+	if i == -1 {
+		i = len(value)
+	}
+
+	challenge := &Challenge{Scheme: value[:i]}
+	params := parseParams(value[i:])
+	challenge.Realm = params["realm"]
+	challenge.Nonce = params["nonce"]
+	challenge.Algorithm = params["algorithm"]
+	challenge.Opaque = params["opaque"]
+	challenge.Stale = strings.EqualFold(params["stale"], "true")
+	for _, qop := range strings.Split(params["qop"], ",") {
+		if qop = strings.TrimSpace(qop); qop != "" {
+			challenge.QOP = append(challenge.QOP, qop)
 		}
-		if strings.EqualFold(parameter, "username") {
-			username = value
-		} else if strings.EqualFold(parameter, "realm") {
-			realm = value
-		} else if strings.EqualFold(parameter, "nonce") {
-			nonce = value
-		} else if strings.EqualFold(parameter, "uri") {
-			uri = value
-		} else if strings.EqualFold(parameter, "response") {
-			response = value
+	}
+
+	switch {
+	case strings.EqualFold(challenge.Scheme, "Digest"):
+		challenge.Scheme = "Digest"
+		if challenge.Nonce == "" {
+			return nil, fmt.Errorf("no nonce in the challenge %q", value)
 		}
-		fields = fields[len(parameter)+2+len(value)+1:]
-		for fields[0] == ' ' || fields[0] == ',' {
-			fields = fields[1:]
+	case strings.EqualFold(challenge.Scheme, "Basic"):
+		challenge.Scheme = "Basic"
+	default:
+		return nil, fmt.Errorf("unknown authentication scheme %q", challenge.Scheme)
+	}
+	return challenge, nil
+}
+
+// String formats the challenge as the value of a "WWW-Authenticate:" header. The realm and the
+// nonce come first, as the clients that only understand RFC 2069 expect them to.
+func (c *Challenge) String() string {
+	if c.Scheme == "Basic" {
+		return "Basic realm=" + quote(c.Realm)
+	}
+
+	s := fmt.Sprintf("Digest realm=%s, nonce=%s", quote(c.Realm), quote(c.Nonce))
+	if c.Algorithm != "" {
+		s += ", algorithm=" + c.Algorithm
+	}
+	if len(c.QOP) > 0 {
+		s += ", qop=" + quote(strings.Join(c.QOP, ","))
+	}
+	if c.Opaque != "" {
+		s += ", opaque=" + quote(c.Opaque)
+	}
+	if c.Stale {
+		s += ", stale=true"
+	}
+	return s
+}
+
+// parseParams parses the comma-separated "name=value" parameters of an authentication
+// header, whose values may be quoted strings. The names are returned in lower case.
+func parseParams(s string) map[string]string {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		i := strings.IndexByte(s, '=')
+		if i == -1 {
+			return params
 		}
-		if fields == "" || fields[0] == '\r' || fields[0] == '\n' {
-			break
+		name := strings.ToLower(strings.TrimSpace(s[:i]))
+		s = strings.TrimLeft(s[i+1:], " \t")
+
+		var value string
+		if strings.HasPrefix(s, "\"") {
+			var b strings.Builder
+			i = 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+			}
+			if i < len(s) {
+				i++ // the closing quote
+			}
+			value, s = b.String(), s[i:]
+		} else {
+			i = strings.IndexByte(s, ',')
+			if i == -1 {
+				i = len(s)
+			}
+			value, s = strings.TrimSpace(s[:i]), s[i:]
 		}
+		params[name] = value
 	}
+}
 
-	return &AuthorizationHeader{
-		URI:      uri,
-		Realm:    realm,
-		Nonce:    nonce,
-		Username: username,
-		Response: response,
-	}
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
 }
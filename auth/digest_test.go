@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComputeResponse(t *testing.T) {
+	// the examples of RFC 7616, section 3.9.1
+	ha1 := ComputeHA1(AlgorithmMD5, "Mufasa", "http-auth@example.org", "Circle of Life")
+	md5Response := computeResponse(AlgorithmMD5, ha1, "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", "00000001",
+		"f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ", "auth", "GET", "/dir/index.html")
+	ha1 = ComputeHA1(AlgorithmSHA256, "Mufasa", "http-auth@example.org", "Circle of Life")
+	sha256Response := computeResponse(AlgorithmSHA256, ha1, "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", "00000001",
+		"f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ", "auth", "GET", "/dir/index.html")
+
+	if md5Response == "8ca523f5e9506fed4657c9700eebdbec" &&
+		sha256Response == "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1" {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %s %s", md5Response, sha256Response)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	value := `Digest realm="dorsvr \"test\"", nonce="abc", algorithm=SHA-256, qop="auth,auth-int", stale=TRUE`
+	challenge, err := ParseChallenge(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if challenge.Scheme == "Digest" && challenge.Realm == `dorsvr "test"` && challenge.Nonce == "abc" &&
+		challenge.Algorithm == AlgorithmSHA256 && len(challenge.QOP) == 2 && challenge.Stale {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %+v", challenge)
+	}
+
+	if again, err := ParseChallenge(challenge.String()); err == nil && again.Realm == challenge.Realm {
+		t.Log("success")
+	} else {
+		t.Errorf("failed to parse %s", challenge.String())
+	}
+
+	if _, err := ParseChallenge("Digest realm=\"dorsvr\""); err == nil {
+		t.Error("failed: a challenge without a nonce was accepted")
+	}
+}
+
+func TestParseAuthorizationHeader(t *testing.T) {
+	request := "DESCRIBE rtsp://127.0.0.1/test.264 RTSP/1.0\r\n" +
+		"CSeq: 2\r\n" +
+		"authorization: DIGEST username=\"alice\", realm=\"dorsvr\", nonce=\"abc\", uri=\"/test.264\", " +
+		"response=\"0123\", qop=auth, nc=00000001, cnonce=\"xyz\"\r\n\r\n"
+	header := ParseAuthorizationHeader(request)
+	if header != nil && header.Username == "alice" && header.Nonce == "abc" && header.URI == "/test.264" &&
+		header.QOP == "auth" && header.NC == "00000001" && header.CNonce == "xyz" {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %+v", header)
+	}
+
+	if ParseAuthorizationHeader(`Authorization: Basic YWxpY2U6c2VjcmV0`) == nil &&
+		ParseAuthorizationHeader(`X-Authorization: Digest username="alice"`) == nil {
+		t.Log("success")
+	} else {
+		t.Error("failed: a header that isn't a digest was parsed")
+	}
+}
+
+// authenticate sends a request for rtsp://127.0.0.1/test.264 with a client's credentials to
+// an authenticator.
+func authenticate(a *Authenticator, header string) error {
+	return a.Authenticate("DESCRIBE", "rtsp://127.0.0.1/test.264", ParseAuthorizationHeader("Authorization: "+header))
+}
+
+func TestAuthenticator(t *testing.T) {
+	db := NewAuthDatabase("dorsvr")
+	db.InsertUserRecord("alice", "secret")
+	a := NewAuthenticator(db)
+
+	// (The challenges share a nonce, so every algorithm is tried with new ones.)
+	for i := range a.Challenges(false) {
+		challenge, err := ParseChallenge(a.Challenges(false)[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		client := &Digest{Username: "alice", Password: "secret"}
+		client.SetChallenge(challenge)
+		header := client.Authorization("DESCRIBE", "rtsp://127.0.0.1/test.264")
+		next := client.Authorization("DESCRIBE", "rtsp://127.0.0.1/test.264")
+		if authenticate(a, header) == nil && authenticate(a, next) == nil &&
+			authenticate(a, header) == ErrReplayed {
+			t.Logf("success: %s", challenge.Algorithm)
+		} else {
+			t.Errorf("failed: %s", header)
+		}
+
+		client.Password = "wrong"
+		if authenticate(a, client.Authorization("DESCRIBE", "rtsp://127.0.0.1/test.264")) == ErrUnauthorized {
+			t.Log("success")
+		} else {
+			t.Error("failed: a wrong password was accepted")
+		}
+	}
+
+	// An older client, that doesn't know "qop", is refused unless it's allowed:
+	challenge, _ := ParseChallenge(a.Challenges(false)[0])
+	client := &Digest{Username: "alice", Password: "secret", Realm: challenge.Realm, Nonce: challenge.Nonce}
+	legacy := client.Authorization("DESCRIBE", "rtsp://127.0.0.1/test.264")
+	refusedErr := authenticate(a, legacy)
+	a.AllowLegacyDigests = true
+	if refusedErr == ErrUnauthorized && authenticate(a, legacy) == nil {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %v", refusedErr)
+	}
+
+	// The digest has to be of the request's URL (or its path):
+	client.SetChallenge(challenge)
+	if authenticate(a, client.Authorization("DESCRIBE", "/test.264")) == nil &&
+		authenticate(a, client.Authorization("DESCRIBE", "rtsp://127.0.0.1/other.264")) == ErrUnauthorized &&
+		authenticate(a, client.Authorization("DESCRIBE", "/other.264")) == ErrUnauthorized {
+		t.Log("success")
+	} else {
+		t.Error("failed: a digest of another URL was accepted")
+	}
+
+	// An expired nonce is stale, and an unknown one is refused:
+	a.NonceLifetime = time.Millisecond
+	challenge, _ = ParseChallenge(a.Challenges(false)[0])
+	client.SetChallenge(challenge)
+	time.Sleep(5 * time.Millisecond)
+	staleErr := authenticate(a, client.Authorization("DESCRIBE", "rtsp://127.0.0.1/test.264"))
+	client.Nonce = "0123456789abcdef"
+	if staleErr == ErrStaleNonce &&
+		authenticate(a, client.Authorization("DESCRIBE", "rtsp://127.0.0.1/test.264")) == ErrUnauthorized {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %v", staleErr)
+	}
+}
+
+func TestAuthenticatorNonceCounts(t *testing.T) {
+	db := NewAuthDatabase("dorsvr")
+	db.InsertUserRecord("alice", "secret")
+	a := NewAuthenticator(db)
+
+	// The challenges themselves leave nothing behind:
+	for i := 0; i < 10000; i++ {
+		a.Challenges(false)
+	}
+	if len(a.nonceCounts) == 0 {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %d nonce counts", len(a.nonceCounts))
+	}
+
+	// The nonce counts of the authenticated nonces are bounded, and a nonce whose count was
+	// forgotten is stale rather than replayable:
+	var first *Digest
+	for i := 0; i <= maxNonceCounts; i++ {
+		challenge, _ := ParseChallenge(a.Challenges(false)[0])
+		client := &Digest{Username: "alice", Password: "secret"}
+		client.SetChallenge(challenge)
+		if err := authenticate(a, client.Authorization("DESCRIBE", "rtsp://127.0.0.1/test.264")); err != nil {
+			t.Fatal(err)
+		}
+		if first == nil {
+			first = client
+		}
+	}
+	if len(a.nonceCounts) <= maxNonceCounts &&
+		authenticate(a, first.Authorization("DESCRIBE", "rtsp://127.0.0.1/test.264")) == ErrStaleNonce {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %d nonce counts", len(a.nonceCounts))
+	}
+}
+
+func TestBasicAuthorization(t *testing.T) {
+	db := NewAuthDatabase("dorsvr")
+	db.InsertUserRecord("alice", "secret")
+	a := NewAuthenticator(db)
+
+	client := &Digest{Username: "alice", Password: "secret"}
+	username, password, ok := ParseBasicAuthorization(client.BasicAuthorization())
+	if ok && a.AuthenticateBasic(username, password) == nil &&
+		a.AuthenticateBasic("alice", "wrong") == ErrUnauthorized && a.AuthenticateBasic("bob", "") == ErrUnauthorized {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %q %q", username, password)
+	}
+
+	if _, _, ok := ParseBasicAuthorization(`Digest username="alice"`); !ok {
+		t.Log("success")
+	} else {
+		t.Error("failed: a digest was taken for Basic")
+	}
+}
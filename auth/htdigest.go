@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTDigestFile is a Store that is read from a file of Apache's htdigest format, whose lines are
+// "username:realm:HA1". So the file holds no passwords. Only the users of the store's realm are
+// read; the other lines are skipped.
+type HTDigestFile struct {
+	fileName string
+	realm    string
+	records  map[string]string
+	modTime  time.Time
+	size     int64
+	mutex    sync.RWMutex
+}
+
+// NewHTDigestFile reads a htdigest file. A realm of "" means the realm of the file's first user.
+func NewHTDigestFile(fileName, realm string) (*HTDigestFile, error) {
+	f := &HTDigestFile{
+		fileName: fileName,
+		realm:    realm,
+	}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Realm implements Store.
+func (f *HTDigestFile) Realm() string {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return f.realm
+}
+
+// LookupHA1 implements Store. A htdigest file has MD5 credentials only.
+func (f *HTDigestFile) LookupHA1(username, algorithm string) string {
+	if algorithm != AlgorithmMD5 {
+		return ""
+	}
+
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return f.records[username]
+}
+
+// Algorithms returns the digest algorithms that the file has credentials for.
+func (f *HTDigestFile) Algorithms() []string {
+	return []string{AlgorithmMD5}
+}
+
+// Reload reads the file again. If it fails, the users that were read before are kept.
+func (f *HTDigestFile) Reload() error {
+	file, err := os.Open(f.fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	realm := f.Realm()
+	records := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 || fields[0] == "" || len(fields[2]) != 32 {
+			return fmt.Errorf("%s:%d: not a htdigest line", f.fileName, lineNum)
+		}
+		if realm == "" {
+			realm = fields[1]
+		}
+		if fields[1] == realm {
+			records[fields[0]] = strings.ToLower(fields[2])
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	f.realm, f.records = realm, records
+	f.modTime, f.size = info.ModTime(), info.Size()
+	f.mutex.Unlock()
+	return nil
+}
+
+// Changed reports whether the file has been modified since it was last read.
+func (f *HTDigestFile) Changed() bool {
+	info, err := os.Stat(f.fileName)
+	if err != nil {
+		return false
+	}
+
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return !info.ModTime().Equal(f.modTime) || info.Size() != f.size
+}
+
+// Watch checks the file for changes every interval, and reloads it when it has changed,
+// until stop is closed. Errors are passed to onError, which may be nil.
+func (f *HTDigestFile) Watch(interval time.Duration, stop <-chan struct{}, onError func(error)) {
+	watch(f, interval, stop, onError)
+}
+
+// watch reloads a file whenever it has changed, every interval, until stop is closed.
+func watch(file interface {
+	Changed() bool
+	Reload() error
+}, interval time.Duration, stop <-chan struct{}, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !file.Changed() {
+				continue
+			}
+			if err := file.Reload(); err != nil && onError != nil {
+				onError(err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
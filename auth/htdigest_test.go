@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHTDigestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dorsvr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "users.htdigest")
+	content := "# users\n" +
+		"alice:dorsvr:" + HA1("alice", "dorsvr", "secret") + "\n" +
+		"bob:other:" + HA1("bob", "other", "secret") + "\n"
+	if err := ioutil.WriteFile(fileName, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewHTDigestFile(fileName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Realm() == "dorsvr" && store.LookupHA1("alice", AlgorithmMD5) == HA1("alice", "dorsvr", "secret") &&
+		store.LookupHA1("bob", AlgorithmMD5) == "" {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: realm %s, alice %s", store.Realm(), store.LookupHA1("alice", AlgorithmMD5))
+	}
+
+	// A changed file is read again; a broken one keeps the users that were read before:
+	time.Sleep(10 * time.Millisecond)
+	content = "carol:dorsvr:" + HA1("carol", "dorsvr", "password") + "\n"
+	if err := ioutil.WriteFile(fileName, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if store.Changed() && store.Reload() == nil && store.LookupHA1("alice", AlgorithmMD5) == "" && store.LookupHA1("carol", AlgorithmMD5) != "" {
+		t.Log("success")
+	} else {
+		t.Error("failed to reload the file")
+	}
+
+	ioutil.WriteFile(fileName, []byte("carol:dorsvr:password\n"), 0600)
+	if store.Reload() != nil && store.LookupHA1("carol", AlgorithmMD5) != "" {
+		t.Log("success")
+	} else {
+		t.Error("failed: a broken file was accepted")
+	}
+}
@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// HTPasswdFile is a Store that is read from a file of Apache's htpasswd format, whose lines are
+// "username:hash". Its hashes can check passwords, but not digests, so its users authenticate
+// with "Basic" only. The hashes may be bcrypt ("htpasswd -B"), Apache's MD5 ("$apr1$", which is
+// htpasswd's default) or SHA-1 ("{SHA}", "htpasswd -s").
+type HTPasswdFile struct {
+	fileName string
+	realm    string
+	records  map[string]string
+	modTime  time.Time
+	size     int64
+	mutex    sync.RWMutex
+}
+
+// NewHTPasswdFile reads a htpasswd file. The file has no realm, so realm is the one of the
+// challenges; "" means the default realm.
+func NewHTPasswdFile(fileName, realm string) (*HTPasswdFile, error) {
+	if realm == "" {
+		realm = DefaultRealm
+	}
+	f := &HTPasswdFile{
+		fileName: fileName,
+		realm:    realm,
+	}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Realm implements Store.
+func (f *HTPasswdFile) Realm() string {
+	return f.realm
+}
+
+// LookupHA1 implements Store. A htpasswd file has no digest credentials.
+func (f *HTPasswdFile) LookupHA1(username, algorithm string) string {
+	return ""
+}
+
+// Algorithms returns the digest algorithms that the file has credentials for: none.
+func (f *HTPasswdFile) Algorithms() []string {
+	return nil
+}
+
+// CheckPassword reports whether password is the password of a user.
+func (f *HTPasswdFile) CheckPassword(username, password string) bool {
+	f.mutex.RLock()
+	hash := f.records[username]
+	f.mutex.RUnlock()
+
+	switch {
+	case hash == "":
+		return false
+	case strings.HasPrefix(hash, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, apr1Magic):
+		salt := strings.SplitN(hash[len(apr1Magic):], "$", 2)[0]
+		return subtle.ConstantTimeCompare([]byte(apr1Crypt(password, salt)), []byte(hash)) == 1
+	default:
+		return subtle.ConstantTimeCompare([]byte(shaHash(password)), []byte(hash)) == 1
+	}
+}
+
+// Reload reads the file again. If it fails, the users that were read before are kept.
+func (f *HTPasswdFile) Reload() error {
+	file, err := os.Open(f.fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	records := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, ":", 2)
+		if len(fields) != 2 || fields[0] == "" {
+			return fmt.Errorf("%s:%d: not a htpasswd line", f.fileName, lineNum)
+		}
+		hash := fields[1]
+		if !strings.HasPrefix(hash, "$2a$") && !strings.HasPrefix(hash, "$2b$") &&
+			!strings.HasPrefix(hash, "$2y$") && !strings.HasPrefix(hash, apr1Magic) &&
+			!strings.HasPrefix(hash, "{SHA}") {
+			// (crypt(3) hashes are weak, and plain passwords are no hashes at all.)
+			return fmt.Errorf("%s:%d: unsupported password hash", f.fileName, lineNum)
+		}
+		records[fields[0]] = hash
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	f.records = records
+	f.modTime, f.size = info.ModTime(), info.Size()
+	f.mutex.Unlock()
+	return nil
+}
+
+// Changed reports whether the file has been modified since it was last read.
+func (f *HTPasswdFile) Changed() bool {
+	info, err := os.Stat(f.fileName)
+	if err != nil {
+		return false
+	}
+
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return !info.ModTime().Equal(f.modTime) || info.Size() != f.size
+}
+
+// Watch checks the file for changes every interval, and reloads it when it has changed,
+// until stop is closed. Errors are passed to onError, which may be nil.
+func (f *HTPasswdFile) Watch(interval time.Duration, stop <-chan struct{}, onError func(error)) {
+	watch(f, interval, stop, onError)
+}
+
+// shaHash returns the "{SHA}" hash of a password: its base64 SHA-1.
+func shaHash(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+const apr1Magic = "$apr1$"
+
+// apr1Crypt returns the "$apr1$" hash of a password, which is the MD5 crypt of FreeBSD
+// with Apache's magic string.
+func apr1Crypt(password, salt string) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+
+	alternate := md5.New()
+	alternate.Write([]byte(password + salt + password))
+	sum := alternate.Sum(nil)
+
+	digest := md5.New()
+	digest.Write([]byte(password + apr1Magic + salt))
+	for i := len(password); i > 0; i -= 16 {
+		if i > 16 {
+			digest.Write(sum)
+		} else {
+			digest.Write(sum[:i])
+		}
+	}
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			digest.Write([]byte{0})
+		} else {
+			digest.Write([]byte(password[:1]))
+		}
+	}
+	sum = digest.Sum(nil)
+
+	// It's made slow with 1000 more rounds:
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write([]byte(password))
+		} else {
+			round.Write(sum)
+		}
+		if i%3 != 0 {
+			round.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			round.Write([]byte(password))
+		}
+		if i&1 != 0 {
+			round.Write(sum)
+		} else {
+			round.Write([]byte(password))
+		}
+		sum = round.Sum(nil)
+	}
+
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	encoded := make([]byte, 0, 22)
+	encode := func(v uint, n int) {
+		for ; n > 0; n-- {
+			encoded = append(encoded, itoa64[v&0x3F])
+			v >>= 6
+		}
+	}
+	for _, i := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint(sum[i[0]])<<16|uint(sum[i[1]])<<8|uint(sum[i[2]]), 4)
+	}
+	encode(uint(sum[11]), 2)
+
+	return apr1Magic + salt + "$" + string(encoded)
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHTPasswdFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dorsvr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileName := filepath.Join(dir, "users.htpasswd")
+	content := "# users\n" +
+		"alice:" + string(bcryptHash) + "\n" +
+		// (made with "openssl passwd -apr1 -salt 8yCAs6mH secret")
+		"bob:$apr1$8yCAs6mH$2LmJZFbHczrMnrrhoJoof/\n" +
+		"carol:" + shaHash("secret") + "\n"
+	if err := ioutil.WriteFile(fileName, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewHTPasswdFile(fileName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator := NewAuthenticator(store)
+	if store.Realm() == DefaultRealm && store.LookupHA1("alice", AlgorithmMD5) == "" &&
+		len(authenticator.Challenges(false)) == 0 &&
+		authenticator.AuthenticateBasic("alice", "secret") == nil &&
+		authenticator.AuthenticateBasic("bob", "secret") == nil &&
+		authenticator.AuthenticateBasic("carol", "secret") == nil &&
+		authenticator.AuthenticateBasic("alice", "wrong") != nil &&
+		authenticator.AuthenticateBasic("bob", "wrong") != nil &&
+		authenticator.AuthenticateBasic("carol", "wrong") != nil &&
+		authenticator.AuthenticateBasic("dave", "secret") != nil {
+		t.Log("success")
+	} else {
+		t.Error("failed")
+	}
+
+	// Plain passwords aren't hashes; a broken file keeps the users that were read before:
+	ioutil.WriteFile(fileName, []byte("dave:secret\n"), 0600)
+	if store.Reload() != nil && authenticator.AuthenticateBasic("alice", "secret") == nil {
+		t.Log("success")
+	} else {
+		t.Error("failed: a broken file was accepted")
+	}
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"fmt"
+	"path"
+	"sync"
+)
+
+// Permission is something that a user may do with a stream.
+type Permission string
+
+// The permissions of the users.
+const (
+	// DESCRIBE, SETUP and PLAY a stream
+	PermissionPlay Permission = "play"
+	// ANNOUNCE and RECORD a stream
+	PermissionPublish Permission = "publish"
+)
+
+// Rule grants permissions on the streams whose names match a glob pattern (see path.Match),
+// e.g. "cameras/*".
+type Rule struct {
+	Streams     string       `json:"streams"`
+	Permissions []Permission `json:"permissions"`
+}
+
+// Authorizer decides what an authenticated user may do with a stream.
+type Authorizer interface {
+	Authorize(username, streamName string, permission Permission) bool
+}
+
+// Permissions is an Authorizer of roles: a role is a list of rules, and a user has the
+// permissions of its roles. The users that have no roles may do nothing.
+type Permissions struct {
+	roles     map[string][]Rule
+	userRoles map[string][]string
+	mutex     sync.RWMutex
+}
+
+// NewPermissions returns permissions with no roles.
+func NewPermissions() *Permissions {
+	return &Permissions{
+		roles:     make(map[string][]Rule),
+		userRoles: make(map[string][]string),
+	}
+}
+
+// AddRole adds a role, or replaces the rules of an existing one.
+func (p *Permissions) AddRole(name string, rules ...Rule) error {
+	for _, rule := range rules {
+		if _, err := path.Match(rule.Streams, ""); err != nil {
+			return fmt.Errorf("role %s: bad pattern %q: %v", name, rule.Streams, err)
+		}
+		for _, permission := range rule.Permissions {
+			if permission != PermissionPlay && permission != PermissionPublish {
+				return fmt.Errorf("role %s: unknown permission %q", name, permission)
+			}
+		}
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.roles[name] = rules
+	return nil
+}
+
+// AssignRoles sets the roles of a user.
+func (p *Permissions) AssignRoles(username string, roles ...string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.userRoles[username] = roles
+}
+
+// Authorize implements Authorizer. A role's rules are tried in order, and the first one whose
+// pattern matches the stream decides.
+func (p *Permissions) Authorize(username, streamName string, permission Permission) bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	for _, role := range p.userRoles[username] {
+		for _, rule := range p.roles[role] {
+			if matched, _ := path.Match(rule.Streams, streamName); !matched {
+				continue
+			}
+			if hasPermission(rule.Permissions, permission) {
+				return true
+			}
+			break
+		}
+	}
+	return false
+}
+
+func hasPermission(permissions []Permission, permission Permission) bool {
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
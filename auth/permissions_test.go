@@ -0,0 +1,34 @@
+package auth
+
+import "testing"
+
+func TestPermissions(t *testing.T) {
+	p := NewPermissions()
+	p.AddRole("viewer", Rule{Streams: "cameras/*", Permissions: []Permission{PermissionPlay}})
+	p.AddRole("ingest",
+		Rule{Streams: "cameras/private", Permissions: nil},
+		Rule{Streams: "cameras/*", Permissions: []Permission{PermissionPlay, PermissionPublish}})
+	p.AssignRoles("alice", "viewer")
+	p.AssignRoles("bob", "ingest")
+
+	if p.Authorize("alice", "cameras/door", PermissionPlay) && !p.Authorize("alice", "cameras/door", PermissionPublish) &&
+		!p.Authorize("alice", "other", PermissionPlay) && !p.Authorize("carol", "cameras/door", PermissionPlay) {
+		t.Log("success")
+	} else {
+		t.Error("failed to authorize alice")
+	}
+
+	// The first rule that matches decides:
+	if p.Authorize("bob", "cameras/door", PermissionPublish) && !p.Authorize("bob", "cameras/private", PermissionPlay) {
+		t.Log("success")
+	} else {
+		t.Error("failed to authorize bob")
+	}
+
+	if p.AddRole("bad", Rule{Streams: "*", Permissions: []Permission{"delete"}}) != nil &&
+		p.AddRole("bad", Rule{Streams: "[", Permissions: []Permission{PermissionPlay}}) != nil {
+		t.Log("success")
+	} else {
+		t.Error("failed: a bad role was accepted")
+	}
+}
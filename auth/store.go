@@ -0,0 +1,27 @@
+package auth
+
+// Store holds the users that may authenticate, and their credentials.
+type Store interface {
+	// Realm returns the realm that the credentials belong to.
+	Realm() string
+	// LookupHA1 returns the digest "HA1" of a user, i.e. the hash of "username:realm:password"
+	// with the digest algorithm (AlgorithmMD5 or AlgorithmSHA256). It returns "" if there is no
+	// such user, or if the store doesn't have the user's credentials for the algorithm.
+	LookupHA1(username, algorithm string) string
+}
+
+// algorithmStore is a Store that has the users' credentials for some digest algorithms only.
+type algorithmStore interface {
+	Algorithms() []string
+}
+
+// passwordStore is a Store that checks the passwords of "Basic" authentication itself, since
+// it has hashes of them rather than digest credentials.
+type passwordStore interface {
+	CheckPassword(username, password string) bool
+}
+
+// HA1 computes the MD5 "HA1" of a user's credentials, as a htdigest file holds it.
+func HA1(username, realm, password string) string {
+	return ComputeHA1(AlgorithmMD5, username, realm, password)
+}
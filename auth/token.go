@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The query parameters of a signed URL.
+const (
+	TokenParam    = "token"
+	ExpiresParam  = "expires"
+	ClientIPParam = "ip"
+)
+
+// The reasons why a signed URL is turned down.
+var (
+	ErrBadToken     = errors.New("auth: bad token")
+	ErrTokenExpired = errors.New("auth: expired token")
+	// the token was signed for another client
+	ErrTokenAddress = errors.New("auth: token of another client address")
+)
+
+// URLSigner makes and checks signed URLs, which give access to a stream until they expire,
+// without a password. The token of a URL is the HMAC-SHA256 of the stream name, the expiry
+// and the client address (if the URL is for one client only).
+type URLSigner struct {
+	key []byte
+}
+
+// NewURLSigner returns a signer with a secret key, which the server and whoever hands out
+// the URLs share.
+func NewURLSigner(key []byte) *URLSigner {
+	return &URLSigner{key: key}
+}
+
+// SignURL adds a token to a RTSP URL, e.g. "rtsp://example.com:8554/live/cam1", that is valid
+// until expires. A clientIP of "" makes a URL that any client may use.
+func (s *URLSigner) SignURL(rawURL string, expires time.Time, clientIP string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if clientIP != "" && net.ParseIP(clientIP) == nil {
+		return "", fmt.Errorf("bad client address %q", clientIP)
+	}
+
+	query := u.Query()
+	query.Set(ExpiresParam, strconv.FormatInt(expires.Unix(), 10))
+	if clientIP != "" {
+		query.Set(ClientIPParam, clientIP)
+	} else {
+		query.Del(ClientIPParam)
+	}
+	query.Set(TokenParam, s.token(strings.Trim(u.Path, "/"), query.Get(ExpiresParam), clientIP))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// Verify checks the token of the query of a request for the stream streamName, from the
+// client at clientIP, and returns when it expires.
+func (s *URLSigner) Verify(streamName string, query url.Values, clientIP net.IP) (time.Time, error) {
+	expires, err := strconv.ParseInt(query.Get(ExpiresParam), 10, 64)
+	if err != nil {
+		return time.Time{}, ErrBadToken
+	}
+	ip := query.Get(ClientIPParam)
+
+	token := s.token(streamName, query.Get(ExpiresParam), ip)
+	if !hmac.Equal([]byte(token), []byte(strings.ToLower(query.Get(TokenParam)))) {
+		return time.Time{}, ErrBadToken
+	}
+	if time.Now().Unix() > expires {
+		return time.Time{}, ErrTokenExpired
+	}
+	if ip != "" && !net.ParseIP(ip).Equal(clientIP) {
+		return time.Time{}, ErrTokenAddress
+	}
+	return time.Unix(expires, 0), nil
+}
+
+func (s *URLSigner) token(streamName, expires, clientIP string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(streamName + "\n" + expires + "\n" + clientIP))
+	return hex.EncodeToString(mac.Sum(nil))
+}
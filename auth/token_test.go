@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestURLSigner(t *testing.T) {
+	signer := NewURLSigner([]byte("secret"))
+	clientIP := net.ParseIP("192.168.1.10")
+
+	verify := func(rawURL, streamName string, ip net.IP) error {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = signer.Verify(streamName, u.Query(), ip)
+		return err
+	}
+
+	signed, err := signer.SignURL("rtsp://127.0.0.1:8554/live/cam1", time.Now().Add(time.Minute), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verify(signed, "live/cam1", clientIP) == nil && verify(signed, "live/cam2", clientIP) == ErrBadToken {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %s", signed)
+	}
+
+	// A token for one client, and an expired one:
+	signed, _ = signer.SignURL("rtsp://127.0.0.1:8554/live/cam1", time.Now().Add(time.Minute), "192.168.1.10")
+	expired, _ := signer.SignURL("rtsp://127.0.0.1:8554/live/cam1", time.Now().Add(-time.Minute), "")
+	if verify(signed, "live/cam1", clientIP) == nil &&
+		verify(signed, "live/cam1", net.ParseIP("192.168.1.11")) == ErrTokenAddress &&
+		verify(expired, "live/cam1", clientIP) == ErrTokenExpired {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %s", signed)
+	}
+
+	// Another key's token isn't ours:
+	other, _ := NewURLSigner([]byte("other")).SignURL("rtsp://127.0.0.1:8554/live/cam1", time.Now().Add(time.Minute), "")
+	if verify(other, "live/cam1", clientIP) == ErrBadToken {
+		t.Log("success")
+	} else {
+		t.Error("failed: the token of another key was accepted")
+	}
+}
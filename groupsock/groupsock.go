@@ -1,19 +1,21 @@
 package groupsock
 
 import (
-	"fmt"
 	"net"
 	"strconv"
-	"strings"
+	"sync"
 )
 
 // GroupSock is used to both send and receive packets.
 // As the name suggests, it was originally designed to send/receive
 // multicast, but it can send/receive unicast as well.
 type GroupSock struct {
-	portNum uint
-	udpConn *net.UDPConn
-	dests   []*destRecord
+	portNum   uint
+	udpConn   *net.UDPConn
+	dests     []*destRecord
+	groupAddr net.IP // the multicast group, if the groupsock has joined one
+	// guards dests, which change while a stream that several clients share is being sent
+	destsMutex sync.Mutex
 }
 
 // NewGroupSock returns a source-independent multicast group
@@ -30,9 +32,13 @@ func NewGroupSock(addrStr string, portNum uint) *GroupSock {
 
 // Output does the datagram send, to each destination.
 func (g *GroupSock) Output(buffer []byte, bufferSize uint) bool {
+	g.destsMutex.Lock()
+	dests := g.dests
+	g.destsMutex.Unlock()
+
 	var err error
 	var writeSuccess bool
-	for _, dest := range g.dests {
+	for _, dest := range dests {
 		if _, err = g.write(dest.addrStr, dest.portNum, buffer, bufferSize); err == nil {
 			writeSuccess = true
 		}
@@ -41,7 +47,7 @@ func (g *GroupSock) Output(buffer []byte, bufferSize uint) bool {
 }
 
 func (g *GroupSock) write(destAddr string, portNum uint, buffer []byte, bufferSize uint) (int, error) {
-	addr := fmt.Sprintf("%s:%d", destAddr, portNum)
+	addr := net.JoinHostPort(destAddr, strconv.Itoa(int(portNum)))
 	udpAddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
 		return 0, err
@@ -68,10 +74,8 @@ func (g *GroupSock) HandleRead(buffer []byte) (int, error) {
 // GetSourcePort returns the source port of system allocation.
 func (g *GroupSock) GetSourcePort() uint {
 	if g.udpConn != nil {
-		localAddr := strings.Split(g.udpConn.LocalAddr().String(), ":")
-		sourcePort, err := strconv.Atoi(localAddr[len(localAddr)-1])
-		if err == nil {
-			return uint(sourcePort)
+		if localAddr, ok := g.udpConn.LocalAddr().(*net.UDPAddr); ok {
+			return uint(localAddr.Port)
 		}
 	}
 	return 0
@@ -80,11 +84,40 @@ func (g *GroupSock) GetSourcePort() uint {
 // AddDestination can add multiple destinations (addresses & ports)
 // This can be used to implement multi-unicast.
 func (g *GroupSock) AddDestination(addr string, port uint) {
-	g.dests = append(g.dests, newDestRecord(addr, port))
+	g.destsMutex.Lock()
+	defer g.destsMutex.Unlock()
+
+	for _, dest := range g.dests {
+		if dest.addrStr == addr && dest.portNum == port {
+			// we already send there
+			return
+		}
+	}
+	// (Output may be going through the old slice, so it's never changed in place.)
+	dests := make([]*destRecord, len(g.dests), len(g.dests)+1)
+	copy(dests, g.dests)
+	g.dests = append(dests, newDestRecord(addr, port))
+}
+
+// DelDestination stops sending to a destination that was added with AddDestination.
+func (g *GroupSock) DelDestination(addr string, port uint) {
+	g.destsMutex.Lock()
+	defer g.destsMutex.Unlock()
+
+	dests := make([]*destRecord, 0, len(g.dests))
+	for _, dest := range g.dests {
+		if dest.addrStr != addr || dest.portNum != port {
+			dests = append(dests, dest)
+		}
+	}
+	g.dests = dests
 }
 
-// DelDestination can remove the destinations.
-func (g *GroupSock) DelDestination() {
+// NumDestinations returns the number of destinations that the groupsock sends to.
+func (g *GroupSock) NumDestinations() int {
+	g.destsMutex.Lock()
+	defer g.destsMutex.Unlock()
+	return len(g.dests)
 }
 
 type destRecord struct {
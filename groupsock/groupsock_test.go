@@ -0,0 +1,90 @@
+package groupsock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGroupSockIPv6(t *testing.T) {
+	// An empty address listens on IPv4 and IPv6 both:
+	receiver := NewGroupSock("", 0)
+	if receiver == nil {
+		t.Fatal("failed")
+	}
+	defer receiver.Close()
+
+	sender := NewGroupSock("", 0)
+	if sender == nil {
+		t.Fatal("failed")
+	}
+	defer sender.Close()
+	sender.AddDestination("::1", receiver.GetSourcePort())
+	sender.AddDestination("127.0.0.1", receiver.GetSourcePort())
+
+	if !sender.Output([]byte("packet"), 6) {
+		t.Skip("no IPv6 loopback")
+	}
+	receiver.udpConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buffer := make([]byte, 16)
+	for i := 0; i < 2; i++ {
+		if n, err := receiver.HandleRead(buffer); err != nil || string(buffer[:n]) != "packet" {
+			t.Fatalf("failed: %v", err)
+		}
+	}
+	t.Log("success")
+}
+
+func TestMulticastGroupSock(t *testing.T) {
+	// The sender and the receiver share the group's port:
+	sender, err := NewMulticastGroupSock("232.1.2.3", 15004, 1)
+	if err != nil {
+		t.Skipf("no multicast: %v", err)
+	}
+	defer sender.Close()
+	receiver, err := NewMulticastGroupSock("232.1.2.3", 15004, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Close()
+
+	if !sender.IsMulticast() || sender.GroupAddress() != "232.1.2.3" || !sender.Output([]byte("packet"), 6) {
+		t.Fatal("failed")
+	}
+	receiver.udpConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buffer := make([]byte, 16)
+	if n, err := receiver.HandleRead(buffer); err == nil && string(buffer[:n]) == "packet" {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %v", err)
+	}
+
+	if _, err := NewMulticastGroupSock("192.168.1.1", 15004, 1); err != nil {
+		t.Log("success")
+	} else {
+		t.Error("failed")
+	}
+}
+
+func TestDelDestination(t *testing.T) {
+	g := NewGroupSock("", 0)
+	if g == nil {
+		t.Fatal("failed")
+	}
+	defer g.Close()
+
+	g.AddDestination("127.0.0.1", 40000)
+	g.AddDestination("127.0.0.1", 40002)
+	g.AddDestination("127.0.0.1", 40000)
+	if g.NumDestinations() != 2 {
+		t.Error("failed")
+		return
+	}
+
+	g.DelDestination("127.0.0.1", 40000)
+	g.DelDestination("127.0.0.1", 40004)
+	if g.NumDestinations() == 1 && g.dests[0].portNum == 40002 {
+		t.Log("success")
+	} else {
+		t.Error("failed")
+	}
+}
@@ -3,11 +3,13 @@ package groupsock
 import (
 	"fmt"
 	"net"
+	"strconv"
 )
 
 // SetupDatagramSocket returns a udp connection of Listening to the specified port.
+// An empty address listens on every address, of both IPv4 and IPv6.
 func SetupDatagramSocket(address string, port uint) *net.UDPConn {
-	addr := fmt.Sprintf("%s:%d", address, port)
+	addr := net.JoinHostPort(address, strconv.Itoa(int(port)))
 	udpAddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
 		fmt.Println("Failed to resolve UDP address.", err)
@@ -37,7 +39,7 @@ func SetupDatagramSocket(address string, port uint) *net.UDPConn {
 }
 
 func setupStreamSocket(address string, port uint) *net.TCPConn {
-	addr := fmt.Sprintf("%s:%d", address, port)
+	addr := net.JoinHostPort(address, strconv.Itoa(int(port)))
 	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
 	if err != nil {
 		fmt.Println("Failed to resolve TCP address.", err)
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"math/rand"
 	"net"
+	"strings"
 	"time"
 )
 
@@ -40,6 +41,8 @@ func Ntohl(packet []byte) (uint32, error) {
 	return value, nil
 }
 
+// OurIPAddress returns an IPv4 address of this host, or on IPv6-only networks,
+// a global IPv6 address.
 func OurIPAddress() (string, error) {
 	addrs, err := net.InterfaceAddrs()
 	if err != nil {
@@ -47,15 +50,27 @@ func OurIPAddress() (string, error) {
 		return "", err
 	}
 
-	var ip string
+	var ip, ipv6 string
 	err = errors.New("ip address not found")
 	for _, address := range addrs {
 		if ipnet, ok := address.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
 			if ipnet.IP.To4() != nil {
 				ip, err = ipnet.IP.String(), nil
 				//break
+			} else if ipv6 == "" && ipnet.IP.IsGlobalUnicast() {
+				ipv6 = ipnet.IP.String()
 			}
 		}
 	}
+	if ip == "" && ipv6 != "" {
+		ip, err = ipv6, nil
+	}
 	return ip, err
 }
+
+// IsIPv6 reports whether an address is an IPv6 address (and not an IPv4 one),
+// e.g. "2001:db8::1" or "fe80::1%eth0".
+func IsIPv6(addr string) bool {
+	ip := net.ParseIP(strings.SplitN(addr, "%", 2)[0])
+	return ip != nil && ip.To4() == nil
+}
@@ -32,3 +32,11 @@ func TestOurIPAddress(t *testing.T) {
 		t.Error("failed")
 	}
 }
+
+func TestIsIPv6(t *testing.T) {
+	if IsIPv6("::1") && IsIPv6("fe80::1%eth0") && !IsIPv6("127.0.0.1") && !IsIPv6("example.com") {
+		t.Log("success")
+	} else {
+		t.Error("failed")
+	}
+}
@@ -0,0 +1,109 @@
+package groupsock
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+)
+
+// NewMulticastGroupSock returns a groupsock that sends to a multicast group, and receives
+// what is sent to it: the group is joined, and the packets go ttl hops (for IPv6, as the
+// hop limit). Other sockets of this host may use the same group and port.
+func NewMulticastGroupSock(groupAddr string, portNum, ttl uint) (*GroupSock, error) {
+	group := net.ParseIP(groupAddr)
+	if group == nil || !group.IsMulticast() {
+		return nil, fmt.Errorf("%q isn't a multicast address", groupAddr)
+	}
+
+	network, addr := "udp4", net.JoinHostPort("0.0.0.0", strconv.Itoa(int(portNum)))
+	if group.To4() == nil {
+		network, addr = "udp6", net.JoinHostPort("::", strconv.Itoa(int(portNum)))
+	}
+	config := &net.ListenConfig{Control: reuseAddress}
+	conn, err := config.ListenPacket(context.Background(), network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &GroupSock{
+		portNum:   portNum,
+		udpConn:   conn.(*net.UDPConn),
+		groupAddr: group,
+	}
+	if err = g.setMulticastOptions(ttl); err != nil {
+		g.Close()
+		return nil, err
+	}
+	g.AddDestination(groupAddr, portNum)
+	return g, nil
+}
+
+// IsMulticast reports whether the groupsock belongs to a multicast group.
+func (g *GroupSock) IsMulticast() bool {
+	return g.groupAddr != nil
+}
+
+// GroupAddress returns the multicast group of the groupsock, or "" if it has none.
+func (g *GroupSock) GroupAddress() string {
+	if g.groupAddr == nil {
+		return ""
+	}
+	return g.groupAddr.String()
+}
+
+// IsMulticastAddress reports whether an address is an IPv4 or IPv6 multicast address.
+func IsMulticastAddress(addr string) bool {
+	ip := net.ParseIP(addr)
+	return ip != nil && ip.IsMulticast()
+}
+
+// setMulticastOptions joins the group, with the default interface, and sets the TTL of the
+// packets that we send to it; they're looped back, so that receivers on this host get them too.
+func (g *GroupSock) setMulticastOptions(ttl uint) error {
+	rawConn, err := g.udpConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		if group4 := g.groupAddr.To4(); group4 != nil {
+			mreq := &syscall.IPMreq{}
+			copy(mreq.Multiaddr[:], group4)
+			if sockErr = syscall.SetsockoptIPMreq(int(fd), syscall.IPPROTO_IP, syscall.IP_ADD_MEMBERSHIP, mreq); sockErr != nil {
+				return
+			}
+			if sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, int(ttl)); sockErr != nil {
+				return
+			}
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP, 1)
+		} else {
+			mreq := &syscall.IPv6Mreq{}
+			copy(mreq.Multiaddr[:], g.groupAddr)
+			if sockErr = syscall.SetsockoptIPv6Mreq(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_JOIN_GROUP, mreq); sockErr != nil {
+				return
+			}
+			if sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS, int(ttl)); sockErr != nil {
+				return
+			}
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_LOOP, 1)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+func reuseAddress(network, address string, rawConn syscall.RawConn) error {
+	var sockErr error
+	err := rawConn.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -79,3 +79,49 @@ func TestParseRTSPRequestString(t *testing.T) {
 		t.Error("failed")
 	}
 }
+
+func TestParseHTTPRequestString(t *testing.T) {
+	getRequest := "GET /test.264 HTTP/1.0\r\n" +
+		"User-Agent: QuickTime/7.6.9\r\n" +
+		"x-sessioncookie: 5LwNsEtqA4HgjjGzqDzxvw\r\n" +
+		"Accept: application/x-rtsp-tunnelled\r\n" +
+		"Pragma: no-cache\r\n\r\n"
+
+	if _, ok := ParseRTSPRequestString(getRequest, len(getRequest)); ok {
+		t.Error("failed")
+		return
+	}
+
+	reqStr, ok := ParseHTTPRequestString(getRequest, len(getRequest))
+	if ok && reqStr.CmdName == "GET" && reqStr.UrlSuffix == "test.264" &&
+		reqStr.SessionCookie == "5LwNsEtqA4HgjjGzqDzxvw" &&
+		reqStr.AcceptStr == "application/x-rtsp-tunnelled" {
+		t.Log("success")
+	} else {
+		t.Error("failed")
+	}
+}
+
+func TestParseTransportHeader(t *testing.T) {
+	header := ParseTransportHeader(setupRequest)
+	if header.StreamingMode != RTP_UDP || header.ClientRTPPortNum != 37175 || header.ClientRTCPPortNum != 37176 ||
+		header.Mode != "PLAY" {
+		t.Error("failed")
+		return
+	}
+
+	header = ParseTransportHeader("transport: RTP/AVP/TCP;unicast;interleaved=2-3;mode=record\r\n\r\n")
+	if header.StreamingMode == RTP_TCP && header.RTPChannelID == 2 && header.RTCPChannelID == 3 &&
+		header.Mode == "RECORD" {
+		t.Log("success")
+	} else {
+		t.Error("failed")
+	}
+
+	rangeHeader, ok := ParseRangeHeader(playRequest)
+	if ok && rangeHeader.RangeStart == 0 && rangeHeader.RangeEnd == 0 {
+		t.Log("success")
+	} else {
+		t.Error("failed")
+	}
+}
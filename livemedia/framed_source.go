@@ -89,3 +89,36 @@ func (f *FramedSource) isAwaitingData() bool {
 // default implementation: do nothing
 func (f *FramedSource) destroy() {
 }
+
+// waitingSource is a source whose frames are pushed to it from elsewhere, so that it may wait
+// for them. A sink can't be stopped while its goroutine waits for a frame, so the stream wakes
+// the source first: wakeUp makes it give up its wait (or its next one) without a frame, and
+// clearWakeUp takes back a wake up that wasn't needed.
+type waitingSource interface {
+	wakeUp()
+	clearWakeUp()
+}
+
+// sourceWaker implements waitingSource for the sources that embed it, which give up waiting
+// when wake has something.
+type sourceWaker struct {
+	wake chan struct{}
+}
+
+func newSourceWaker() sourceWaker {
+	return sourceWaker{wake: make(chan struct{}, 1)}
+}
+
+func (w *sourceWaker) wakeUp() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *sourceWaker) clearWakeUp() {
+	select {
+	case <-w.wake:
+	default:
+	}
+}
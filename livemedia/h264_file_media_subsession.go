@@ -46,7 +46,7 @@ func (s *H264FileMediaSubsession) getAuxSDPLine(rtpSink IMediaSink, inputSource
 		s.dummyRTPSink = rtpSink
 
 		// start reading the file
-		go s.dummyRTPSink.StartPlaying(inputSource, s.afterPlayingDummy)
+		s.dummyRTPSink.startPlayingInBackground(inputSource, s.afterPlayingDummy)
 
 		s.checkForAuxSDPLine()
 	}
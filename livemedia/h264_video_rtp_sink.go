@@ -41,14 +41,20 @@ func (s *H264VideoRTPSink) ContinuePlaying() {
 	s.multiFramedPlaying()
 }
 
+// AuxSDPLine returns the "a=fmtp:" line of the stream, with the parameter sets that its
+// source has read so far.
 func (s *H264VideoRTPSink) AuxSDPLine() string {
+	// (The source is read by the goroutine that plays the sink.)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	if len(s.sps) == 0 || len(s.pps) == 0 {
 		if s.ourFragmenter == nil {
 			return ""
 		}
 
-		framerSource := s.ourFragmenter.inputSource.(*H264VideoStreamFramer)
-		if framerSource == nil {
+		framerSource, ok := s.ourFragmenter.inputSource.(*H264VideoStreamFramer)
+		if !ok {
 			return ""
 		}
 
@@ -58,25 +64,42 @@ func (s *H264VideoRTPSink) AuxSDPLine() string {
 		}
 	}
 
-	spsBase64 := base64.StdEncoding.EncodeToString(s.sps)
-	ppsBase64 := base64.StdEncoding.EncodeToString(s.pps)
+	return h264AuxSDPLine(s._rtpPayloadType, s.sps[:s.spsSize], s.pps[:s.ppsSize])
+}
+
+// h264AuxSDPLine returns the "a=fmtp:" line of a H.264 stream with its parameter sets; without
+// them, the line has the packetization mode only.
+func h264AuxSDPLine(rtpPayloadType uint32, sps, pps []byte) string {
+	if len(sps) == 0 || len(pps) == 0 {
+		return fmt.Sprintf("a=fmtp:%d packetization-mode=1\r\n", rtpPayloadType)
+	}
+
+	spsBase64 := base64.StdEncoding.EncodeToString(sps)
+	ppsBase64 := base64.StdEncoding.EncodeToString(pps)
 
 	var profileLevelID uint32
-	if s.spsSize >= 4 {
-		profileLevelID = Uint32(s.sps[1:4])
+	if len(sps) >= 4 {
+		profileLevelID = Uint32(sps[1:4])
 	}
 
 	return fmt.Sprintf("a=fmtp:%d packetization-mode=1;profile-level-id=%06X;sprop-parameter-sets=%s,%s\r\n",
-		s._rtpPayloadType, profileLevelID, spsBase64, ppsBase64)
+		rtpPayloadType, profileLevelID, spsBase64, ppsBase64)
 }
 
 func (s *H264VideoRTPSink) doSpecialFrameHandling(fragmentationOffset, numBytesInFrame, numRemainingBytes uint,
 	frameStart []byte, framePresentationTime sys.Timeval) {
-	if s.ourFragmenter != nil {
-		framerSource := s.ourFragmenter.inputSource.(*H264VideoStreamFramer)
-		if s.ourFragmenter.lastFragmentCompletedNALUnit && framerSource != nil && framerSource.pictureEndMarker {
-			s.setMarkerBit()
-			framerSource.pictureEndMarker = false
+	if s.ourFragmenter != nil && s.ourFragmenter.lastFragmentCompletedNALUnit {
+		switch source := s.ourFragmenter.inputSource.(type) {
+		case *H264VideoStreamFramer:
+			if source.pictureEndMarker {
+				s.setMarkerBit()
+				source.pictureEndMarker = false
+			}
+		case *liveH264Reader:
+			if source.pictureEndMarker {
+				s.setMarkerBit()
+				source.pictureEndMarker = false
+			}
 		}
 	}
 	s.setTimestamp(framePresentationTime)
@@ -4,9 +4,7 @@ import gs "github.com/djwackey/dorsvr/groupsock"
 
 type H264VideoRTPSource struct {
 	MultiFramedRTPSource
-	curPacketNALUnitType        uint
-	currentPacketBeginsFrame    bool
-	currentPacketCompletesFrame bool
+	curPacketNALUnitType uint
 }
 
 func newH264VideoRTPSource(RTPgs *gs.GroupSock,
@@ -31,8 +29,12 @@ func (s *H264VideoRTPSource) processSpecialHeader(packet IBufferedPacket) (
 	switch s.curPacketNALUnitType {
 	case 24: // STAP-A
 		expectedHeaderSize = 1
+		s.currentPacketBeginsFrame = true
+		s.currentPacketCompletesFrame = true
 	case 25, 26, 27: // STAP-B, MTAP16, or MTAP24
 		expectedHeaderSize = 3
+		s.currentPacketBeginsFrame = true
+		s.currentPacketCompletesFrame = true
 	case 28, 29: // FU-A or FU-B
 		startBit := (headerStart[1] & 0x80) != 0
 		endBit := headerStart[1] & 0x40
@@ -93,7 +95,7 @@ func newH264BufferedPacket(source *H264VideoRTPSource) *H264BufferedPacket {
 }
 
 func (p *H264BufferedPacket) nextEnclosedFrameSize(buff []byte, size uint32) uint32 {
-	framePtr, dataSize := buff[p.head:], p.tail-p.head
+	framePtr, dataSize := buff, size
 
 	var resultNALUSize, frameSize uint32
 
@@ -102,21 +104,24 @@ func (p *H264BufferedPacket) nextEnclosedFrameSize(buff []byte, size uint32) uin
 		// The first two bytes are NALU size:
 		if dataSize >= 2 {
 			resultNALUSize = (uint32(framePtr[0]) << 8) | uint32(framePtr[1])
-			framePtr = framePtr[2:]
+			p.skip(2)
+			dataSize -= 2
 		}
 	case 26: // MTAP16
 		// The first two bytes are NALU size.
 		// The next three are the DOND and TS offset:
 		if dataSize >= 5 {
 			resultNALUSize = (uint32(framePtr[0]) << 8) | uint32(framePtr[1])
-			framePtr = framePtr[5:]
+			p.skip(5)
+			dataSize -= 5
 		}
 	case 27: // MTAP24
 		// The first two bytes are NALU size.
 		// The next four are the DOND and TS offset:
 		if dataSize >= 6 {
 			resultNALUSize = (uint32(framePtr[0]) << 8) | uint32(framePtr[1])
-			framePtr = framePtr[6:]
+			p.skip(6)
+			dataSize -= 6
 		}
 	default:
 		// Common case: We use the entire packet data:
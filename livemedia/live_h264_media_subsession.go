@@ -0,0 +1,51 @@
+package livemedia
+
+import (
+	"time"
+
+	gs "github.com/djwackey/dorsvr/groupsock"
+)
+
+// how long a description waits for the first parameter sets of a live source
+const liveParameterSetsTimeout = 2 * time.Second
+
+// LiveH264MediaSubsession is a track that streams a LiveH264Source. Its clients share the
+// stream, which they get from the next key frame on.
+type LiveH264MediaSubsession struct {
+	OnDemandServerMediaSubsession
+	source *LiveH264Source
+}
+
+// NewLiveH264MediaSubsession creates a track of a live source.
+func NewLiveH264MediaSubsession(source *LiveH264Source) *LiveH264MediaSubsession {
+	subsession := &LiveH264MediaSubsession{source: source}
+	subsession.initOnDemandServerMediaSubsession(subsession)
+	subsession.reuseFirstSource = true
+	return subsession
+}
+
+// SDPLines returns the SDP lines of the track. Until the source has written its parameter
+// sets, they're left out, and the lines are made again for the next client.
+func (s *LiveH264MediaSubsession) SDPLines() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sdpLines := s.makeSDPLines()
+	if sps, pps := s.source.parameterSets(0); sps == nil || pps == nil {
+		s.sdpLines = ""
+	}
+	return sdpLines
+}
+
+func (s *LiveH264MediaSubsession) createNewStreamSource() IFramedSource {
+	return s.source.newReader()
+}
+
+func (s *LiveH264MediaSubsession) createNewRTPSink(rtpGroupSock *gs.GroupSock, rtpPayloadType uint) IMediaSink {
+	return newH264VideoRTPSink(rtpGroupSock, uint32(rtpPayloadType))
+}
+
+func (s *LiveH264MediaSubsession) getAuxSDPLine(rtpSink IMediaSink, inputSource IFramedSource) string {
+	sps, pps := s.source.parameterSets(liveParameterSetsTimeout)
+	return h264AuxSDPLine(rtpSink.rtpPayloadType(), sps, pps)
+}
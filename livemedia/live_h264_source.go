@@ -0,0 +1,233 @@
+package livemedia
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	sys "syscall"
+	"time"
+)
+
+// the number of NAL units that may be queued for a slow stream before units are dropped
+const liveFrameQueueSize = 256
+
+// ErrSourceClosed is returned by WriteFrame once the source is closed.
+var ErrSourceClosed = errors.New("the source is closed")
+
+type liveH264Frame struct {
+	nal              []byte
+	presentationTime sys.Timeval
+	// whether it's the last NAL unit of its picture
+	pictureEnd bool
+}
+
+// isKeyFrame reports whether a decoder can start from the NAL unit (an IDR picture, or the
+// parameter sets that come before one).
+func (f *liveH264Frame) isKeyFrame() bool {
+	nalUnitType := f.nal[0] & 0x1F
+	return nalUnitType == 5 || nalUnitType == 7 || nalUnitType == 8
+}
+
+// LiveH264Source is H.264 video that Go code produces, e.g. from a hardware encoder, and pushes
+// to the streams with WriteFrame. Serve it with a LiveH264MediaSubsession.
+type LiveH264Source struct {
+	mutex   sync.Mutex
+	readers map[*liveH264Reader]bool
+	// the latest parameter sets, for the SDP descriptions
+	sps, pps []byte
+	// the wall clock time of pts 0, which is set by the first frame
+	timeBase  time.Time
+	isClosed  bool
+	paramsSet chan struct{}
+}
+
+// NewLiveH264Source creates a source that has no frames yet.
+func NewLiveH264Source() *LiveH264Source {
+	return &LiveH264Source{
+		readers:   make(map[*liveH264Reader]bool),
+		paramsSet: make(chan struct{}),
+	}
+}
+
+// WriteFrame pushes the NAL units of a picture, with its presentation time: nal is a single NAL
+// unit, or an access unit in the Annex B format (with start codes). The SPS and PPS units are
+// kept for the SDP descriptions of the stream. WriteFrame doesn't block: a stream that doesn't
+// keep up drops units, and then waits for the next key frame, and with no streams, the units
+// are dropped. The units are copied, so the caller may reuse nal.
+func (s *LiveH264Source) WriteFrame(nal []byte, pts time.Duration) error {
+	nalUnits := splitNALUnits(append([]byte(nil), nal...))
+	if len(nalUnits) == 0 {
+		return errors.New("no NAL units in the frame")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.isClosed {
+		return ErrSourceClosed
+	}
+	if s.timeBase.IsZero() {
+		s.timeBase = time.Now().Add(-pts)
+	}
+	presentationTime := sys.NsecToTimeval(s.timeBase.Add(pts).UnixNano())
+
+	for i, nalUnit := range nalUnits {
+		switch nalUnit[0] & 0x1F {
+		case 7:
+			// (copied apart from the frame, which they would keep from being freed)
+			s.sps = append([]byte(nil), nalUnit...)
+		case 8:
+			s.pps = append([]byte(nil), nalUnit...)
+		}
+
+		frame := &liveH264Frame{
+			nal:              nalUnit,
+			presentationTime: presentationTime,
+			pictureEnd:       i == len(nalUnits)-1,
+		}
+		for reader := range s.readers {
+			reader.deliver(frame)
+		}
+	}
+
+	if s.sps != nil && s.pps != nil {
+		select {
+		case <-s.paramsSet:
+		default:
+			close(s.paramsSet)
+		}
+	}
+	return nil
+}
+
+// Close ends the streams of the source.
+func (s *LiveH264Source) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.isClosed = true
+	for reader := range s.readers {
+		reader.close()
+	}
+}
+
+// parameterSets returns the latest SPS and PPS, waiting up to timeout for the first ones.
+func (s *LiveH264Source) parameterSets(timeout time.Duration) (sps, pps []byte) {
+	select {
+	case <-s.paramsSet:
+	case <-time.After(timeout):
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.sps, s.pps
+}
+
+// newReader returns a stream of the units that are written from now on; it starts with the
+// next key frame.
+func (s *LiveH264Source) newReader() *liveH264Reader {
+	reader := &liveH264Reader{
+		sourceWaker: newSourceWaker(),
+		master:      s,
+		frames:      make(chan *liveH264Frame, liveFrameQueueSize),
+		closed:      make(chan struct{}),
+		dropping:    true,
+	}
+	reader.initFramedSource(reader)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.isClosed {
+		reader.close()
+	} else {
+		s.readers[reader] = true
+	}
+	return reader
+}
+
+func (s *LiveH264Source) removeReader(reader *liveH264Reader) {
+	s.mutex.Lock()
+	delete(s.readers, reader)
+	s.mutex.Unlock()
+}
+
+// splitNALUnits returns the NAL units of an Annex B byte stream, or the data itself if it
+// has no start codes.
+func splitNALUnits(data []byte) (nalUnits [][]byte) {
+	startCode := []byte{0, 0, 1}
+	if !bytes.HasPrefix(data, startCode) && !bytes.HasPrefix(data, []byte{0, 0, 0, 1}) {
+		if len(data) == 0 {
+			return nil
+		}
+		return [][]byte{data}
+	}
+
+	for _, nalUnit := range bytes.Split(data, startCode) {
+		// (A 4-byte start code leaves a zero at the end of the previous unit.)
+		nalUnit = bytes.TrimRight(nalUnit, "\x00")
+		if len(nalUnit) > 0 {
+			nalUnits = append(nalUnits, nalUnit)
+		}
+	}
+	return
+}
+
+//////// liveH264Reader ////////
+
+// liveH264Reader feeds a stream's RTP sink with the NAL units of a LiveH264Source.
+type liveH264Reader struct {
+	FramedSource
+	sourceWaker
+	master  *LiveH264Source
+	frames  chan *liveH264Frame
+	closed  chan struct{}
+	closing sync.Once
+	// whether units are dropped until the next key frame; it's guarded by the master's mutex
+	dropping bool
+	// whether the unit that was delivered last ends a picture, for the RTP marker bit
+	pictureEndMarker bool
+}
+
+func (r *liveH264Reader) doGetNextFrame() error {
+	select {
+	case frame := <-r.frames:
+		r.frameSize = uint(copy(r.buffTo[:r.maxSize], frame.nal))
+		r.numTruncatedBytes = uint(len(frame.nal)) - r.frameSize
+		r.presentationTime = frame.presentationTime
+		r.pictureEndMarker = frame.pictureEnd
+		r.durationInMicroseconds = 0
+		r.afterGetting()
+	case <-r.closed:
+		r.handleClosure()
+	case <-r.wake:
+		// The stream is being stopped:
+		r.isCurrentlyAwaitingData = false
+	}
+	return nil
+}
+
+// deliver queues a unit. When the queue is full, the unit is dropped, and so are the units
+// that follow it until a key frame, since they can't be decoded without it.
+func (r *liveH264Reader) deliver(frame *liveH264Frame) {
+	if r.dropping && !frame.isKeyFrame() {
+		return
+	}
+
+	select {
+	case r.frames <- frame:
+		r.dropping = false
+	default:
+		r.dropping = true
+	}
+}
+
+func (r *liveH264Reader) close() {
+	r.closing.Do(func() {
+		close(r.closed)
+	})
+}
+
+func (r *liveH264Reader) destroy() {
+	r.master.removeReader(r)
+	r.close()
+}
@@ -0,0 +1,101 @@
+package livemedia
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLiveH264Source(t *testing.T) {
+	source := NewLiveH264Source()
+	defer source.Close()
+	reader := source.newReader()
+	defer reader.destroy()
+
+	// A stream starts with a key frame:
+	source.WriteFrame([]byte{0x41, 1, 2, 3}, 0)
+	accessUnit := []byte{0, 0, 0, 1, 0x67, 0x4D, 0x40, 0x33, 0, 0, 0, 1, 0x68, 0xEE, 0, 0, 1, 0x65, 4, 5, 6}
+	if err := source.WriteFrame(accessUnit, 40*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	// The caller may reuse its buffer:
+	for i := range accessUnit {
+		accessUnit[i] = 0xFF
+	}
+
+	sps, pps := source.parameterSets(0)
+	if !bytes.Equal(sps, []byte{0x67, 0x4D, 0x40, 0x33}) || !bytes.Equal(pps, []byte{0x68, 0xEE}) ||
+		len(reader.frames) != 3 {
+		t.Error("failed")
+		return
+	}
+	for i, nal := range [][]byte{{0x67, 0x4D, 0x40, 0x33}, {0x68, 0xEE}, {0x65, 4, 5, 6}} {
+		frame := <-reader.frames
+		if frame.pictureEnd != (i == 2) || !bytes.Equal(frame.nal, nal) {
+			t.Error("failed")
+			return
+		}
+	}
+
+	// A full queue drops units until the next key frame:
+	for i := 0; i < liveFrameQueueSize+1; i++ {
+		source.WriteFrame([]byte{0x41, byte(i)}, time.Duration(i)*time.Millisecond)
+	}
+	for len(reader.frames) > 0 {
+		<-reader.frames
+	}
+	source.WriteFrame([]byte{0x41, 1}, time.Second)
+	source.WriteFrame([]byte{0x65, 1}, time.Second)
+	if frame := <-reader.frames; frame.nal[0] == 0x65 && len(reader.frames) == 0 {
+		t.Log("success")
+	} else {
+		t.Error("failed")
+	}
+
+	source.Close()
+	if source.WriteFrame([]byte{0x41, 1}, 2*time.Second) == ErrSourceClosed {
+		t.Log("success")
+	} else {
+		t.Error("failed")
+	}
+}
+
+func TestLiveH264SDPLines(t *testing.T) {
+	source := NewLiveH264Source()
+	defer source.Close()
+	subsession := NewLiveH264MediaSubsession(source)
+	sms := NewServerMediaSession("H.264 Video", "live")
+	sms.AddSubsession(subsession)
+
+	// Two clients ask for the description before the source has its parameter sets:
+	results := make(chan string, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			results <- subsession.SDPLines()
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	source.WriteFrame([]byte{0, 0, 0, 1, 0x67, 0x4D, 0x40, 0x33, 0, 0, 0, 1, 0x68, 0xEE, 0, 0, 1, 0x65, 4}, 0)
+
+	for i := 0; i < 2; i++ {
+		if sdpLines := <-results; strings.Contains(sdpLines, "sprop-parameter-sets=Z01AMw==,aO4=") {
+			t.Log("success")
+		} else {
+			t.Errorf("failed: %s", sdpLines)
+		}
+	}
+
+	// SRTP is enabled while a client asks for the description; the next ones have the key:
+	go func() {
+		results <- subsession.SDPLines()
+	}()
+	subsession.EnableSRTP()
+	<-results
+	if strings.Contains(subsession.SDPLines(), "a=crypto:") {
+		t.Log("success")
+	} else {
+		t.Error("failed")
+	}
+}
@@ -61,32 +61,12 @@ func (s *MediaSession) initWithSDP(sdpLine string) bool {
 			break
 		}
 
+		// Check for various special SDP lines that we understand:
+		s.parseSessionLevelSDPLine(thisSDPLine)
+
 		// there is no m= lines at all
 		if sdpLine == "" {
-			break
-		}
-
-		// Check for various special SDP lines that we understand:
-		if s.parseSDPLineS(thisSDPLine) {
-			continue
-		}
-		if s.parseSDPLineI(thisSDPLine) {
-			continue
-		}
-		if s.parseSDPLineC(thisSDPLine) {
-			continue
-		}
-		if s.parseSDPAttributeControl(thisSDPLine) {
-			continue
-		}
-		if s.parseSDPAttributeRange(thisSDPLine) {
-			continue
-		}
-		if s.parseSDPAttributeType(thisSDPLine) {
-			continue
-		}
-		if s.parseSDPAttributeSourceFilter(thisSDPLine) {
-			continue
+			return true
 		}
 	}
 
@@ -110,6 +90,11 @@ func (s *MediaSession) initWithSDP(sdpLine string) bool {
 			&subsession.clientPortNum, &payloadFormat)
 		n5, _ = fmt.Sscanf(thisSDPLine, "m=%s %d RAW/RAW/UDP %d", &mediumName,
 			&subsession.clientPortNum, &payloadFormat)
+		if n1 != 3 && n2 != 3 {
+			// SRTP (RFC 3711), whose keys come in "a=crypto" lines
+			n1, _ = fmt.Sscanf(thisSDPLine, "m=%s %d RTP/SAVP %d", &mediumName,
+				&subsession.clientPortNum, &payloadFormat)
+		}
 
 		if (n1 == 3 || n2 == 3) && payloadFormat <= 127 {
 			protocolName = "RTP"
@@ -120,33 +105,32 @@ func (s *MediaSession) initWithSDP(sdpLine string) bool {
 		}
 
 		// Insert this subsession at the end of the list:
-		//s.mediaSubSessions = append(s.mediaSubSessions, subsession)
 		s.mediaSubsessions[s.subsessionNum] = subsession
 		s.subsessionNum++
 
 		subsession.serverPortNum = subsession.clientPortNum
-		subsession.savedSDPLines = sdpLine
 		subsession.mediumName = mediumName
 		subsession.protocolName = protocolName
 		subsession.rtpPayloadFormat = payloadFormat
 
 		// Process the following SDP lines, up until the next "m=":
-		for {
-			sdpLine = nextSDPLine
-			if sdpLine == "" {
-				//fmt.Println("we've reached the end")
-				break // we've reached the end
-			}
-
+		savedSDPLines := thisSDPLine + "\r\n"
+		haveNextSubsession := false
+		for sdpLine != "" {
 			nextSDPLine, thisSDPLine, result = s.parseSDPLine(sdpLine)
 			if !result {
 				return false
 			}
 
+			sdpLine = nextSDPLine
+
 			if thisSDPLine[0] == 'm' {
+				haveNextSubsession = true
 				break // we've reached the next subsession
 			}
 
+			savedSDPLines += thisSDPLine + "\r\n"
+
 			// Check for various special SDP lines that we understand:
 			if subsession.parseSDPLineC(thisSDPLine) {
 				continue
@@ -172,11 +156,15 @@ func (s *MediaSession) initWithSDP(sdpLine string) bool {
 			if subsession.parseSDPAttributeXDimensions(thisSDPLine) {
 				continue
 			}
+			if subsession.parseSDPAttributeCrypto(thisSDPLine) {
+				continue
+			}
 			if subsession.parseSDPAttributeFrameRate(thisSDPLine) {
 				continue
 			}
 			// (Later, check for malformed lines, and other valid SDP lines#####)
 		}
+		subsession.savedSDPLines = savedSDPLines
 
 		if subsession.codecName == "" {
 			subsession.codecName,
@@ -192,11 +180,24 @@ func (s *MediaSession) initWithSDP(sdpLine string) bool {
 			subsession.rtpTimestampFrequency = s.guessRTPTimestampFrequency(subsession.mediumName,
 				subsession.codecName)
 		}
-		break
+
+		if !haveNextSubsession || s.subsessionNum >= len(s.mediaSubsessions) {
+			break
+		}
 	}
 	return true
 }
 
+func (s *MediaSession) parseSessionLevelSDPLine(sdpLine string) bool {
+	return s.parseSDPLineS(sdpLine) ||
+		s.parseSDPLineI(sdpLine) ||
+		s.parseSDPLineC(sdpLine) ||
+		s.parseSDPAttributeControl(sdpLine) ||
+		s.parseSDPAttributeRange(sdpLine) ||
+		s.parseSDPAttributeType(sdpLine) ||
+		s.parseSDPAttributeSourceFilter(sdpLine)
+}
+
 func (s *MediaSession) Scale() float32 {
 	return s.scale
 }
@@ -214,10 +215,19 @@ func (s *MediaSession) AbsEndTime() string {
 }
 
 func (session *MediaSession) HasSubsessions() bool {
-	return len(session.mediaSubsessions) > 0
+	return session.subsessionNum > 0
+}
+
+// SubsessionNum returns the number of "m=" sections that were described by the SDP.
+func (s *MediaSession) SubsessionNum() int {
+	return s.subsessionNum
 }
 
+// Subsession iterates over the subsessions, and returns nil once all have been returned.
 func (s *MediaSession) Subsession() *MediaSubsession {
+	if s.subsessionIndex >= s.subsessionNum {
+		return nil
+	}
 	s.subsessionIndex++
 	return s.mediaSubsessions[s.subsessionIndex-1]
 }
@@ -226,12 +236,13 @@ func (s *MediaSession) parseSDPLine(inputLine string) (nextLine, thisLine string
 	inputLen := len(inputLine)
 
 	// Begin by finding the start of the next line (if any):
+	thisLine = inputLine
 	for i := 0; i < inputLen; i++ {
 		if inputLine[i] == '\r' || inputLine[i] == '\n' {
+			thisLine = inputLine[:i]
 			for i += 1; i < inputLen && (inputLine[i] == '\r' || inputLine[i] == '\n'); i++ {
 			}
 			nextLine = inputLine[i:]
-			thisLine = inputLine[:i-2]
 			break
 		}
 	}
@@ -244,8 +255,15 @@ func (s *MediaSession) parseSDPLine(inputLine string) (nextLine, thisLine string
 	return
 }
 
-func parseCLine(sdpLine string) (result string) {
-	fmt.Sscanf(sdpLine, "c=IN IP4 %s", &result)
+// parseCLine returns the address of a "c=" line, and its TTL, if it has one.
+func parseCLine(sdpLine string) (result string, ttl uint) {
+	if n, _ := fmt.Sscanf(sdpLine, "c=IN IP4 %s", &result); n != 1 {
+		fmt.Sscanf(sdpLine, "c=IN IP6 %s", &result)
+	}
+	if i := strings.Index(result, "/"); i != -1 {
+		fmt.Sscanf(result[i+1:], "%d", &ttl)
+		result = result[:i]
+	}
 	return
 }
 
@@ -275,9 +293,10 @@ func (s *MediaSession) parseSDPLineI(sdpLine string) bool {
 
 // Check for "c=IN IP4 <connection-endpoint>"
 // or "c=IN IP4 <connection-endpoint>/<ttl+numAddresses>"
+// or "c=IN IP6 <connection-endpoint>"
 // (Later, do something with <ttl+numAddresses> also #####)
 func (s *MediaSession) parseSDPLineC(sdpLine string) bool {
-	connectionEndpointName := parseCLine(sdpLine)
+	connectionEndpointName, _ := parseCLine(sdpLine)
 	if connectionEndpointName != "" {
 		s.connectionEndpointName = connectionEndpointName
 		return true
@@ -363,9 +382,10 @@ func (s *MediaSession) parseSDPAttributeRange(sdpLine string) bool {
 // one of our multicast addresses.  We also don't support more than
 // one <source> #####
 func parseSourceFilterAttribute(sdpLine string) bool {
-	var sourceName string
-	n, _ := fmt.Sscanf(sdpLine, "a=source-filter: incl IN IP4 %*s %s", &sourceName)
-	return (n == 1)
+	var addrType, destAddr, sourceName string
+	sdpLine = strings.Replace(sdpLine, "a=source-filter: ", "a=source-filter:", 1)
+	n, _ := fmt.Sscanf(sdpLine, "a=source-filter:incl IN %s %s %s", &addrType, &destAddr, &sourceName)
+	return (n == 3)
 }
 
 func (s *MediaSession) parseSDPAttributeSourceFilter(sdpLine string) bool {
@@ -490,6 +510,8 @@ type MediaSubsession struct {
 	playEndTime            float64
 	videoFPS               float32
 	scale                  float32
+	srtpKey                []byte // the SRTP master key and salt of an "a=crypto" line
+	connectionTTL          uint   // the TTL of a multicast "c=" line
 }
 
 func NewMediaSubsession(parent *MediaSession) *MediaSubsession {
@@ -518,10 +540,14 @@ func (s *MediaSubsession) Initiate() bool {
 		return false
 	}
 
-	tempAddr := s.ConnectionEndpointName()
+	// (Our sockets listen on every address, so that they receive the stream over IPv4 or IPv6.)
+	var tempAddr string
 
 	var success bool
-	for {
+	if groupAddr := s.multicastAddress(); groupAddr != "" && s.clientPortNum != 0 {
+		success = s.joinMulticastGroup(groupAddr)
+	}
+	for !success {
 		// create new socket
 		s.rtpSocket = gs.NewGroupSock(tempAddr, 0)
 		if s.rtpSocket == nil {
@@ -563,6 +589,15 @@ func (s *MediaSubsession) Initiate() bool {
 		return false
 	}
 
+	var srtp *SRTPContext
+	if s.srtpKey != nil {
+		var err error
+		if srtp, err = NewSRTPContext(s.srtpKey); err != nil {
+			fmt.Println(err)
+			return false
+		}
+	}
+
 	if !s.createSourceObject() {
 		return false
 	}
@@ -579,10 +614,55 @@ func (s *MediaSubsession) Initiate() bool {
 		totSessionBandwidth = 500
 	}
 
-	s.rtcpInstance = newRTCPInstance(s.rtcpSocket, totSessionBandwidth, s.parent.cname, nil, s.RTPSource)
+	if source, ok := s.readSource.(interface {
+		setSRTP(srtp *SRTPContext)
+	}); ok && srtp != nil {
+		source.setSRTP(srtp)
+	}
+
+	s.rtcpInstance = newRTCPInstance(s.rtcpSocket, totSessionBandwidth, s.parent.cname, nil, s.RTPSource, srtp)
+	return true
+}
+
+// multicastAddress returns the multicast group of the subsession's (or else the session's)
+// "c=" line, or "" if it isn't multicast.
+func (s *MediaSubsession) multicastAddress() string {
+	groupAddr := s.connectionEndpointName
+	if groupAddr == "" {
+		groupAddr = s.parent.connectionEndpointName
+	}
+	if !gs.IsMulticastAddress(groupAddr) {
+		return ""
+	}
+	return groupAddr
+}
+
+// joinMulticastGroup creates our sockets on the ports of a multicast stream (from its "m=" line),
+// in its group; our RTCP reports go to the group as well.
+func (s *MediaSubsession) joinMulticastGroup(groupAddr string) bool {
+	ttl := s.connectionTTL
+	if ttl == 0 {
+		ttl = 255
+	}
+
+	var err error
+	if s.rtpSocket, err = gs.NewMulticastGroupSock(groupAddr, s.clientPortNum, ttl); err != nil {
+		fmt.Println("Unable to join the multicast group.", err)
+		return false
+	}
+	if s.rtcpSocket, err = gs.NewMulticastGroupSock(groupAddr, s.clientPortNum|1, ttl); err != nil {
+		fmt.Println("Unable to join the multicast group.", err)
+		s.rtpSocket.Close()
+		return false
+	}
 	return true
 }
 
+// IsMulticast reports whether the subsession's stream is received from a multicast group.
+func (s *MediaSubsession) IsMulticast() bool {
+	return s.rtpSocket != nil && s.rtpSocket.IsMulticast()
+}
+
 func (s *MediaSubsession) Scale() float32 {
 	return s.scale
 }
@@ -646,10 +726,33 @@ func (s *MediaSubsession) ProtocolName() string {
 	return s.protocolName
 }
 
+// SRTPEnabled reports whether the subsession's stream is sent with SRTP, i.e. whether its
+// SETUP asks for the "RTP/SAVP" profile.
+func (s *MediaSubsession) SRTPEnabled() bool {
+	return s.srtpKey != nil
+}
+
 func (s *MediaSubsession) ControlPath() string {
 	return s.controlPath
 }
 
+// SavedSDPLines returns the SDP lines (from "m=" onwards) that describe this subsession.
+func (s *MediaSubsession) SavedSDPLines() string {
+	return s.savedSDPLines
+}
+
+func (s *MediaSubsession) RTPPayloadFormat() uint32 {
+	return s.rtpPayloadFormat
+}
+
+func (s *MediaSubsession) RTPTimestampFrequency() uint32 {
+	return s.rtpTimestampFrequency
+}
+
+func (s *MediaSubsession) NumChannels() uint32 {
+	return s.numChannels
+}
+
 func (s *MediaSubsession) ReadSource() IFramedSource {
 	return s.readSource
 }
@@ -694,11 +797,11 @@ func (s *MediaSubsession) parseSDPLineB(sdpLine string) bool {
 
 // Check for "c=IN IP4 <connection-endpoint>"
 // or "c=IN IP4 <connection-endpoint>/<ttl+numAddresses>"
-// (Later, do something with <ttl+numAddresses> also #####)
+// or "c=IN IP6 <connection-endpoint>"
 func (s *MediaSubsession) parseSDPLineC(sdpLine string) bool {
-	connectionEndpointName := parseCLine(sdpLine)
+	connectionEndpointName, ttl := parseCLine(sdpLine)
 	if connectionEndpointName != "" {
-		s.connectionEndpointName = connectionEndpointName
+		s.connectionEndpointName, s.connectionTTL = connectionEndpointName, ttl
 		return true
 	}
 
@@ -727,15 +830,22 @@ func (s *MediaSubsession) parseSDPAttributeRtpmap(sdpLine string) bool {
 		}
 		s.rtpPayloadFormat = uint32(rtpPayloadFormat)
 
+		// <encoding name>/<clock rate>[/<encoding parameters>]
 		value := strings.Split(fields[1], "/")
-		if len(value) == 2 {
-			s.codecName = value[0]
+		if len(value) == 2 || len(value) == 3 {
+			s.codecName = strings.ToUpper(value[0])
 
 			rtpTimestampFrequency, err := strconv.Atoi(value[1])
 			if err != nil {
 				break
 			}
 			s.rtpTimestampFrequency = uint32(rtpTimestampFrequency)
+
+			if len(value) == 3 {
+				if channels, err := strconv.Atoi(value[2]); err == nil {
+					numChannels = uint32(channels)
+				}
+			}
 		} else {
 			break
 		}
@@ -789,8 +899,10 @@ func (s *MediaSubsession) parseSDPAttributeRange(sdpLine string) bool {
 	return parseSuccess
 }
 
+// Check for a "a=fmtp:<format> <parameters>" line:
+// (The parameters themselves are kept in the subsession's saved SDP lines.)
 func (s *MediaSubsession) parseSDPAttributeFmtp(sdpLine string) bool {
-	return true
+	return strings.HasPrefix(sdpLine, "a=fmtp:")
 }
 
 func (s *MediaSubsession) parseSDPAttributeSourceFilter(sdpLine string) bool {
@@ -807,6 +919,15 @@ func (s *MediaSubsession) parseSDPAttributeXDimensions(sdpLine string) bool {
 	return parseSuccess
 }
 
+// Check for a "a=crypto:<tag> AES_CM_128_HMAC_SHA1_80 inline:<key||salt>" line:
+func (s *MediaSubsession) parseSDPAttributeCrypto(sdpLine string) bool {
+	masterKey, ok := parseSRTPCryptoAttribute(sdpLine)
+	if ok && s.srtpKey == nil {
+		s.srtpKey = masterKey
+	}
+	return ok
+}
+
 // check for a "a=framerate: <fps>" r "a=x-framerate: <fps>" line:
 func (s *MediaSubsession) parseSDPAttributeFrameRate(sdpLine string) bool {
 	parseSuccess := true
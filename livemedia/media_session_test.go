@@ -48,3 +48,99 @@ func TestConnectionEndpointName(t *testing.T) {
 	//fmt.Println("Connection Endpoint Name:", endPointName)
 	t.Log("success")
 }
+
+var announceSDPDesc = "v=0\r\n" +
+	"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+	"s=No Name\r\n" +
+	"t=0 0\r\n" +
+	"a=tool:libavformat 58.29.100\r\n" +
+	"m=video 0 RTP/AVP 96\r\n" +
+	"a=rtpmap:96 H264/90000\r\n" +
+	"a=fmtp:96 packetization-mode=1\r\n" +
+	"a=control:streamid=0\r\n" +
+	"m=audio 0 RTP/AVP 97\r\n" +
+	"b=AS:128\r\n" +
+	"a=rtpmap:97 MPEG4-GENERIC/44100/2\r\n" +
+	"a=control:streamid=1\r\n"
+
+func TestInitWithMultipleSubsessions(t *testing.T) {
+	session := NewMediaSession(announceSDPDesc)
+	if session == nil || session.SubsessionNum() != 2 {
+		t.Error("failed")
+		return
+	}
+
+	video, audio := session.Subsession(), session.Subsession()
+	if video.CodecName() != "H264" || video.ControlPath() != "streamid=0" ||
+		audio.CodecName() != "MPEG4-GENERIC" || audio.NumChannels() != 2 || audio.ControlPath() != "streamid=1" ||
+		session.Subsession() != nil {
+		fmt.Println("parse subsessions error", video.CodecName(), audio.ControlPath())
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+var srtpSDPDesc = "v=0\r\n" +
+	"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+	"s=Test\r\n" +
+	"t=0 0\r\n" +
+	"m=video 0 RTP/SAVP 96\r\n" +
+	"a=rtpmap:96 H264/90000\r\n" +
+	"a=crypto:1 AES_CM_128_HMAC_SHA1_80 inline:WVNfX19zZW1jdGwgKCkgewkyMjA7fQp9CnVubGVz|2^20|1:4\r\n" +
+	"a=control:streamid=0\r\n"
+
+func TestInitWithSRTP(t *testing.T) {
+	session := NewMediaSession(srtpSDPDesc)
+	if session == nil || session.SubsessionNum() != 1 {
+		t.Error("failed")
+		return
+	}
+
+	subsession := session.Subsession()
+	if subsession.ProtocolName() != "RTP" || !subsession.SRTPEnabled() || len(subsession.srtpKey) != 30 {
+		fmt.Println("parse crypto error", subsession.ProtocolName())
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+func TestParseCLine(t *testing.T) {
+	addr4, _ := parseCLine("c=IN IP4 0.0.0.0")
+	addr6, _ := parseCLine("c=IN IP6 ::")
+	group, ttl := parseCLine("c=IN IP4 232.1.2.3/16")
+	if addr4 == "0.0.0.0" && addr6 == "::" && group == "232.1.2.3" && ttl == 16 &&
+		sdpAddressType("2001:db8::1") == "IP6" && sdpAddressType("192.168.1.105") == "IP4" {
+		t.Log("success")
+	} else {
+		t.Error("failed")
+	}
+}
+
+var multicastSDPDesc = "v=0\r\n" +
+	"o=- 0 0 IN IP4 192.168.1.105\r\n" +
+	"s=Test\r\n" +
+	"t=0 0\r\n" +
+	"a=source-filter: incl IN IP4 * 192.168.1.105\r\n" +
+	"m=video 15004 RTP/AVP 96\r\n" +
+	"c=IN IP4 232.1.2.3/16\r\n" +
+	"a=rtpmap:96 H264/90000\r\n" +
+	"a=control:track1\r\n"
+
+func TestInitWithMulticast(t *testing.T) {
+	session := NewMediaSession(multicastSDPDesc)
+	if session == nil || session.SubsessionNum() != 1 {
+		t.Error("failed")
+		return
+	}
+
+	subsession := session.Subsession()
+	if subsession.multicastAddress() != "232.1.2.3" || subsession.connectionTTL != 16 ||
+		subsession.ClientPortNum() != 15004 || !parseSourceFilterAttribute("a=source-filter:incl IN IP4 * 10.0.0.1") {
+		fmt.Println("parse multicast error", subsession.multicastAddress())
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
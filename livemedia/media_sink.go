@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"encoding/binary"
 	"net"
+	"sync"
 	sys "syscall"
+	"time"
 
 	"github.com/djwackey/gitea/log"
 )
@@ -27,7 +29,13 @@ type OutPacketBuffer struct {
 }
 
 func newOutPacketBuffer(preferredPacketSize, maxPacketSize uint) *OutPacketBuffer {
-	maxNumPackets := (OutPacketBufferMaxSize + (maxPacketSize - 1)) / maxPacketSize
+	return newOutPacketBufferOfSize(preferredPacketSize, maxPacketSize, OutPacketBufferMaxSize)
+}
+
+// newOutPacketBufferOfSize returns a buffer of (at least) maxBufferSize bytes, rather than of
+// OutPacketBufferMaxSize.
+func newOutPacketBufferOfSize(preferredPacketSize, maxPacketSize, maxBufferSize uint) *OutPacketBuffer {
+	maxNumPackets := (maxBufferSize + (maxPacketSize - 1)) / maxPacketSize
 	limit := maxNumPackets * maxPacketSize
 
 	b := &OutPacketBuffer{
@@ -206,7 +214,9 @@ type IMediaSink interface {
 	enableRTCPReports() bool
 	nextTimestampHasBeenPreset() bool
 	StartPlaying(source IFramedSource, afterFunc interface{}) bool
+	startPlayingInBackground(source IFramedSource, afterFunc interface{}) bool
 	StopPlaying()
+	waitForPlayer()
 	ContinuePlaying()
 	destroy()
 	ssrc() uint32
@@ -219,7 +229,7 @@ type IMediaSink interface {
 	transmissionStatsDB() *RTPTransmissionStatsDB
 	addStreamSocket(socketNum net.Conn, streamChannelID uint)
 	delStreamSocket(socketNum net.Conn, streamChannelID uint)
-	setServerRequestAlternativeByteHandler(socketNum net.Conn, handler interface{})
+	setSRTP(srtp *SRTPContext)
 	frameCanAppearAfterPacketStart(frameStart []byte, numBytesInFrame uint) bool
 	doSpecialFrameHandling(fragmentationOffset, numBytesInFrame, numRemainingBytes uint,
 		frameStart []byte, framePresentationTime sys.Timeval)
@@ -229,13 +239,58 @@ type MediaSink struct {
 	Source    IFramedSource
 	rtpSink   IMediaSink
 	afterFunc interface{}
+	// closed by StopPlaying, to end the goroutine that plays the sink
+	stopping chan struct{}
+	// closed when the goroutine that plays the sink has ended
+	done chan struct{}
+	// held by the goroutine that plays the sink while it reads a frame and sends a packet, and
+	// by the others that use the sink meanwhile
+	mutex sync.Mutex
 }
 
 func (s *MediaSink) InitMediaSink(rtpSink IMediaSink) {
 	s.rtpSink = rtpSink
 }
 
+// StartPlaying plays a source. A sink that sends RTP sends the stream from the calling
+// goroutine, until it's stopped or the source ends.
 func (s *MediaSink) StartPlaying(source IFramedSource, afterFunc interface{}) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.start(source, afterFunc) {
+		return false
+	}
+	s.rtpSink.ContinuePlaying()
+	return true
+}
+
+// startPlayingInBackground plays a source from a goroutine of its own. Unlike with
+// "go StartPlaying()", a StopPlaying that comes before the goroutine does stop the sink.
+func (s *MediaSink) startPlayingInBackground(source IFramedSource, afterFunc interface{}) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.start(source, afterFunc) {
+		return false
+	}
+	stopping, done := s.stopping, make(chan struct{})
+	s.done = done
+	go func() {
+		defer close(done)
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		select {
+		case <-stopping:
+		default:
+			s.rtpSink.ContinuePlaying()
+		}
+	}()
+	return true
+}
+
+func (s *MediaSink) start(source IFramedSource, afterFunc interface{}) bool {
 	if s.Source != nil {
 		log.Error(1, "This sink is already being played")
 		return false
@@ -248,34 +303,93 @@ func (s *MediaSink) StartPlaying(source IFramedSource, afterFunc interface{}) bo
 
 	s.Source = source
 	s.afterFunc = afterFunc
-	s.rtpSink.ContinuePlaying()
+	s.stopping = make(chan struct{})
+	s.done = nil
 	return true
 }
 
+// StopPlaying stops the sink, once the packet that it's sending has been sent. (A source that
+// waits for its frames, such as a live one, is woken first by its stream; see waitingSource.)
 func (s *MediaSink) StopPlaying() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	// First, tell the source that we're no longer interested:
 	if s.Source != nil {
 		s.Source.stopGettingFrames()
 	}
+	if s.stopping != nil {
+		close(s.stopping)
+		s.stopping = nil
+	}
 
 	s.Source = nil
 	s.afterFunc = nil
 }
 
+// waitForPlayer waits until the goroutine that played the sink has ended. The sink is stopped
+// before; once it's stopped, the goroutine may still be finishing with a frame of the source,
+// so a source is destroyed after this.
+func (s *MediaSink) waitForPlayer() {
+	s.mutex.Lock()
+	done := s.done
+	s.mutex.Unlock()
+
+	if done != nil {
+		<-done
+	}
+}
+
 func (s *MediaSink) OnSourceClosure() {
 	if s.afterFunc != nil {
 		s.afterFunc.(func())()
 	}
 }
 
+// sourceClosed tells the player of the sink that its source has ended. It's called by the
+// goroutine that plays the sink, which lets go of the sink meanwhile.
+func (s *MediaSink) sourceClosed() {
+	if afterFunc := s.afterFunc; afterFunc != nil {
+		s.mutex.Unlock()
+		defer s.mutex.Lock()
+		afterFunc.(func())()
+	}
+}
+
+// waitUntil lets go of the sink until the time of the next packet, and reports whether the
+// sink is still being played then. It's called by the goroutine that plays the sink.
+func (s *MediaSink) waitUntil(delay time.Duration) bool {
+	stopping := s.stopping
+	if stopping == nil {
+		return false
+	}
+	s.mutex.Unlock()
+
+	timer := time.NewTimer(delay)
+	select {
+	case <-timer.C:
+	case <-stopping:
+		timer.Stop()
+	}
+
+	// (The sink may have been stopped as the time came.)
+	s.mutex.Lock()
+	select {
+	case <-stopping:
+		return false
+	default:
+		return true
+	}
+}
+
 func (s *MediaSink) addStreamSocket(socketNum net.Conn, streamChannelID uint) {}
 func (s *MediaSink) delStreamSocket(socketNum net.Conn, streamChannelID uint) {}
+func (s *MediaSink) setSRTP(srtp *SRTPContext)                                {}
 func (s *MediaSink) frameCanAppearAfterPacketStart(frameStart []byte, numBytesInFrame uint) bool {
 	return false
 }
 func (s *MediaSink) doSpecialFrameHandling(fragmentationOffset, numBytesInFrame, numRemainingBytes uint, frameStart []byte, framePresentationTime sys.Timeval) {
 }
-func (s *MediaSink) setServerRequestAlternativeByteHandler(socketNum net.Conn, handler interface{}) {}
 
 func (s *MediaSink) nextTimestampHasBeenPreset() bool             { return true }
 func (s *MediaSink) enableRTCPReports() bool                      { return true }
@@ -193,11 +193,8 @@ func (s *MultiFramedRTPSink) sendPacketIfNecessary() {
 			}
 		}
 
-		s._packetCount++
-		s.totalOctetCount += s.outBuf.curPacketSize()
-		s._octetCount += s.outBuf.curPacketSize() - rtpHeaderSize - s.specialHeaderSize - s.totalFrameSpecificHeaderSizes
-
-		s.seqNo++ // for next time
+		s.notePacketSent(s.outBuf.curPacketSize(),
+			s.outBuf.curPacketSize()-rtpHeaderSize-s.specialHeaderSize-s.totalFrameSpecificHeaderSizes)
 	}
 
 	if s.outBuf.haveOverflowData() &&
@@ -218,7 +215,7 @@ func (s *MultiFramedRTPSink) sendPacketIfNecessary() {
 
 	if s.noFramesLeft {
 		// We're done:
-		s.OnSourceClosure()
+		s.sourceClosed()
 	} else {
 		// We have more frames left to send.  Figure out when the next frame
 		// is due to start playing, then make sure that we wait this long before
@@ -231,10 +228,11 @@ func (s *MultiFramedRTPSink) sendPacketIfNecessary() {
 			uSecondsToGo = 0
 		}
 
-		// Delay this amount of time:
+		// Delay this amount of time, unless the sink is stopped meanwhile:
 		//log.Debug("[MultiFramedRTPSink::sendPacketIfNecessary] uSecondsToGo: %d", uSecondsToGo)
-		time.Sleep(time.Duration(uSecondsToGo) * time.Microsecond)
-		s.sendNext()
+		if s.waitUntil(time.Duration(uSecondsToGo) * time.Microsecond) {
+			s.sendNext()
+		}
 	}
 }
 
@@ -40,21 +40,25 @@ func (s *MultiFramedRTPSource) reset() {
 }
 
 func (s *MultiFramedRTPSource) doGetNextFrame() error {
-	s.rtpInterface.startNetworkReading(s.networkReadHandler)
-
+	// (Reset before the reading goroutine starts, which fills in the frame.)
 	s.frameSize = 0
+
+	if !s.areDoingNetworkReads {
+		// Turn on background read handling of incoming packets:
+		s.areDoingNetworkReads = true
+		s.rtpInterface.startNetworkReading(s.networkReadHandler)
+	}
 	return nil
 }
 
 func (s *MultiFramedRTPSource) doGetNextFrame1() {
 	s.needDelivery = true
-	for s.needDelivery {
+	for s.needDelivery && s.isCurrentlyAwaitingData {
 		var packetLossPrecededThis bool
 		var nextPacket IBufferedPacket
 
 		nextPacket, packetLossPrecededThis = s.reOrderingBuffer.getNextCompletedPacket()
 		if nextPacket == nil {
-			fmt.Println("failed to get next completed packet.")
 			break
 		}
 
@@ -88,7 +92,7 @@ func (s *MultiFramedRTPSource) doGetNextFrame1() {
 			break
 		}
 
-		packetInfo := nextPacket.use(s.buffTo, uint32(s.maxSize))
+		packetInfo := nextPacket.use(s.buffTo[s.frameSize:], uint32(s.maxSize-s.frameSize))
 		s.presentationTime = packetInfo.presentationTime
 		s.numTruncatedBytes = uint(packetInfo.bytesTruncated)
 		s.curPacketRTPTimestamp = packetInfo.rtpTimestamp
@@ -100,13 +104,13 @@ func (s *MultiFramedRTPSource) doGetNextFrame1() {
 
 		if !nextPacket.hasUsableData() {
 			s.reOrderingBuffer.releaseUsedPacket(nextPacket)
-			fmt.Println("reOrderingBuffer release Used Packet.")
 		}
 
+		// Keep going: there may be further frames in this packet (or in
+		// later packets that are already queued) for our next reader.
+		s.needDelivery = true
 		if s.currentPacketCompletesFrame {
 			s.afterGetting()
-		} else {
-			s.needDelivery = true
 		}
 	}
 }
@@ -132,10 +136,12 @@ func (s *MultiFramedRTPSource) networkReadHandler() {
 		if packet == nil {
 			packet = s.reOrderingBuffer.getFreePacket(s)
 		}
+		packet.reset()
 
+		var readError error
+		var packetStored bool
 		for {
-			err := packet.fillInData(s.rtpInterface)
-			if err != nil {
+			if readError = packet.fillInData(s.rtpInterface); readError != nil {
 				break
 			}
 
@@ -234,9 +240,24 @@ func (s *MultiFramedRTPSource) networkReadHandler() {
 				break
 			}
 
+			packetStored = true
 			break
 		}
 
+		if readError != nil {
+			// The socket (or the RTSP connection carrying it) has gone away:
+			s.areDoingNetworkReads = false
+			s.handleClosure()
+			return
+		}
+
+		// A packet that we couldn't use is recycled for the next read:
+		if packetStored {
+			s.packetReadInProgress = nil
+		} else {
+			s.packetReadInProgress = packet
+		}
+
 		s.doGetNextFrame1()
 	}
 }
@@ -251,6 +272,7 @@ type IBufferedPacket interface {
 	rtpSeqNo() uint
 	UseCount() uint
 	skip(numBytes uint32)
+	reset()
 	isFirstPacket() bool
 	hasUsableData() bool
 	markFirstPacket(flag bool)
@@ -304,10 +326,11 @@ func (p *BufferedPacket) initBufferedPacket() {
 }
 
 func (p *BufferedPacket) use(buff []byte, size uint32) (info *PacketInfo) {
-	origFramePtr, dataSize := p.data(), p.dataSize()
-
 	var frameSize, frameDurationInMicroseconds uint32
-	frameSize, frameDurationInMicroseconds = p.getNextEnclosedFrameParameters(origFramePtr, dataSize)
+	frameSize, frameDurationInMicroseconds = p.getNextEnclosedFrameParameters(p.data(), p.dataSize())
+
+	// the call above may have skipped over a per-frame header:
+	framePtr := p.data()
 
 	var bytesUsed, bytesTruncated uint32
 	if frameSize > size {
@@ -318,6 +341,8 @@ func (p *BufferedPacket) use(buff []byte, size uint32) (info *PacketInfo) {
 		bytesUsed = frameSize
 	}
 
+	copy(buff[:bytesUsed], framePtr[:bytesUsed])
+	p.skip(frameSize)
 	p.useCount += 1
 
 	info = &PacketInfo{
@@ -347,6 +372,13 @@ func (p *BufferedPacket) skip(numBytes uint32) {
 	}
 }
 
+func (p *BufferedPacket) reset() {
+	p.head, p.tail = 0, 0
+	p.useCount = 0
+	p.nextPacket = nil
+	p.firstPacketFlag = false
+}
+
 func (p *BufferedPacket) rtpSeqNo() uint {
 	return uint(p.RTPSeqNo)
 }
@@ -389,7 +421,7 @@ func (p *BufferedPacket) TimeReceived() sys.Timeval {
 
 func (p *BufferedPacket) nextEnclosedFrameSize(buff []byte, size uint32) uint32 {
 	if p.nextEnclosedFrameProc != nil {
-		p.nextEnclosedFrameProc.(func(buff []byte, size uint32) uint32)(buff, size)
+		return p.nextEnclosedFrameProc.(func(buff []byte, size uint32) uint32)(buff, size)
 	}
 	return size
 }
@@ -485,7 +517,6 @@ func (b *ReorderingPacketBuffer) getNextCompletedPacket() (IBufferedPacket, bool
 	var packetLossPreceded bool
 
 	if b.headPacket == nil {
-		fmt.Println("ReorderingPacketBuffer::getNextCompletedPacket: buffer head packet equal nil")
 		return nil, packetLossPreceded
 	}
 
@@ -518,13 +549,18 @@ func (b *ReorderingPacketBuffer) getNextCompletedPacket() (IBufferedPacket, bool
 }
 
 func (b *ReorderingPacketBuffer) releaseUsedPacket(packet IBufferedPacket) {
-	b.nextExpectedSeqNo++
+	// RTP sequence numbers are 16 bits, and wrap around:
+	b.nextExpectedSeqNo = (b.nextExpectedSeqNo + 1) & 0xFFFF
 
 	b.headPacket = b.headPacket.NextPacket()
-	if b.headPacket != nil {
+	if b.headPacket == nil {
 		b.tailPacket = nil
 	}
 	packet.setNextPacket(nil)
+
+	if packet == b.savePacket {
+		b.savedPacketFree = true
+	}
 }
 
 func (b *ReorderingPacketBuffer) resetHaveSeenFirstPacket() {
@@ -538,7 +574,6 @@ func (b *ReorderingPacketBuffer) storePacket(packet IBufferedPacket) bool {
 		b.nextExpectedSeqNo = rtpSeqNo
 		packet.markFirstPacket(true)
 		b.haveSeenFirstPacket = true
-		fmt.Println("IsFirstPacket")
 	}
 
 	if seqNumLT(int(rtpSeqNo), int(b.nextExpectedSeqNo)) {
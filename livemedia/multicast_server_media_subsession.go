@@ -0,0 +1,196 @@
+package livemedia
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	gs "github.com/djwackey/dorsvr/groupsock"
+	"github.com/djwackey/gitea/log"
+)
+
+// MulticastServerMediaSubsession is a track that is streamed once, to a multicast group,
+// for every client: a "SETUP" tells a client the group, port and TTL, and the first "PLAY"
+// starts the stream, which goes on until the subsession is closed.
+type MulticastServerMediaSubsession struct {
+	ServerMediaSubsession
+	input       IServerMediaSubsession
+	groupAddr   string
+	portNum     uint
+	ttl         uint
+	sdpLines    string
+	mutex       sync.Mutex
+	streamState *StreamState
+	dests       *Destinations
+	isPlaying   bool
+}
+
+// NewMulticastServerMediaSubsession creates a track that streams the source of input, e.g.
+// a H264FileMediaSubsession, to a multicast group: its RTP goes to portNum (which should be
+// even), and its RTCP to portNum+1, with packets that go ttl hops.
+func NewMulticastServerMediaSubsession(input IServerMediaSubsession, groupAddr string,
+	portNum, ttl uint) (*MulticastServerMediaSubsession, error) {
+	if !gs.IsMulticastAddress(groupAddr) {
+		return nil, fmt.Errorf("%q isn't a multicast address", groupAddr)
+	}
+	if portNum == 0 || portNum > 65534 {
+		return nil, fmt.Errorf("bad multicast port %d", portNum)
+	}
+	if ttl == 0 || ttl > 255 {
+		return nil, fmt.Errorf("bad multicast TTL %d", ttl)
+	}
+
+	s := &MulticastServerMediaSubsession{
+		input:     input,
+		groupAddr: groupAddr,
+		portNum:   portNum,
+		ttl:       ttl,
+	}
+	s.initBaseClass(s)
+	return s, nil
+}
+
+// GroupAddress returns the multicast group that the track is streamed to.
+func (s *MulticastServerMediaSubsession) GroupAddress() string {
+	return s.groupAddr
+}
+
+// The input is numbered like this track, so that its payload type and "a=control:" are ours:
+func (s *MulticastServerMediaSubsession) IncrTrackNumber() {
+	s.ServerMediaSubsession.IncrTrackNumber()
+	s.input.IncrTrackNumber()
+}
+
+func (s *MulticastServerMediaSubsession) setParentSession(parentSession *ServerMediaSession) {
+	s.ServerMediaSubsession.setParentSession(parentSession)
+	s.input.setParentSession(parentSession)
+}
+
+func (s *MulticastServerMediaSubsession) getAuxSDPLine(rtpSink IMediaSink, inputSource IFramedSource) string {
+	return s.input.getAuxSDPLine(rtpSink, inputSource)
+}
+
+func (s *MulticastServerMediaSubsession) createNewStreamSource() IFramedSource {
+	return s.input.createNewStreamSource()
+}
+
+func (s *MulticastServerMediaSubsession) createNewRTPSink(rtpGroupSock *gs.GroupSock, rtpPayloadType uint) IMediaSink {
+	return s.input.createNewRTPSink(rtpGroupSock, rtpPayloadType)
+}
+
+func (s *MulticastServerMediaSubsession) CNAME() string {
+	return s.input.CNAME()
+}
+
+// SDPLines returns the SDP lines of the input, with the group's port in the "m=" line, and the
+// group (and TTL) in the "c=" line.
+func (s *MulticastServerMediaSubsession) SDPLines() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.sdpLines == "" {
+		connectionLine := fmt.Sprintf("c=IN IP4 %s/%d", s.groupAddr, s.ttl)
+		if gs.IsIPv6(s.groupAddr) {
+			// (IPv6 has no TTL in SDP.)
+			connectionLine = fmt.Sprintf("c=IN IP6 %s", s.groupAddr)
+		}
+
+		var sdpLines string
+		for _, line := range strings.Split(s.input.SDPLines(), "\r\n") {
+			switch {
+			case line == "":
+				continue
+			case strings.HasPrefix(line, "m="):
+				if fields := strings.Fields(line); len(fields) > 1 {
+					fields[1] = strconv.Itoa(int(s.portNum))
+					line = strings.Join(fields, " ")
+				}
+			case strings.HasPrefix(line, "c="):
+				line = connectionLine
+			}
+			sdpLines += line + "\r\n"
+		}
+		s.sdpLines = sdpLines
+	}
+	return s.sdpLines
+}
+
+// GetStreamParameters returns the group of the stream; the first call creates the stream.
+func (s *MulticastServerMediaSubsession) GetStreamParameters(tcpSocketNum net.Conn, destAddr,
+	clientSessionID string, clientRTPPort, clientRTCPPort, rtpChannelID, rtcpChannelID uint) *StreamParameter {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.streamState == nil {
+		rtpGroupSock, err := gs.NewMulticastGroupSock(s.groupAddr, s.portNum, s.ttl)
+		if err != nil {
+			log.Error(1, "[GetStreamParameters] %v", err)
+			return nil
+		}
+		rtcpGroupSock, err := gs.NewMulticastGroupSock(s.groupAddr, s.portNum+1, s.ttl)
+		if err != nil {
+			log.Error(1, "[GetStreamParameters] %v", err)
+			rtpGroupSock.Close()
+			return nil
+		}
+
+		rtpPayloadType := 96 + s.TrackNumber() - 1
+		rtpSink := s.createNewRTPSink(rtpGroupSock, rtpPayloadType)
+		mediaSource := s.createNewStreamSource()
+
+		s.streamState = newStreamState(s, s.portNum, s.portNum+1, rtpSink, nil, 500,
+			mediaSource, rtpGroupSock, rtcpGroupSock)
+		// (The groupsocks send to the group already, so this adds no destinations.)
+		s.dests = newDestinations(nil, s.groupAddr, s.portNum, s.portNum+1, 0, 0)
+	}
+
+	return &StreamParameter{
+		IsMulticast:     true,
+		ServerRTPPort:   s.portNum,
+		ServerRTCPPort:  s.portNum + 1,
+		DestinationTTL:  s.ttl,
+		DestinationAddr: s.groupAddr,
+		StreamToken:     s.streamState,
+	}
+}
+
+// StartStream starts the stream, unless it's already being sent to the group.
+func (s *MulticastServerMediaSubsession) StartStream(clientSessionID string, streamState *StreamState,
+	rtcpRRHandler interface{}) (rtpSeqNum, rtpTimestamp uint32) {
+	s.mutex.Lock()
+	if !s.isPlaying {
+		s.isPlaying = true
+		streamState.startPlaying(s.dests, rtcpRRHandler)
+	}
+	s.mutex.Unlock()
+
+	if streamState.RtpSink() != nil {
+		rtpSeqNum = streamState.RtpSink().currentSeqNo()
+		rtpTimestamp = streamState.RtpSink().presetNextTimestamp()
+	}
+	return
+}
+
+// The stream is shared by every client, so one client can't pause or seek it:
+func (s *MulticastServerMediaSubsession) PauseStream(streamState *StreamState) {}
+
+func (s *MulticastServerMediaSubsession) SeekStream(sessionID string, streamState *StreamState, streamDuration float32) {
+}
+
+// DeleteStream leaves the stream to the other clients of the group.
+func (s *MulticastServerMediaSubsession) DeleteStream(sessionID string, streamState *StreamState) {}
+
+// Close stops the stream, and leaves the group.
+func (s *MulticastServerMediaSubsession) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.streamState != nil {
+		s.streamState.close()
+		s.streamState = nil
+	}
+	s.isPlaying = false
+	s.input.Close()
+}
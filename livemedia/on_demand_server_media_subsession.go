@@ -4,8 +4,11 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"sync"
+	sys "syscall"
 
 	gs "github.com/djwackey/dorsvr/groupsock"
+	"github.com/djwackey/gitea/log"
 )
 
 type OnDemandServerMediaSubsession struct {
@@ -13,10 +16,18 @@ type OnDemandServerMediaSubsession struct {
 	cname            string
 	sdpLines         string
 	portNumForSDP    int
-	initialPortNum   uint
 	reuseFirstSource bool
 	lastStreamToken  *StreamState
 	destinations     map[string]*Destinations
+	// the SRTP master key and salt of the stream, which its "a=crypto" line gives to the
+	// clients; nil means that the stream is sent in the clear
+	srtpKey []byte
+	// the streams of the clients, with the number of clients of each: a stream of its own for
+	// every client, or a single stream that they share, with reuseFirstSource
+	streamStates map[*StreamState]int
+	// guards the streams and the destinations, which the clients change from their goroutines,
+	// and the SDP lines and the SRTP key, which their descriptions read
+	mutex sync.Mutex
 }
 
 type StreamParameter struct {
@@ -31,13 +42,53 @@ type StreamParameter struct {
 }
 
 func (s *OnDemandServerMediaSubsession) initOnDemandServerMediaSubsession(isubsession IServerMediaSubsession) {
-	s.initialPortNum = 6970
 	s.cname, _ = os.Hostname()
 	s.destinations = make(map[string]*Destinations)
+	s.streamStates = make(map[*StreamState]int)
 	s.initBaseClass(isubsession)
 }
 
+// SetReuseFirstSource makes the clients of the subsession share a single stream, which is
+// read from a single source, as a live source has to be: a client that joins gets the stream
+// from where it is, and it can't pause or seek it. The stream ends when its last client leaves.
+// By default, every client gets a stream of its own.
+func (s *OnDemandServerMediaSubsession) SetReuseFirstSource(reuseFirstSource bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.reuseFirstSource = reuseFirstSource
+}
+
+// EnableSRTP makes the subsession send its stream with SRTP (RFC 3711), with a new master key
+// that clients get from the "a=crypto" line (RFC 4568) of its SDP description. Since the
+// description holds the key, it should only be sent over RTSPS.
+func (s *OnDemandServerMediaSubsession) EnableSRTP() error {
+	srtpKey, err := NewSRTPMasterKey()
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.srtpKey, s.sdpLines = srtpKey, ""
+	return nil
+}
+
+// SRTPEnabled reports whether the subsession sends its stream with SRTP.
+func (s *OnDemandServerMediaSubsession) SRTPEnabled() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.srtpKey != nil
+}
+
 func (s *OnDemandServerMediaSubsession) SDPLines() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.makeSDPLines()
+}
+
+// makeSDPLines returns the SDP lines of the track, which are made by the first call. It's
+// called with the mutex held.
+func (s *OnDemandServerMediaSubsession) makeSDPLines() string {
 	if s.sdpLines == "" {
 		rtpPayloadType := 96 + s.TrackNumber() - 1
 
@@ -48,6 +99,7 @@ func (s *OnDemandServerMediaSubsession) SDPLines() string {
 
 		s.setSDPLinesFromRTPSink(dummyRTPSink, inputSource, 500)
 		dummyRTPSink.destroy()
+		dummyRTPSink.waitForPlayer()
 		inputSource.destroy()
 	}
 
@@ -60,7 +112,11 @@ func (s *OnDemandServerMediaSubsession) GetStreamParameters(tcpSocketNum net.Con
 
 	sp := new(StreamParameter)
 
-	if s.lastStreamToken != nil {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.reuseFirstSource && s.lastStreamToken != nil {
+		// The client joins the stream that is already going:
 		streamState := s.lastStreamToken
 		sp.ServerRTPPort = streamState.ServerRTPPort()
 		sp.ServerRTCPPort = streamState.ServerRTCPPort()
@@ -69,44 +125,42 @@ func (s *OnDemandServerMediaSubsession) GetStreamParameters(tcpSocketNum net.Con
 	} else {
 		mediaSource := s.isubsession.createNewStreamSource()
 
-		var dummyAddr string
+		var err error
 		var rtpSink IMediaSink
 		var udpSink *BasicUDPSink
 		var rtpGroupSock, rtcpGroupSock *gs.GroupSock
 
-		sp.ServerRTPPort = s.initialPortNum
 		if clientRTCPPort == 0 {
 			// We're streaming raw UDP (not RTP). Create a single groupsock:
-			for {
-				rtpGroupSock = gs.NewGroupSock(dummyAddr, sp.ServerRTPPort)
-				if rtpGroupSock != nil {
-					break
-				}
-				sp.ServerRTPPort++
+			if rtpGroupSock, sp.ServerRTPPort, err = newGroupSockSingle(); err != nil {
+				log.Error(1, "[GetStreamParameters] %v", err)
+				mediaSource.destroy()
+				return nil
 			}
 			udpSink = NewBasicUDPSink(rtpGroupSock)
 		} else {
 			// Normal case: We're streaming RTP (over UDP or TCP).  Create a pair of
 			// groupsocks (RTP and RTCP), with adjacent port numbers (RTP port number even):
-			for {
-				rtpGroupSock = gs.NewGroupSock(dummyAddr, sp.ServerRTPPort)
-				if rtpGroupSock == nil {
-					sp.ServerRTPPort += 2
-					continue
-				}
-
-				sp.ServerRTCPPort = sp.ServerRTPPort + 1
-				rtcpGroupSock = gs.NewGroupSock(dummyAddr, sp.ServerRTCPPort)
-				if rtcpGroupSock == nil {
-					sp.ServerRTPPort += 2
-					continue
-				}
-				break
+			if rtpGroupSock, rtcpGroupSock, sp.ServerRTPPort, err = newGroupSockPair(); err != nil {
+				log.Error(1, "[GetStreamParameters] %v", err)
+				mediaSource.destroy()
+				return nil
 			}
+			sp.ServerRTCPPort = sp.ServerRTPPort + 1
 			rtpPayloadType := 96 + s.TrackNumber() - 1
 			rtpSink = s.isubsession.createNewRTPSink(rtpGroupSock, rtpPayloadType)
 		}
 
+		var srtp *SRTPContext
+		if s.srtpKey != nil && rtpSink != nil {
+			if srtp, err = NewSRTPContext(s.srtpKey); err != nil {
+				log.Error(1, "[GetStreamParameters] %v", err)
+				mediaSource.destroy()
+				return nil
+			}
+			rtpSink.setSRTP(srtp)
+		}
+
 		// Set up the state of the stream.  The stream will get started later:
 		s.lastStreamToken = newStreamState(s.isubsession,
 			sp.ServerRTPPort,
@@ -117,8 +171,10 @@ func (s *OnDemandServerMediaSubsession) GetStreamParameters(tcpSocketNum net.Con
 			mediaSource,
 			rtpGroupSock,
 			rtcpGroupSock)
+		s.lastStreamToken.srtp = srtp
 		sp.StreamToken = s.lastStreamToken
 	}
+	s.streamStates[sp.StreamToken]++
 
 	// Record these destinations as being for this client session id:
 	dests := newDestinations(tcpSocketNum, destAddr, clientRTPPort, clientRTCPPort, rtpChannelID, rtcpChannelID)
@@ -150,24 +206,31 @@ func (s *OnDemandServerMediaSubsession) setSDPLinesFromRTPSink(rtpSink IMediaSin
 		auxSDPLine = ""
 	}
 
-	ipAddr := "0.0.0.0"
-	sdpFmt := "m=%s %d RTP/AVP %d\r\n" +
-		"c=IN IP4 %s\r\n" +
+	profile, cryptoLine := "RTP/AVP", ""
+	if s.srtpKey != nil {
+		profile, cryptoLine = "RTP/SAVP", srtpCryptoAttribute(s.srtpKey)
+	}
+
+	sdpFmt := "m=%s %d %s %d\r\n" +
+		"%s\r\n" +
 		"b=AS:%d\r\n" +
 		"%s" +
 		"%s" +
 		"%s" +
+		"%s" +
 		"a=control:%s\r\n"
 
 	s.sdpLines = fmt.Sprintf(sdpFmt,
 		mediaType,
 		s.portNumForSDP,
+		profile,
 		rtpPayloadType,
-		ipAddr,
+		s.connectionSDPLine(),
 		estBitrate,
 		rtpmapLine,
 		rangeLine,
 		auxSDPLine,
+		cryptoLine,
 		s.TrackID())
 }
 
@@ -176,13 +239,24 @@ func (s *OnDemandServerMediaSubsession) CNAME() string {
 }
 
 func (s *OnDemandServerMediaSubsession) StartStream(clientSessionID string, streamState *StreamState,
-	rtcpRRHandler, serverRequestAlternativeByteHandler interface{}) (rtpSeqNum, rtpTimestamp uint32) {
+	rtcpRRHandler interface{}) (rtpSeqNum, rtpTimestamp uint32) {
+	s.mutex.Lock()
 	destinations, _ := s.destinations[clientSessionID]
-	go streamState.startPlaying(destinations, rtcpRRHandler, serverRequestAlternativeByteHandler)
-
-	if streamState.RtpSink() != nil {
-		rtpSeqNum = streamState.RtpSink().currentSeqNo()
-		rtpTimestamp = streamState.RtpSink().presetNextTimestamp()
+	isShared := s.reuseFirstSource
+	s.mutex.Unlock()
+
+	wasPlaying := streamState.startPlaying(destinations, rtcpRRHandler)
+
+	if rtpSink := streamState.RtpSink(); rtpSink != nil {
+		rtpSeqNum = rtpSink.currentSeqNo()
+		if isShared && wasPlaying {
+			// The other clients are receiving the stream, so its timestamps go on as they are:
+			var timeNow sys.Timeval
+			sys.Gettimeofday(&timeNow)
+			rtpTimestamp = rtpSink.convertToRTPTimestamp(timeNow)
+		} else {
+			rtpTimestamp = rtpSink.presetNextTimestamp()
+		}
 	}
 	return
 }
@@ -193,19 +267,53 @@ func (s *OnDemandServerMediaSubsession) SeekStream(sessionID string, streamState
 	}
 }
 
+// PauseStream pauses the stream of a client, unless the stream is shared by the clients.
 func (s *OnDemandServerMediaSubsession) PauseStream(streamState *StreamState) {
+	if s.reuseFirstSource {
+		return
+	}
 	streamState.pause()
 }
 
+// DeleteStream stops sending the stream to a client. The stream ends when it has no clients left.
 func (s *OnDemandServerMediaSubsession) DeleteStream(sessionID string, streamState *StreamState) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	if dest, existed := s.destinations[sessionID]; existed {
-		streamState.endPlaying(dest)
+		if streamState != nil {
+			streamState.endPlaying(dest)
+		}
 		delete(s.destinations, sessionID)
 	}
 
-	if streamState != nil {
-		streamState.reclaim()
+	numClients, existed := s.streamStates[streamState]
+	if !existed {
+		return
+	}
+	if numClients > 1 {
+		s.streamStates[streamState] = numClients - 1
+		return
+	}
+
+	delete(s.streamStates, streamState)
+	if s.lastStreamToken == streamState {
+		s.lastStreamToken = nil
 	}
+	streamState.close()
+}
+
+// Close ends the stream of every client, and closes its sockets.
+func (s *OnDemandServerMediaSubsession) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for streamState := range s.streamStates {
+		streamState.close()
+	}
+	s.streamStates = make(map[*StreamState]int)
+	s.lastStreamToken = nil
+	s.destinations = make(map[string]*Destinations)
 }
 
 //////// Destinations ////////
@@ -0,0 +1,369 @@
+package livemedia
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	sys "syscall"
+
+	gs "github.com/djwackey/dorsvr/groupsock"
+	"github.com/djwackey/gitea/log"
+)
+
+// the number of frames that may be queued for a slow player before frames are dropped
+const relayQueueSize = 64
+
+// RecordServerMediaSubsession is a track of a stream that is pushed to us by a
+// client using ANNOUNCE and RECORD. The frames that it receives are relayed to
+// every client that plays the stream.
+type RecordServerMediaSubsession struct {
+	OnDemandServerMediaSubsession
+	mediumName            string
+	codecName             string
+	mediaSDPLines         string
+	rtpPayloadFormat      uint32
+	rtpTimestampFrequency uint32
+	numChannels           uint32
+	bandWidth             uint
+	isTCP                 bool
+	isRecording           bool
+	rtpChannelID          uint
+	rtcpChannelID         uint
+	rtpGroupSock          *gs.GroupSock
+	rtcpGroupSock         *gs.GroupSock
+	rtpSource             *MultiFramedRTPSource
+	readSource            IFramedSource
+	rtcpInstance          *RTCPInstance
+	frameBuffer           []byte
+	// guards the recording state above, and relaySources; the frames are read by a goroutine
+	// of the RTP source, while the client's connection starts and stops the recording
+	relayMutex   sync.Mutex
+	relaySources map[*relaySource]bool
+}
+
+// NewRecordServerMediaSession creates a session whose tracks are described by the
+// SDP description that a client sent with ANNOUNCE. It returns nil if the
+// description doesn't contain any usable tracks.
+func NewRecordServerMediaSession(streamName, sdpDescription string) *ServerMediaSession {
+	mediaSession := NewMediaSession(sdpDescription)
+	if mediaSession == nil || !mediaSession.HasSubsessions() {
+		return nil
+	}
+
+	description := mediaSession.sessionName
+	if description == "" {
+		description = "Live stream"
+	}
+
+	sms := NewServerMediaSession(description, streamName)
+	for {
+		subsession := mediaSession.Subsession()
+		if subsession == nil {
+			break
+		}
+
+		if subsession.protocolName != "RTP" {
+			log.Warn("[NewRecordServerMediaSession] ignored the unsupported %s track of \"%s\"",
+				subsession.mediumName, streamName)
+			continue
+		}
+		sms.AddSubsession(newRecordServerMediaSubsession(subsession))
+	}
+
+	if sms.SubsessionCounter == 0 {
+		return nil
+	}
+	return sms
+}
+
+func newRecordServerMediaSubsession(subsession *MediaSubsession) *RecordServerMediaSubsession {
+	s := &RecordServerMediaSubsession{
+		mediumName:            subsession.mediumName,
+		codecName:             subsession.codecName,
+		mediaSDPLines:         subsession.savedSDPLines,
+		rtpPayloadFormat:      subsession.rtpPayloadFormat,
+		rtpTimestampFrequency: subsession.rtpTimestampFrequency,
+		numChannels:           subsession.numChannels,
+		bandWidth:             subsession.bandWidth,
+		frameBuffer:           make([]byte, OutPacketBufferMaxSize),
+		relaySources:          make(map[*relaySource]bool),
+	}
+	s.initOnDemandServerMediaSubsession(s)
+	// The players share one stream of the frames, which is live:
+	s.reuseFirstSource = true
+
+	// Keep the track id that the client chose, because it will SETUP the track by it:
+	controlPath := subsession.controlPath
+	if i := strings.LastIndex(controlPath, "/"); i != -1 {
+		controlPath = controlPath[i+1:]
+	}
+	if controlPath != "*" {
+		s.trackID = controlPath
+	}
+	return s
+}
+
+// SDPLines returns the media-level SDP lines that were announced for this track,
+// with the "a=control:" line replaced by our own.
+func (s *RecordServerMediaSubsession) SDPLines() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.sdpLines == "" {
+		var sdpLines string
+		for _, line := range strings.Split(s.mediaSDPLines, "\r\n") {
+			if line == "" || strings.HasPrefix(line, "c=") || strings.HasPrefix(line, "a=control:") {
+				continue
+			}
+			if strings.HasPrefix(line, "m=") {
+				line = fmt.Sprintf("m=%s 0 RTP/AVP %d\r\n%s", s.mediumName, s.rtpPayloadFormat, s.connectionSDPLine())
+			}
+			sdpLines += line + "\r\n"
+		}
+		s.sdpLines = sdpLines + fmt.Sprintf("a=control:%s\r\n", s.TrackID())
+	}
+	return s.sdpLines
+}
+
+// GetRecordParameters sets up the sockets (or the RTSP connection's channels, if
+// tcpSocketNum isn't nil) on which we'll receive the stream from the client.
+func (s *RecordServerMediaSubsession) GetRecordParameters(tcpSocketNum net.Conn, destAddr string,
+	clientRTPPort, clientRTCPPort, rtpChannelID, rtcpChannelID uint) *StreamParameter {
+	s.relayMutex.Lock()
+	defer s.relayMutex.Unlock()
+
+	if s.rtpSource != nil {
+		// this track is already being received
+		return nil
+	}
+
+	sp := new(StreamParameter)
+
+	if tcpSocketNum != nil {
+		s.isTCP = true
+		s.rtpChannelID = rtpChannelID
+		s.rtcpChannelID = rtcpChannelID
+	} else {
+		var err error
+		if s.rtpGroupSock, s.rtcpGroupSock, sp.ServerRTPPort, err = newGroupSockPair(); err != nil {
+			log.Error(1, "[GetRecordParameters] %v", err)
+			return nil
+		}
+		sp.ServerRTCPPort = sp.ServerRTPPort + 1
+
+		// Our RTCP "RR"s go back to the client:
+		s.rtcpGroupSock.AddDestination(destAddr, clientRTCPPort)
+	}
+
+	switch s.codecName {
+	case "H264":
+		source := newH264VideoRTPSource(s.rtpGroupSock, s.rtpPayloadFormat, s.rtpTimestampFrequency)
+		s.rtpSource, s.readSource = &source.MultiFramedRTPSource, source
+	default:
+		source := newSimpleRTPSource(s.rtpGroupSock, s.rtpPayloadFormat, s.rtpTimestampFrequency)
+		s.rtpSource, s.readSource = &source.MultiFramedRTPSource, source
+	}
+
+	totSessionBandwidth := s.bandWidth + s.bandWidth/20
+	if totSessionBandwidth == 0 {
+		totSessionBandwidth = 500
+	}
+	s.rtcpInstance = newRTCPInstance(s.rtcpGroupSock, totSessionBandwidth, s.CNAME(), nil, &s.rtpSource.RTPSource, nil)
+
+	sp.ClientRTPPort = clientRTPPort
+	sp.ClientRTCPPort = clientRTCPPort
+	sp.DestinationAddr = destAddr
+	return sp
+}
+
+// HandleInterleavedPacket takes a RTP or RTCP packet that the client sent over the
+// RTSP connection. It returns false if the channel doesn't belong to this track.
+func (s *RecordServerMediaSubsession) HandleInterleavedPacket(channelID uint, packet []byte) bool {
+	s.relayMutex.Lock()
+	defer s.relayMutex.Unlock()
+
+	if !s.isTCP || s.readSource == nil {
+		return false
+	}
+
+	switch channelID {
+	case s.rtpChannelID:
+		s.rtpSource.rtpInterface.deliverTCPPacket(packet)
+	case s.rtcpChannelID:
+		s.rtcpInstance.netInterface.deliverTCPPacket(packet)
+	default:
+		return false
+	}
+	return true
+}
+
+// StartRecording starts reading the frames that the client sends.
+func (s *RecordServerMediaSubsession) StartRecording() {
+	s.relayMutex.Lock()
+	defer s.relayMutex.Unlock()
+
+	if s.isRecording || s.readSource == nil {
+		return
+	}
+	s.isRecording = true
+
+	// (This starts the goroutine that reads the frames.)
+	s.readSource.GetNextFrame(s.frameBuffer, uint(len(s.frameBuffer)), s.afterGettingFrame, s.readSourceClosed)
+}
+
+// StopRecording closes the receiving sockets, and ends the stream for every player.
+func (s *RecordServerMediaSubsession) StopRecording() {
+	s.stopRecording(true)
+}
+
+// readSourceClosed stops the recording once the client's stream has ended. It's called by
+// the goroutine that reads the frames, which is ending.
+func (s *RecordServerMediaSubsession) readSourceClosed() {
+	s.stopRecording(false)
+}
+
+func (s *RecordServerMediaSubsession) stopRecording(waitForReader bool) {
+	s.relayMutex.Lock()
+	if !s.isRecording && s.readSource == nil {
+		s.relayMutex.Unlock()
+		return
+	}
+	wasRecording := s.isRecording
+	s.isRecording = false
+	readSource, rtpSource, rtcpInstance := s.readSource, s.rtpSource, s.rtcpInstance
+	s.readSource, s.rtcpInstance = nil, nil
+
+	for source := range s.relaySources {
+		source.close()
+	}
+	s.relayMutex.Unlock()
+
+	if readSource != nil {
+		// Make the reading goroutine give up, and wait for it before we touch the source:
+		rtpSource.rtpInterface.stopNetworkReading()
+		if wasRecording && waitForReader {
+			rtpSource.rtpInterface.waitForNetworkReading()
+		}
+		readSource.stopGettingFrames()
+	}
+	if rtcpInstance != nil {
+		rtcpInstance.destroy()
+	}
+}
+
+// Close stops receiving the stream from the client, and ends it for every player.
+func (s *RecordServerMediaSubsession) Close() {
+	s.StopRecording()
+	s.OnDemandServerMediaSubsession.Close()
+}
+
+func (s *RecordServerMediaSubsession) afterGettingFrame(frameSize, durationInMicroseconds uint,
+	presentationTime sys.Timeval) {
+	frame := &relayFrame{
+		data:             make([]byte, frameSize),
+		presentationTime: presentationTime,
+	}
+	copy(frame.data, s.frameBuffer[:frameSize])
+
+	s.relayMutex.Lock()
+	for source := range s.relaySources {
+		source.deliver(frame)
+	}
+	readSource := s.readSource
+	s.relayMutex.Unlock()
+
+	// Then try getting the next frame:
+	if readSource != nil {
+		readSource.GetNextFrame(s.frameBuffer, uint(len(s.frameBuffer)), s.afterGettingFrame, s.StopRecording)
+	}
+}
+
+func (s *RecordServerMediaSubsession) createNewStreamSource() IFramedSource {
+	source := newRelaySource(s)
+
+	s.relayMutex.Lock()
+	s.relaySources[source] = true
+	s.relayMutex.Unlock()
+	return source
+}
+
+// The payload type is the one that the client announced, so that the SDP lines that we
+// pass on stay correct.
+func (s *RecordServerMediaSubsession) createNewRTPSink(rtpGroupSock *gs.GroupSock, rtpPayloadType uint) IMediaSink {
+	switch s.codecName {
+	case "H264":
+		return newH264VideoRTPSink(rtpGroupSock, s.rtpPayloadFormat)
+	default:
+		return newSimpleRTPSink(rtpGroupSock, s.rtpPayloadFormat, s.rtpTimestampFrequency, s.numChannels,
+			s.mediumName, s.codecName, false, true)
+	}
+}
+
+func (s *RecordServerMediaSubsession) removeRelaySource(source *relaySource) {
+	s.relayMutex.Lock()
+	delete(s.relaySources, source)
+	s.relayMutex.Unlock()
+}
+
+type relayFrame struct {
+	data             []byte
+	presentationTime sys.Timeval
+}
+
+// relaySource feeds a player's RTP sink with the frames of a recorded track.
+type relaySource struct {
+	FramedSource
+	sourceWaker
+	master  *RecordServerMediaSubsession
+	frames  chan *relayFrame
+	closed  chan struct{}
+	closing sync.Once
+}
+
+func newRelaySource(master *RecordServerMediaSubsession) *relaySource {
+	source := &relaySource{
+		sourceWaker: newSourceWaker(),
+		master:      master,
+		frames:      make(chan *relayFrame, relayQueueSize),
+		closed:      make(chan struct{}),
+	}
+	source.initFramedSource(source)
+	return source
+}
+
+func (s *relaySource) doGetNextFrame() error {
+	select {
+	case frame := <-s.frames:
+		s.frameSize = uint(copy(s.buffTo[:s.maxSize], frame.data))
+		s.numTruncatedBytes = uint(len(frame.data)) - s.frameSize
+		s.presentationTime = frame.presentationTime
+		s.durationInMicroseconds = 0
+		s.afterGetting()
+	case <-s.closed:
+		s.handleClosure()
+	case <-s.wake:
+		// The stream is being stopped:
+		s.isCurrentlyAwaitingData = false
+	}
+	return nil
+}
+
+// deliver queues a frame, dropping it if the player isn't keeping up.
+func (s *relaySource) deliver(frame *relayFrame) {
+	select {
+	case s.frames <- frame:
+	default:
+	}
+}
+
+func (s *relaySource) close() {
+	s.closing.Do(func() {
+		close(s.closed)
+	})
+}
+
+func (s *relaySource) destroy() {
+	s.master.removeRelaySource(s)
+	s.close()
+}
@@ -8,8 +8,9 @@ const (
 	eventBye     = 2
 )
 
-func drand48() int32 {
-	return gs.OurRandom()
+// drand48 returns a pseudo-random number in the range [0.0, 1.0)
+func drand48() float64 {
+	return float64(gs.OurRandom()) / (1 << 31)
 }
 
 func rtcpInterval(members, senders, weSent, rtcpBW, avgRtcpSize float64) float64 {
@@ -36,7 +37,7 @@ func rtcpInterval(members, senders, weSent, rtcpBW, avgRtcpSize float64) float64
 		t = rtcpMinTime
 	}
 
-	t = t * (float64(drand48()) + 0.5)
+	t = t * (drand48() + 0.5)
 	t = t / COMPENSATION
 	return t
 }
@@ -66,6 +66,7 @@ type RTCPInstance struct {
 	SRHandlerTask        interface{}
 	RRHandlerTask        interface{}
 	byeHandlerClientData interface{}
+	reportTimer          *time.Timer
 }
 
 func newSDESItem(tag int, value string) *SDESItem {
@@ -90,11 +91,7 @@ func (s *SDESItem) totalSize() uint {
 }
 
 func newRTCPInstance(rtcpGS *gs.GroupSock, totSessionBW uint, cname string,
-	sink IMediaSink, source *RTPSource) *RTCPInstance {
-	// saved OutPacketBuffer's max size temporarily
-	savedMaxSize := OutPacketBufferMaxSize
-	OutPacketBufferMaxSize = maxRTCPPacketSize
-
+	sink IMediaSink, source *RTPSource, srtp *SRTPContext) *RTCPInstance {
 	reportTime := dTimeNow()
 	rtcp := &RTCPInstance{
 		typeOfEvent:    eventReport,
@@ -102,13 +99,11 @@ func newRTCPInstance(rtcpGS *gs.GroupSock, totSessionBW uint, cname string,
 		prevReportTime: reportTime,
 		nextReportTime: reportTime,
 		CNAME:          newSDESItem(RTCP_SDES_CNAME, cname),
-		outBuf:         newOutPacketBuffer(preferredPacketSize, maxRTCPPacketSize),
+		outBuf:         newOutPacketBufferOfSize(preferredPacketSize, maxRTCPPacketSize, maxRTCPPacketSize),
 		inBuf:          make([]byte, maxRTCPPacketSize),
 		Sink:           sink,
 		Source:         source,
 	}
-	// resume common OutPacketBuffer's max size
-	OutPacketBufferMaxSize = savedMaxSize
 
 	if rtcp.totSessionBW == 0 {
 		log.Warn("[newRTCPInstance] totSessionBW can't be zero!")
@@ -116,6 +111,7 @@ func newRTCPInstance(rtcpGS *gs.GroupSock, totSessionBW uint, cname string,
 	}
 
 	rtcp.netInterface = newRTPInterface(rtcp, rtcpGS)
+	rtcp.netInterface.setSRTP(srtp, true)
 	rtcp.netInterface.startNetworkReading(rtcp.incomingReportHandler)
 
 	rtcp.onExpire()
@@ -452,9 +448,7 @@ func (r *RTCPInstance) schedule(nextTime int64) {
 	if secondsToDelay < 0 {
 		secondsToDelay = 0
 	}
-	usToGo := secondsToDelay * 1000000000
-	time.Sleep(time.Duration(usToGo) * time.Microsecond)
-	go r.onExpire()
+	r.reportTimer = time.AfterFunc(time.Duration(secondsToDelay)*time.Second, r.onExpire)
 }
 
 func (r *RTCPInstance) onExpire() {
@@ -558,6 +552,9 @@ func (r *RTCPInstance) enqueueReportBlock(stats *RTPReceptionStats) {
 }
 
 func (r *RTCPInstance) destroy() {
+	if r.reportTimer != nil {
+		r.reportTimer.Stop()
+	}
 	r.sendBye()
 	r.netInterface.stopNetworkReading()
 }
@@ -1,43 +1,77 @@
 package livemedia
 
 import (
+	"io"
 	"net"
+	"sync"
 
 	gs "github.com/djwackey/dorsvr/groupsock"
 	"github.com/djwackey/gitea/log"
 )
 
 type RTPInterface struct {
-	gs                         *gs.GroupSock
-	owner                      interface{}
-	auxReadHandlerFunc         interface{}
-	nextTCPReadStreamSocketNum net.Conn
-	socketDescriptors          map[net.Conn]*SocketDescriptor
-	tcpStreams                 *tcpStreamRecord
-	nextTCPReadSize            uint
-	nextTCPReadStreamChannelID uint
-}
+	gs                 *gs.GroupSock
+	owner              interface{}
+	auxReadHandlerFunc interface{}
+	tcpStreams         *tcpStreamRecord
+	// packets received "interleaved" on a RTSP connection, when we have no groupsock.
+	// The RTSP connection itself reads the socket, and hands the packets to us.
+	tcpReadPackets chan []byte
+	tcpReadClosed  chan struct{}
+	// closed when the goroutine that reads the packets has ended
+	readingDone chan struct{}
+	// SRTP: what protects the packets (nil if they're sent in the clear),
+	// and whether they're RTCP packets
+	srtp   *SRTPContext
+	isRTCP bool
+	// guards tcpStreams, which the clients of a shared stream join and leave while it's sent
+	tcpStreamsMutex sync.Mutex
+}
+
+// the maximum number of RTP-over-TCP packets queued for the reader
+const tcpReadQueueSize = 256
 
 func newRTPInterface(owner interface{}, gs *gs.GroupSock) *RTPInterface {
 	return &RTPInterface{
-		gs:                gs,
-		owner:             owner,
-		socketDescriptors: make(map[net.Conn]*SocketDescriptor),
+		gs:             gs,
+		owner:          owner,
+		tcpReadPackets: make(chan []byte, tcpReadQueueSize),
+		tcpReadClosed:  make(chan struct{}),
 	}
 }
 
+// setSRTP protects the packets that are sent and received with a SRTP context;
+// it's to be called before any packets are.
+func (i *RTPInterface) setSRTP(srtp *SRTPContext, isRTCP bool) {
+	i.srtp, i.isRTCP = srtp, isRTCP
+}
+
 func (i *RTPInterface) startNetworkReading(handlerProc interface{}) {
-	go handlerProc.(func())()
+	done := make(chan struct{})
+	i.readingDone = done
+	go func() {
+		defer close(done)
+		handlerProc.(func())()
+	}()
 }
 
 func (i *RTPInterface) stopNetworkReading() {
-	i.gs.Close()
+	if i.gs != nil {
+		i.gs.Close()
+	}
+
+	select {
+	case <-i.tcpReadClosed:
+	default:
+		close(i.tcpReadClosed)
+	}
 }
 
-func (i *RTPInterface) setServerRequestAlternativeByteHandler(socketNum net.Conn, handler interface{}) {
-	descriptor := i.lookupSocketDescriptor(socketNum)
-	if descriptor != nil {
-		descriptor.setServerRequestAlternativeByteHandler(handler)
+// waitForNetworkReading waits until the goroutine that read the packets has ended, after
+// stopNetworkReading. It's not to be called by that goroutine.
+func (i *RTPInterface) waitForNetworkReading() {
+	if i.readingDone != nil {
+		<-i.readingDone
 	}
 }
 
@@ -46,6 +80,9 @@ func (i *RTPInterface) addStreamSocket(socketNum net.Conn, streamChannelID uint)
 		return
 	}
 
+	i.tcpStreamsMutex.Lock()
+	defer i.tcpStreamsMutex.Unlock()
+
 	var streams *tcpStreamRecord
 	for streams = i.tcpStreams; streams != nil; streams = streams.next {
 		if streams.streamSocketNum == socketNum && streams.streamChannelID == streamChannelID {
@@ -54,28 +91,39 @@ func (i *RTPInterface) addStreamSocket(socketNum net.Conn, streamChannelID uint)
 	}
 
 	i.tcpStreams = newTCPStreamRecord(socketNum, streamChannelID, i.tcpStreams)
-
-	// Also, make sure this new socket is set up for receiving RTP/RTCP over TCP:
-	descriptor := i.lookupSocketDescriptor(socketNum)
-	descriptor.registerRTPInterface(streamChannelID, i)
 }
 
 func (i *RTPInterface) delStreamSocket(socketNum net.Conn, streamChannelID uint) {
-	var streams *tcpStreamRecord
-	for streams = i.tcpStreams; streams != nil; streams = streams.next {
-		if streams.streamSocketNum == socketNum && streams.streamChannelID == streamChannelID {
-			i.deregisterSocket(socketNum, streamChannelID)
-
-			next := streams.next
-			streams.next = nil
-			streams = next
+	i.tcpStreamsMutex.Lock()
+	defer i.tcpStreamsMutex.Unlock()
+
+	for streamsPtr := &i.tcpStreams; *streamsPtr != nil; streamsPtr = &(*streamsPtr).next {
+		if (*streamsPtr).streamSocketNum == socketNum && (*streamsPtr).streamChannelID == streamChannelID {
+			// Unlink the record; the socket itself belongs to the RTSP connection:
+			*streamsPtr = (*streamsPtr).next
+			break
 		}
 	}
 }
 
 // normal case: send as a UDP packet, also, send over each of our TCP sockets
 func (i *RTPInterface) sendPacket(packet []byte, packetSize uint) bool {
-	success := i.gs.Output(packet, packetSize)
+	if i.srtp != nil {
+		protected, err := i.protect(packet[:packetSize])
+		if err != nil {
+			log.Warn("[RTPInterface::sendPacket] %v", err)
+			return false
+		}
+		packet, packetSize = protected, uint(len(protected))
+	}
+
+	success := true
+	if i.gs != nil {
+		success = i.gs.Output(packet, packetSize)
+	}
+
+	i.tcpStreamsMutex.Lock()
+	defer i.tcpStreamsMutex.Unlock()
 
 	var streams *tcpStreamRecord
 	for streams = i.tcpStreams; streams != nil; streams = streams.next {
@@ -86,31 +134,62 @@ func (i *RTPInterface) sendPacket(packet []byte, packetSize uint) bool {
 }
 
 func (i *RTPInterface) handleRead(buffer []byte) (int, error) {
-	return i.gs.HandleRead(buffer)
+	for {
+		readBytes, err := i.readPacket(buffer)
+		if err != nil || i.srtp == nil {
+			return readBytes, err
+		}
+
+		// Drop the packets that fail to authenticate:
+		packet, err := i.unprotect(buffer[:readBytes])
+		if err != nil {
+			log.Warn("[RTPInterface::handleRead] dropped a %d-byte packet: %v", readBytes, err)
+			continue
+		}
+		return copy(buffer, packet), nil
+	}
 }
 
-func (i *RTPInterface) lookupSocketDescriptor(socketNum net.Conn) *SocketDescriptor {
-	var existed bool
-	var descriptor *SocketDescriptor
-	if descriptor, existed = i.socketDescriptors[socketNum]; existed {
-		return descriptor
+func (i *RTPInterface) protect(packet []byte) ([]byte, error) {
+	if i.isRTCP {
+		return i.srtp.ProtectRTCP(packet)
 	}
+	return i.srtp.ProtectRTP(packet)
+}
 
-	descriptor = newSocketDescriptor(socketNum)
-	i.socketDescriptors[socketNum] = descriptor
-	return descriptor
+func (i *RTPInterface) unprotect(packet []byte) ([]byte, error) {
+	if i.isRTCP {
+		return i.srtp.UnprotectRTCP(packet)
+	}
+	return i.srtp.UnprotectRTP(packet)
 }
 
-func (i *RTPInterface) deregisterSocket(socketNum net.Conn, streamChannelID uint) {
-	descriptor := i.lookupSocketDescriptor(socketNum)
-	if descriptor != nil {
-		i.removeSocketDescriptor(socketNum)
-		descriptor.deregisterRTPInterface(streamChannelID)
+func (i *RTPInterface) readPacket(buffer []byte) (int, error) {
+	if i.gs != nil {
+		return i.gs.HandleRead(buffer)
+	}
+
+	// RTP-over-TCP: wait for the RTSP connection to hand us a packet
+	select {
+	case packet := <-i.tcpReadPackets:
+		return copy(buffer, packet), nil
+	case <-i.tcpReadClosed:
+		return 0, io.EOF
 	}
 }
 
-func (i *RTPInterface) removeSocketDescriptor(socketNum net.Conn) {
-	delete(i.socketDescriptors, socketNum)
+// deliverTCPPacket queues a packet that was read "interleaved" from a RTSP connection
+// (RFC 2326, section 10.12). The packet is dropped if the reader has fallen behind.
+func (i *RTPInterface) deliverTCPPacket(packet []byte) {
+	data := make([]byte, len(packet))
+	copy(data, packet)
+
+	select {
+	case i.tcpReadPackets <- data:
+	case <-i.tcpReadClosed:
+	default:
+		log.Warn("[RTPInterface::deliverTCPPacket] dropped a %d-byte packet", len(packet))
+	}
 }
 
 type tcpStreamRecord struct {
@@ -129,137 +208,17 @@ func newTCPStreamRecord(streamSocketNum net.Conn, streamChannelID uint, next *tc
 
 ///////////// Help Functions ///////////////
 
-// Send RTP over TCP, using the encoding defined RFC 2326, section 10.12:
+// Send RTP over TCP, using the encoding defined RFC 2326, section 10.12.
+// The frame is written at once, so that it doesn't get mixed up with the RTSP
+// responses, or with other streams' packets, that are written on the same socket:
 func sendRTPOverTCP(socketNum net.Conn, packet []byte, packetSize, streamChannelID uint) error {
-	var err error
-
-	dollar := []byte{'$'}
-	_, err = socketNum.Write(dollar)
-	if err != nil {
-		return err
-	}
-
-	channelID := []byte{byte(streamChannelID)}
-	_, err = socketNum.Write(channelID)
-	if err != nil {
-		return err
-	}
-
-	netPacketSize := make([]byte, 2)
-	netPacketSize[0] = byte((packetSize & 0xFF00) >> 8)
-	netPacketSize[1] = byte(packetSize & 0xFF)
-	_, err = socketNum.Write(netPacketSize)
-	if err != nil {
-		return err
-	}
-
-	_, err = socketNum.Write(packet[:packetSize])
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-const (
-	awaitingDollar = iota
-	awaitingStreamChannelID
-	awaitingSize1
-	awaitingSize2
-	awaitingPacketData
-)
-
-type SocketDescriptor struct {
-	tcpReadingState                     int
-	streamChannelID                     uint
-	sizeByte1                           uint
-	socketNum                           net.Conn
-	serverRequestAlternativeByteHandler interface{}
-	subChannels                         map[uint]*RTPInterface
-}
-
-func newSocketDescriptor(socketNum net.Conn) *SocketDescriptor {
-	return &SocketDescriptor{
-		socketNum:       socketNum,
-		tcpReadingState: awaitingDollar,
-		subChannels:     make(map[uint]*RTPInterface),
-	}
-}
-
-func (s *SocketDescriptor) registerRTPInterface(streamChannelID uint, rtpInterface *RTPInterface) {
-	s.subChannels[streamChannelID] = rtpInterface
-	go s.tcpReadHandler(rtpInterface)
-}
-
-func (s *SocketDescriptor) lookupRTPInterface(streamChannelID uint) (rtpInterface *RTPInterface, existed bool) {
-	rtpInterface, existed = s.subChannels[streamChannelID]
-	return
-}
-
-func (s *SocketDescriptor) deregisterRTPInterface(streamChannelID uint) {
-	defer s.socketNum.Close()
-	if s.serverRequestAlternativeByteHandler != nil {
-		s.serverRequestAlternativeByteHandler.(func(requestByte uint))(0xFE)
-	}
-	delete(s.subChannels, streamChannelID)
-}
-
-func (s *SocketDescriptor) tcpReadHandler(rtpInterface *RTPInterface) {
-	defer s.socketNum.Close()
-	buffer := make([]byte, 1)
-	for {
-		if s.tcpReadingState != awaitingPacketData {
-			_, err := gs.ReadSocket(s.socketNum, buffer)
-			if err != nil {
-				if s.serverRequestAlternativeByteHandler != nil {
-					s.serverRequestAlternativeByteHandler.(func(requestByte uint))(0xFF)
-				}
-				rtpInterface.removeSocketDescriptor(s.socketNum)
-				break
-			}
-		}
-	}
-
-	switch s.tcpReadingState {
-	case awaitingDollar:
-		if buffer[0] == '$' {
-			log.Debug("[SocketDescriptor::tcpReadHandler] Saw '$'")
-			s.tcpReadingState = awaitingStreamChannelID
-		} else {
-			// This character is part of a RTSP request or command, which is handled separately:
-			if s.serverRequestAlternativeByteHandler != nil && buffer[0] != 0xFF && buffer[0] != 0xFE {
-				s.serverRequestAlternativeByteHandler.(func(requestByte uint))(uint(buffer[0]))
-			}
-		}
-	case awaitingStreamChannelID:
-		// The byte that we read is the stream channel id.
-		if _, existed := s.lookupRTPInterface(uint(buffer[0])); existed {
-			s.streamChannelID = uint(buffer[0])
-			s.tcpReadingState = awaitingSize1
-		} else {
-			s.tcpReadingState = awaitingDollar
-		}
-	case awaitingSize1:
-		// The byte that we read is the first (high) byte of the 16-bit RTP or RTCP packet 'size'.
-		s.sizeByte1 = uint(buffer[0])
-		s.tcpReadingState = awaitingSize2
-	case awaitingSize2:
-		// The byte that we read is the second (low) byte of the 16-bit RTP or RTCP packet 'size'.
-		size := (s.sizeByte1 << 8) | uint(buffer[0])
-
-		// Record the information about the packet data that will be read next:
-		rtpInterface, existed := s.lookupRTPInterface(s.streamChannelID)
-		if existed {
-			rtpInterface.nextTCPReadSize = size
-			rtpInterface.nextTCPReadStreamSocketNum = s.socketNum
-			rtpInterface.nextTCPReadStreamChannelID = s.streamChannelID
-		}
-		s.tcpReadingState = awaitingPacketData
-	case awaitingPacketData:
-		s.tcpReadingState = awaitingDollar
-	}
-}
-
-func (s *SocketDescriptor) setServerRequestAlternativeByteHandler(handler interface{}) {
-	s.serverRequestAlternativeByteHandler = handler
+	frame := make([]byte, 4+packetSize)
+	frame[0] = '$'
+	frame[1] = byte(streamChannelID)
+	frame[2] = byte((packetSize & 0xFF00) >> 8)
+	frame[3] = byte(packetSize & 0xFF)
+	copy(frame[4:], packet[:packetSize])
+
+	_, err := socketNum.Write(frame)
+	return err
 }
@@ -0,0 +1,68 @@
+package livemedia
+
+import (
+	"errors"
+	"fmt"
+
+	gs "github.com/djwackey/dorsvr/groupsock"
+)
+
+// the UDP ports on which streams are sent and received; see SetRTPPortRange
+var (
+	rtpPortRangeStart uint = 6970
+	rtpPortRangeEnd   uint = 65535
+)
+
+var errNoFreePorts = errors.New("no free ports are left in the RTP port range")
+
+// SetRTPPortRange sets the range of the UDP ports that streams are sent from (and received on).
+// RTP uses an even port, and RTCP the odd port after it. It should be called before any
+// stream is set up.
+func SetRTPPortRange(start, end uint) error {
+	if start%2 == 1 {
+		start++
+	}
+	if start == 0 || end > 65535 || start+1 > end {
+		return fmt.Errorf("bad RTP port range %d-%d", start, end)
+	}
+
+	rtpPortRangeStart, rtpPortRangeEnd = start, end
+	return nil
+}
+
+// RTPPortRange returns the range of the UDP ports that streams are sent from.
+func RTPPortRange() (start, end uint) {
+	return rtpPortRangeStart, rtpPortRangeEnd
+}
+
+// newGroupSockPair creates a groupsock for RTP on the first free even port of the range,
+// and one for RTCP on the port after it.
+func newGroupSockPair() (rtpGroupSock, rtcpGroupSock *gs.GroupSock, rtpPort uint, err error) {
+	var dummyAddr string
+	for rtpPort = rtpPortRangeStart; rtpPort+1 <= rtpPortRangeEnd; rtpPort += 2 {
+		rtpGroupSock = gs.NewGroupSock(dummyAddr, rtpPort)
+		if rtpGroupSock == nil {
+			continue
+		}
+
+		rtcpGroupSock = gs.NewGroupSock(dummyAddr, rtpPort+1)
+		if rtcpGroupSock == nil {
+			rtpGroupSock.Close()
+			continue
+		}
+		return rtpGroupSock, rtcpGroupSock, rtpPort, nil
+	}
+	return nil, nil, 0, errNoFreePorts
+}
+
+// newGroupSockSingle creates a groupsock on the first free port of the range,
+// for a stream that has no RTCP.
+func newGroupSockSingle() (groupSock *gs.GroupSock, port uint, err error) {
+	var dummyAddr string
+	for port = rtpPortRangeStart; port <= rtpPortRangeEnd; port++ {
+		if groupSock = gs.NewGroupSock(dummyAddr, port); groupSock != nil {
+			return groupSock, port, nil
+		}
+	}
+	return nil, 0, errNoFreePorts
+}
@@ -3,6 +3,8 @@ package livemedia
 import (
 	"fmt"
 	"net"
+	"sync"
+	"sync/atomic"
 	sys "syscall"
 
 	gs "github.com/djwackey/dorsvr/groupsock"
@@ -14,8 +16,6 @@ type RTPSink struct {
 	MediaSink
 	seqNo                       uint32
 	_ssrc                       uint32
-	_octetCount                 uint
-	_packetCount                uint // incl RTP hdr
 	totalOctetCount             uint
 	timestampBase               uint32
 	_rtpPayloadType             uint32
@@ -25,6 +25,11 @@ type RTPSink struct {
 	_nextTimestampHasBeenPreset bool
 	_transmissionStatsDB        *RTPTransmissionStatsDB
 	rtpInterface                *RTPInterface
+	// the counts of the packets and the payload octets that have been sent; they're read by
+	// RTCP and the metrics, from other goroutines
+	_packetCount, _octetCount uint64
+	// guards the timestamp base, which RTCP and the clients' requests use too
+	timestampMutex sync.Mutex
 }
 
 func (s *RTPSink) InitRTPSink(rtpSink IMediaSink, g *gs.GroupSock, rtpPayloadType,
@@ -51,8 +56,12 @@ func (s *RTPSink) delStreamSocket(socketNum net.Conn, streamChannelID uint) {
 	s.rtpInterface.delStreamSocket(socketNum, streamChannelID)
 }
 
+func (s *RTPSink) setSRTP(srtp *SRTPContext) {
+	s.rtpInterface.setSRTP(srtp, false)
+}
+
 func (s *RTPSink) currentSeqNo() uint32 {
-	return s.seqNo
+	return atomic.LoadUint32(&s.seqNo)
 }
 
 func (s *RTPSink) sdpMediaType() string {
@@ -79,11 +88,20 @@ func (s *RTPSink) ssrc() uint32 {
 }
 
 func (s *RTPSink) octetCount() uint {
-	return s._octetCount
+	return uint(atomic.LoadUint64(&s._octetCount))
 }
 
 func (s *RTPSink) packetCount() uint {
-	return s._packetCount
+	return uint(atomic.LoadUint64(&s._packetCount))
+}
+
+// notePacketSent counts a packet that has been sent, and the size of its payload, and moves
+// on to the next sequence number.
+func (s *RTPSink) notePacketSent(packetSize, payloadSize uint) {
+	atomic.AddUint64(&s._packetCount, 1)
+	atomic.AddUint64(&s._octetCount, uint64(payloadSize))
+	s.totalOctetCount += packetSize
+	atomic.AddUint32(&s.seqNo, 1)
 }
 
 func (s *RTPSink) enableRTCPReports() bool {
@@ -91,6 +109,8 @@ func (s *RTPSink) enableRTCPReports() bool {
 }
 
 func (s *RTPSink) nextTimestampHasBeenPreset() bool {
+	s.timestampMutex.Lock()
+	defer s.timestampMutex.Unlock()
 	return s._nextTimestampHasBeenPreset
 }
 
@@ -102,7 +122,10 @@ func (s *RTPSink) presetNextTimestamp() uint32 {
 	var timeNow sys.Timeval
 	sys.Gettimeofday(&timeNow)
 
-	tsNow := s.convertToRTPTimestamp(timeNow)
+	s.timestampMutex.Lock()
+	defer s.timestampMutex.Unlock()
+
+	tsNow := s.rtpTimestamp(timeNow)
 	s.timestampBase = tsNow
 	s._nextTimestampHasBeenPreset = true
 
@@ -110,6 +133,12 @@ func (s *RTPSink) presetNextTimestamp() uint32 {
 }
 
 func (s *RTPSink) convertToRTPTimestamp(tv sys.Timeval) uint32 {
+	s.timestampMutex.Lock()
+	defer s.timestampMutex.Unlock()
+	return s.rtpTimestamp(tv)
+}
+
+func (s *RTPSink) rtpTimestamp(tv sys.Timeval) uint32 {
 	// Begin by converting from "struct timeval" units to RTP timestamp units:
 	timestampIncrement := s.rtpTimestampFrequency * uint32(tv.Sec)
 	timestampIncrement += (2.0*s.rtpTimestampFrequency*uint32(tv.Usec) + 1000000.0) / 2000000
@@ -125,7 +154,3 @@ func (s *RTPSink) convertToRTPTimestamp(tv sys.Timeval) uint32 {
 	// return RTP Timestamp
 	return s.timestampBase + timestampIncrement
 }
-
-func (s *RTPSink) setServerRequestAlternativeByteHandler(socketNum net.Conn, handler interface{}) {
-	s.rtpInterface.setServerRequestAlternativeByteHandler(socketNum, handler)
-}
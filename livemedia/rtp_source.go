@@ -34,3 +34,7 @@ func (s *RTPSource) initRTPSouce(isource IFramedSource, RTPgs *gs.GroupSock,
 
 func (s *RTPSource) SetStreamSocket() {
 }
+
+func (s *RTPSource) setSRTP(srtp *SRTPContext) {
+	s.rtpInterface.setSRTP(srtp, false)
+}
@@ -1,6 +1,7 @@
 package livemedia
 
 import (
+	"sync"
 	sys "syscall"
 )
 
@@ -8,6 +9,7 @@ import (
 type RTPTransmissionStatsDB struct {
 	sink  *RTPSink
 	table map[uint32]*RTPTransmissionStats
+	mutex sync.Mutex
 }
 
 func newRTPTransmissionStatsDB(sink *RTPSink) *RTPTransmissionStatsDB {
@@ -28,6 +30,9 @@ func (d *RTPTransmissionStatsDB) lookup(ssrc uint32) *RTPTransmissionStats {
 
 func (d *RTPTransmissionStatsDB) noteIncomingRR(lastFromAddress string,
 	ssrc, lossStats, lastPacketNumReceived, jitter, lastSRTime, diffSRRRTime uint32) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
 	stats := d.lookup(ssrc)
 	if stats == nil {
 		// This is the first time we've heard of this SSRC.
@@ -44,6 +49,27 @@ func (d *RTPTransmissionStatsDB) noteIncomingRR(lastFromAddress string,
 		lastSRTime, diffSRRRTime)
 }
 
+// receivers returns what each receiver reported in its latest RTCP "RR".
+func (d *RTPTransmissionStatsDB) receivers() []ReceiverStats {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	receivers := make([]ReceiverStats, 0, len(d.table))
+	for _, stats := range d.table {
+		receiver := ReceiverStats{
+			SSRC:         stats.ssrc,
+			Address:      stats.lastFromAddress,
+			FractionLost: float64(stats.packetLossRatio) / 256,
+			PacketsLost:  stats.totNumPacketsLost,
+		}
+		if d.sink.rtpTimestampFrequency != 0 {
+			receiver.Jitter = float64(stats.jitter) / float64(d.sink.rtpTimestampFrequency)
+		}
+		receivers = append(receivers, receiver)
+	}
+	return receivers
+}
+
 //////// RTPTransmissionStats ////////
 type RTPTransmissionStats struct {
 	sink                          *RTPSink
@@ -0,0 +1,348 @@
+package livemedia
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	gs "github.com/djwackey/dorsvr/groupsock"
+	"github.com/djwackey/gitea/log"
+)
+
+// The group and port of the SAP (RFC 2974) announcements of global scope.
+const (
+	SAPAddress = "224.2.127.254"
+	SAPPort    = 9875
+)
+
+// the flags of the first byte of a SAP header
+const (
+	sapVersion         = 1 << 5
+	sapAddressTypeIPv6 = 1 << 4
+	sapDeletion        = 1 << 2
+	sapEncrypted       = 1 << 1
+	sapCompressed      = 1 << 0
+)
+
+const sapPayloadType = "application/sdp"
+
+// A session that isn't announced again for this long (or for ten of its announcement
+// intervals, if that's longer) is dropped from a catalog.
+const sapSessionTimeout = time.Hour
+
+var errSAPPacket = errors.New("bad SAP packet")
+
+type sapPacket struct {
+	deletion    bool
+	msgIDHash   uint16
+	origin      net.IP
+	sdp         string
+	receiveTime time.Time
+}
+
+// newSAPPacket returns an announcement of a SDP description, or its deletion.
+func newSAPPacket(origin net.IP, sdp string, deletion bool) []byte {
+	hash := fnv.New32a()
+	hash.Write([]byte(sdp))
+	msgIDHash := uint16(hash.Sum32())
+	if msgIDHash == 0 {
+		// (0 means that there's no hash.)
+		msgIDHash = 1
+	}
+
+	flags := byte(sapVersion)
+	originAddr := origin.To4()
+	if originAddr == nil {
+		flags |= sapAddressTypeIPv6
+		originAddr = origin.To16()
+	}
+	if deletion {
+		flags |= sapDeletion
+	}
+
+	packet := []byte{flags, 0, byte(msgIDHash >> 8), byte(msgIDHash)}
+	packet = append(packet, originAddr...)
+	packet = append(packet, sapPayloadType...)
+	packet = append(packet, 0)
+	return append(packet, sdp...)
+}
+
+func parseSAPPacket(packet []byte) (*sapPacket, error) {
+	if len(packet) < 4 || packet[0]>>5 != 1 || packet[0]&sapEncrypted != 0 {
+		return nil, errSAPPacket
+	}
+
+	p := &sapPacket{
+		deletion:  packet[0]&sapDeletion != 0,
+		msgIDHash: uint16(packet[2])<<8 | uint16(packet[3]),
+	}
+	originSize := net.IPv4len
+	if packet[0]&sapAddressTypeIPv6 != 0 {
+		originSize = net.IPv6len
+	}
+	// (The authentication data, which we don't check, is in 32-bit words.)
+	offset := 4 + originSize + int(packet[1])*4
+	if len(packet) < offset {
+		return nil, errSAPPacket
+	}
+	p.origin = net.IP(append([]byte(nil), packet[4:4+originSize]...))
+
+	payload := packet[offset:]
+	if packet[0]&sapCompressed != 0 {
+		reader, err := zlib.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, errSAPPacket
+		}
+		if payload, err = ioutil.ReadAll(reader); err != nil {
+			return nil, errSAPPacket
+		}
+	}
+
+	// The payload type is optional, and SDP by default:
+	if !bytes.HasPrefix(payload, []byte("v=0")) {
+		i := bytes.IndexByte(payload, 0)
+		if i == -1 || string(payload[:i]) != sapPayloadType {
+			return nil, errSAPPacket
+		}
+		payload = payload[i+1:]
+	}
+	p.sdp = string(payload)
+	return p, nil
+}
+
+//////// SAPAnnouncer ////////
+
+// SAPAnnouncer announces the SDP description of a multicast session on a SAP group, so that
+// receivers such as set-top boxes can find it without RTSP.
+type SAPAnnouncer struct {
+	sms       *ServerMediaSession
+	groupSock *gs.GroupSock
+	origin    net.IP
+	interval  time.Duration
+	stop      chan struct{}
+	stopOnce  sync.Once
+	done      chan struct{}
+}
+
+// NewSAPAnnouncer creates an announcer of a session that has multicast tracks, on the SAP
+// group groupAddr (e.g. SAPAddress) and port (e.g. SAPPort), every interval. The announcements
+// go as far as the tracks: their TTL is the largest of the tracks.
+func NewSAPAnnouncer(sms *ServerMediaSession, groupAddr string, portNum uint,
+	interval time.Duration) (*SAPAnnouncer, error) {
+	ttl := sms.multicastTTL()
+	if ttl == 0 {
+		return nil, errors.New("the session has no multicast tracks")
+	}
+	if interval <= 0 {
+		return nil, errors.New("bad SAP announcement interval")
+	}
+	origin := net.ParseIP(sms.ipAddr)
+	if origin == nil {
+		origin = net.IPv4zero
+	}
+
+	groupSock, err := gs.NewMulticastGroupSock(groupAddr, portNum, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return &SAPAnnouncer{
+		sms:       sms,
+		groupSock: groupSock,
+		origin:    origin,
+		interval:  interval,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}, nil
+}
+
+// Start sends the announcements, from now on.
+func (a *SAPAnnouncer) Start() {
+	go a.announce()
+}
+
+func (a *SAPAnnouncer) announce() {
+	defer close(a.done)
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		packet := newSAPPacket(a.origin, a.sms.GenerateSDPDescription(), false)
+		if !a.groupSock.Output(packet, uint(len(packet))) {
+			log.Warn("[SAPAnnouncer] failed to announce \"%s\"", a.sms.StreamName())
+		}
+
+		select {
+		case <-ticker.C:
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// Stop stops the announcements, and withdraws the session.
+func (a *SAPAnnouncer) Stop() {
+	a.stopOnce.Do(func() {
+		close(a.stop)
+		<-a.done
+
+		packet := newSAPPacket(a.origin, a.sms.GenerateSDPDescription(), true)
+		a.groupSock.Output(packet, uint(len(packet)))
+		a.groupSock.Close()
+	})
+}
+
+// multicastTTL returns the largest TTL of the session's multicast tracks, or 0 if it has none.
+func (s *ServerMediaSession) multicastTTL() (ttl uint) {
+	for i := 0; i < s.SubsessionCounter; i++ {
+		if subsession, ok := s.Subsessions[i].(*MulticastServerMediaSubsession); ok && subsession.ttl > ttl {
+			ttl = subsession.ttl
+		}
+	}
+	return
+}
+
+// IsMulticast reports whether the session has multicast tracks.
+func (s *ServerMediaSession) IsMulticast() bool {
+	return s.multicastTTL() != 0
+}
+
+//////// SAPCatalog ////////
+
+// SAPSession is a session that is announced with SAP. Its description can be passed to
+// NewMediaSession.
+type SAPSession struct {
+	// the address of the announcer
+	Origin string
+	// the "s=" line of the description
+	SessionName string
+	SDP         string
+}
+
+type sapEntry struct {
+	session  SAPSession
+	lastSeen time.Time
+	interval time.Duration
+}
+
+// SAPCatalog collects the sessions that are announced on a SAP group. A session leaves the
+// catalog when it's deleted, or when it's no longer announced.
+type SAPCatalog struct {
+	groupSock *gs.GroupSock
+	mutex     sync.Mutex
+	// by origin and message id hash, which is new for every version of a description
+	entries map[string]*sapEntry
+	done    chan struct{}
+}
+
+// NewSAPCatalog starts listening to the announcements of a SAP group, e.g. SAPAddress and
+// SAPPort.
+func NewSAPCatalog(groupAddr string, portNum uint) (*SAPCatalog, error) {
+	groupSock, err := gs.NewMulticastGroupSock(groupAddr, portNum, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &SAPCatalog{
+		groupSock: groupSock,
+		entries:   make(map[string]*sapEntry),
+		done:      make(chan struct{}),
+	}
+	go c.incomingPacketHandler()
+	return c, nil
+}
+
+func (c *SAPCatalog) incomingPacketHandler() {
+	defer close(c.done)
+
+	buffer := make([]byte, 65536)
+	for {
+		numBytes, err := c.groupSock.HandleRead(buffer)
+		if err != nil {
+			// the catalog was closed
+			return
+		}
+
+		packet, err := parseSAPPacket(buffer[:numBytes])
+		if err != nil {
+			log.Warn("[SAPCatalog] %v", err)
+			continue
+		}
+		packet.receiveTime = time.Now()
+		c.update(packet)
+	}
+}
+
+func (c *SAPCatalog) update(packet *sapPacket) {
+	key := fmt.Sprintf("%s/%d", packet.origin, packet.msgIDHash)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if packet.deletion {
+		delete(c.entries, key)
+		return
+	}
+
+	entry, existed := c.entries[key]
+	if !existed {
+		entry = &sapEntry{
+			session: SAPSession{
+				Origin:      packet.origin.String(),
+				SessionName: sdpSessionName(packet.sdp),
+				SDP:         packet.sdp,
+			},
+		}
+		c.entries[key] = entry
+	} else {
+		entry.interval = packet.receiveTime.Sub(entry.lastSeen)
+	}
+	entry.lastSeen = packet.receiveTime
+}
+
+// Sessions returns the sessions that are currently announced, by name.
+func (c *SAPCatalog) Sessions() []SAPSession {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	sessions := make([]SAPSession, 0, len(c.entries))
+	for key, entry := range c.entries {
+		timeout := sapSessionTimeout
+		if 10*entry.interval > timeout {
+			timeout = 10 * entry.interval
+		}
+		if now.Sub(entry.lastSeen) > timeout {
+			delete(c.entries, key)
+			continue
+		}
+		sessions = append(sessions, entry.session)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].SessionName < sessions[j].SessionName
+	})
+	return sessions
+}
+
+// Close stops listening to the announcements.
+func (c *SAPCatalog) Close() {
+	c.groupSock.Close()
+	<-c.done
+}
+
+func sdpSessionName(sdp string) string {
+	for _, line := range strings.Split(sdp, "\n") {
+		if strings.HasPrefix(line, "s=") {
+			return strings.TrimSpace(line[2:])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,73 @@
+package livemedia
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSAPPacket(t *testing.T) {
+	packet, err := parseSAPPacket(newSAPPacket(net.ParseIP("10.0.0.1"), multicastSDPDesc, true))
+	if err != nil || !packet.deletion || packet.msgIDHash == 0 ||
+		packet.origin.String() != "10.0.0.1" || packet.sdp != multicastSDPDesc {
+		t.Error("failed")
+		return
+	}
+
+	// (A SAP packet may leave out its payload type.)
+	bare := append([]byte{0x30, 0, 0x12, 0x34}, net.ParseIP("2001:db8::1")...)
+	packet, err = parseSAPPacket(append(bare, "v=0\r\n"...))
+	if err != nil || packet.deletion || packet.origin.String() != "2001:db8::1" || packet.sdp != "v=0\r\n" {
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
+
+func TestSAPCatalog(t *testing.T) {
+	catalog, err := NewSAPCatalog("239.195.255.255", 19875)
+	if err != nil {
+		t.Skipf("no multicast: %v", err)
+	}
+	defer catalog.Close()
+
+	subsession, err := NewMulticastServerMediaSubsession(NewH264FileMediaSubsession("../examples/test.264"),
+		"232.1.2.3", 15020, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sms := NewServerMediaSession("H.264 Video", "sap")
+	sms.AddSubsession(subsession)
+	defer sms.Close()
+
+	announcer, err := NewSAPAnnouncer(sms, "239.195.255.255", 19875, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	announcer.Start()
+
+	var sessions []SAPSession
+	for i := 0; i < 50 && len(sessions) == 0; i++ {
+		time.Sleep(20 * time.Millisecond)
+		sessions = catalog.Sessions()
+	}
+	if len(sessions) != 1 || !strings.HasPrefix(sessions[0].SessionName, "H.264 Video") ||
+		!strings.Contains(sessions[0].SDP, "c=IN IP4 232.1.2.3/1") || NewMediaSession(sessions[0].SDP) == nil {
+		t.Errorf("failed: %v", sessions)
+		announcer.Stop()
+		return
+	}
+
+	// The session is withdrawn when the announcer stops:
+	announcer.Stop()
+	for i := 0; i < 50 && len(sessions) != 0; i++ {
+		time.Sleep(20 * time.Millisecond)
+		sessions = catalog.Sessions()
+	}
+	if len(sessions) == 0 {
+		t.Log("success")
+	} else {
+		t.Error("failed")
+	}
+}
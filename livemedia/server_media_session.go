@@ -38,8 +38,8 @@ func NewServerMediaSession(description, streamName string) *ServerMediaSession {
 func (s *ServerMediaSession) GenerateSDPDescription() string {
 	var sourceFilterLine string
 	if s.isSSM {
-		sourceFilterLine = fmt.Sprintf("a=source-filter: incl IN IP4 * %s\r\n"+
-			"a=rtcp-unicast: reflection\r\n", s.ipAddr)
+		sourceFilterLine = fmt.Sprintf("a=source-filter: incl IN %s * %s\r\n"+
+			"a=rtcp-unicast: reflection\r\n", sdpAddressType(s.ipAddr), s.ipAddr)
 	} else {
 		sourceFilterLine = ""
 	}
@@ -53,7 +53,7 @@ func (s *ServerMediaSession) GenerateSDPDescription() string {
 	}
 
 	sdpPrefixFmt := "v=0\r\n" +
-		"o=- %d%06d %d IN IP4 %s\r\n" +
+		"o=- %d%06d %d IN %s %s\r\n" +
 		"s=%s\r\n" +
 		"i=%s\r\n" +
 		"t=0 0\r\n" +
@@ -70,6 +70,7 @@ func (s *ServerMediaSession) GenerateSDPDescription() string {
 		s.creationTime.Sec,
 		s.creationTime.Usec,
 		1,
+		sdpAddressType(s.ipAddr),
 		s.ipAddr,
 		s.descSDPStr,
 		s.infoSDPStr,
@@ -89,6 +90,20 @@ func (s *ServerMediaSession) GenerateSDPDescription() string {
 	return sdp
 }
 
+// sdpAddressType returns the address type of an address in SDP: "IP4" or "IP6".
+func sdpAddressType(addr string) string {
+	if gs.IsIPv6(addr) {
+		return "IP6"
+	}
+	return "IP4"
+}
+
+// SetSSM makes the session source-specific multicast (SSM): its SDP description tells the
+// receivers of its multicast tracks to accept packets from our address only.
+func (s *ServerMediaSession) SetSSM(isSSM bool) {
+	s.isSSM = isSSM
+}
+
 func (s *ServerMediaSession) StreamName() string {
 	return s.streamName
 }
@@ -100,6 +115,13 @@ func (s *ServerMediaSession) AddSubsession(subsession IServerMediaSubsession) {
 	subsession.IncrTrackNumber()
 }
 
+// Close ends the streams of every track of the session.
+func (s *ServerMediaSession) Close() {
+	for i := 0; i < s.SubsessionCounter; i++ {
+		s.Subsessions[i].Close()
+	}
+}
+
 func (s *ServerMediaSession) Duration() float32 {
 	return 0.0
 }
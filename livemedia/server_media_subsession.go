@@ -19,12 +19,13 @@ type IServerMediaSubsession interface {
 	IncrTrackNumber()
 	TrackID() string
 	SDPLines() string
+	SRTPEnabled() bool
 	CNAME() string
-	StartStream(clientSessionID string, streamState *StreamState,
-		rtcpRRHandler, serverRequestAlternativeByteHandler interface{}) (uint32, uint32)
+	StartStream(clientSessionID string, streamState *StreamState, rtcpRRHandler interface{}) (uint32, uint32)
 	PauseStream(streamState *StreamState)
 	DeleteStream(sessionID string, streamState *StreamState)
 	SeekStream(sessionID string, streamState *StreamState, streamDuration float32)
+	Close()
 }
 
 type ServerMediaSubsession struct {
@@ -57,6 +58,14 @@ func (s *ServerMediaSubsession) IncrTrackNumber() {
 	s.trackNumber++
 }
 
+// connectionSDPLine returns the "c=" line of the track, of the address family of its session.
+func (s *ServerMediaSubsession) connectionSDPLine() string {
+	if s.parentSession != nil && sdpAddressType(s.parentSession.ipAddr) == "IP6" {
+		return "c=IN IP6 ::"
+	}
+	return "c=IN IP4 0.0.0.0"
+}
+
 func (s *ServerMediaSubsession) getAbsoluteTimeRange(absStartTime, absEndTime *string) {
 	//absStartTime = nil
 	//absEndTime = nil
@@ -84,6 +93,11 @@ func (s *ServerMediaSubsession) rangeSDPLine() string {
 	}
 }
 
+// default implementation: the stream is sent in the clear
+func (s *ServerMediaSubsession) SRTPEnabled() bool {
+	return false
+}
+
 // default implementation: Support scale = 1 only
 func (s *ServerMediaSubsession) TestScaleFactor(scale float32) float32 {
 	scale = 1.0
@@ -1,10 +1,15 @@
 package livemedia
 
-import gs "github.com/djwackey/dorsvr/groupsock"
+import (
+	sys "syscall"
+
+	gs "github.com/djwackey/dorsvr/groupsock"
+)
 
 type SimpleRTPSink struct {
 	MultiFramedRTPSink
 	allowMultipleFramesPerPacket bool
+	setMBitOnLastFrames          bool
 }
 
 func newSimpleRTPSink(rtpGS *gs.GroupSock, rtpPayloadFormat,
@@ -14,6 +19,7 @@ func newSimpleRTPSink(rtpGS *gs.GroupSock, rtpPayloadFormat,
 	sink := new(SimpleRTPSink)
 	sink.InitMultiFramedRTPSink(sink, rtpGS, rtpPayloadFormat, rtpTimestampFrequency, rtpPayloadFormatName)
 	sink.allowMultipleFramesPerPacket = allowMultipleFramesPerPacket
+	sink.setMBitOnLastFrames = doNormalMBitRule
 	return sink
 }
 
@@ -22,4 +28,21 @@ func (s *SimpleRTPSink) AuxSDPLine() string {
 }
 
 func (s *SimpleRTPSink) ContinuePlaying() {
+	s.multiFramedPlaying()
+}
+
+func (s *SimpleRTPSink) frameCanAppearAfterPacketStart(frameStart []byte, numBytesInFrame uint) bool {
+	return s.allowMultipleFramesPerPacket
+}
+
+func (s *SimpleRTPSink) doSpecialFrameHandling(fragmentationOffset, numBytesInFrame, numRemainingBytes uint,
+	frameStart []byte, framePresentationTime sys.Timeval) {
+	if numRemainingBytes == 0 && s.setMBitOnLastFrames {
+		// This packet contains the last (or only) fragment of the frame.
+		s.setMarkerBit()
+	}
+
+	// Important: Also call our base class's doSpecialFrameHandling(), to set the packet's timestamp:
+	s.MultiFramedRTPSink.doSpecialFrameHandling(fragmentationOffset, numBytesInFrame, numRemainingBytes,
+		frameStart, framePresentationTime)
 }
@@ -0,0 +1,15 @@
+package livemedia
+
+import gs "github.com/djwackey/dorsvr/groupsock"
+
+// SimpleRTPSource delivers each incoming RTP payload as a frame, without any
+// payload format specific processing.
+type SimpleRTPSource struct {
+	MultiFramedRTPSource
+}
+
+func newSimpleRTPSource(RTPgs *gs.GroupSock, rtpPayloadFormat, rtpTimestampFrequency uint32) *SimpleRTPSource {
+	source := new(SimpleRTPSource)
+	source.initMultiFramedRTPSource(source, RTPgs, rtpPayloadFormat, rtpTimestampFrequency, nil)
+	return source
+}
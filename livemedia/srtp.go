@@ -0,0 +1,377 @@
+package livemedia
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"sync"
+)
+
+// SRTPCryptoSuite is the SRTP crypto suite (RFC 4568) that we support: AES in counter mode
+// with a 128-bit key, and an 80-bit HMAC-SHA1 tag.
+const SRTPCryptoSuite = "AES_CM_128_HMAC_SHA1_80"
+
+// the sizes of the master key and the master salt, and of the authentication tag
+const (
+	srtpMasterKeySize  = 16
+	srtpMasterSaltSize = 14
+	srtpAuthKeySize    = 20
+	srtpAuthTagSize    = 10
+)
+
+// the labels of the session keys (RFC 3711, section 4.3.1)
+const (
+	labelRTPEncryption  = 0x00
+	labelRTPAuth        = 0x01
+	labelRTPSalt        = 0x02
+	labelRTCPEncryption = 0x03
+	labelRTCPAuth       = 0x04
+	labelRTCPSalt       = 0x05
+)
+
+// the reasons why a packet is dropped
+var (
+	errSRTPShort   = errors.New("srtp: packet too short")
+	errSRTPAuth    = errors.New("srtp: authentication failed")
+	errSRTPReplay  = errors.New("srtp: replayed packet")
+	errSRTPKeySize = fmt.Errorf("srtp: the master key and salt must be %d bytes", srtpMasterKeySize+srtpMasterSaltSize)
+)
+
+// srtpSessionKeys are the keys of one direction of RTP or RTCP, derived from the master key.
+type srtpSessionKeys struct {
+	block cipher.Block
+	salt  []byte
+	auth  hash.Hash
+}
+
+// srtpStream is what we know about the packets of one SSRC.
+type srtpStream struct {
+	// RTP: the rollover counter, and the greatest sequence number
+	roc         uint32
+	seq         uint16
+	initialized bool
+	rtpReplay   srtpReplayWindow
+	// RTCP: the index of the next packet that we send
+	rtcpIndex  uint32
+	rtcpReplay srtpReplayWindow
+}
+
+// SRTPContext protects (and unprotects) the RTP and RTCP packets of a stream with the session
+// keys that are derived from a master key (RFC 3711). The packets of each SSRC are counted
+// separately, so a context can both send a stream and receive the reports of its receivers.
+type SRTPContext struct {
+	rtp     srtpSessionKeys
+	rtcp    srtpSessionKeys
+	streams map[uint32]*srtpStream
+	mutex   sync.Mutex
+}
+
+// NewSRTPContext returns a context of a master key, which is followed by the master salt,
+// as the "inline:" key parameter of an "a=crypto" line holds them.
+func NewSRTPContext(masterKey []byte) (*SRTPContext, error) {
+	if len(masterKey) != srtpMasterKeySize+srtpMasterSaltSize {
+		return nil, errSRTPKeySize
+	}
+	key, salt := masterKey[:srtpMasterKeySize], masterKey[srtpMasterKeySize:]
+
+	c := &SRTPContext{streams: make(map[uint32]*srtpStream)}
+	var err error
+	if c.rtp, err = deriveSessionKeys(key, salt, labelRTPEncryption, labelRTPAuth, labelRTPSalt); err != nil {
+		return nil, err
+	}
+	if c.rtcp, err = deriveSessionKeys(key, salt, labelRTCPEncryption, labelRTCPAuth, labelRTCPSalt); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewSRTPMasterKey returns a random master key and salt.
+func NewSRTPMasterKey() ([]byte, error) {
+	masterKey := make([]byte, srtpMasterKeySize+srtpMasterSaltSize)
+	if _, err := rand.Read(masterKey); err != nil {
+		return nil, err
+	}
+	return masterKey, nil
+}
+
+func deriveSessionKeys(masterKey, masterSalt []byte, encryptionLabel, authLabel, saltLabel byte) (srtpSessionKeys, error) {
+	var keys srtpSessionKeys
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return keys, err
+	}
+
+	encryptionKey := deriveKey(block, masterSalt, encryptionLabel, srtpMasterKeySize)
+	if keys.block, err = aes.NewCipher(encryptionKey); err != nil {
+		return keys, err
+	}
+	keys.auth = hmac.New(sha1.New, deriveKey(block, masterSalt, authLabel, srtpAuthKeySize))
+	keys.salt = deriveKey(block, masterSalt, saltLabel, srtpMasterSaltSize)
+	return keys, nil
+}
+
+// deriveKey is the key derivation function of RFC 3711, section 4.3.1, with a key
+// derivation rate of 0: the keystream of AES-CM, whose IV is the master salt XOR the label.
+func deriveKey(block cipher.Block, masterSalt []byte, label byte, size int) []byte {
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, masterSalt)
+	iv[7] ^= label
+
+	key := make([]byte, size)
+	cipher.NewCTR(block, iv).XORKeyStream(key, key)
+	return key
+}
+
+// xorKeyStream encrypts (or decrypts) data with AES-CM, whose IV is the session salt XOR the
+// SSRC and the packet index (RFC 3711, section 4.1.1).
+func (k *srtpSessionKeys) xorKeyStream(data []byte, ssrc uint32, index uint64) {
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, k.salt)
+	for i := 0; i < 4; i++ {
+		iv[4+i] ^= byte(ssrc >> uint(24-8*i))
+	}
+	for i := 0; i < 6; i++ {
+		iv[8+i] ^= byte(index >> uint(40-8*i))
+	}
+	cipher.NewCTR(k.block, iv).XORKeyStream(data, data)
+}
+
+// authTag computes the tag of the authenticated portion of a packet, followed by the ROC
+// in the case of RTP.
+func (k *srtpSessionKeys) authTag(data []byte, roc []byte) []byte {
+	k.auth.Reset()
+	k.auth.Write(data)
+	k.auth.Write(roc)
+	return k.auth.Sum(nil)[:srtpAuthTagSize]
+}
+
+func (c *SRTPContext) stream(ssrc uint32) *srtpStream {
+	stream, existed := c.streams[ssrc]
+	if !existed {
+		stream = &srtpStream{}
+		c.streams[ssrc] = stream
+	}
+	return stream
+}
+
+// srtpHeaderSize returns the size of the header of a RTP packet, with its CSRCs and extension.
+func srtpHeaderSize(packet []byte) (int, bool) {
+	if len(packet) < 12 {
+		return 0, false
+	}
+	size := 12 + 4*int(packet[0]&0x0F)
+	if packet[0]&0x10 != 0 {
+		if len(packet) < size+4 {
+			return 0, false
+		}
+		size += 4 + 4*int(binary.BigEndian.Uint16(packet[size+2:]))
+	}
+	return size, size <= len(packet)
+}
+
+// estimateROC guesses the rollover counter of a sequence number (RFC 3711, appendix A).
+func (s *srtpStream) estimateROC(seq uint16) uint32 {
+	if !s.initialized {
+		return s.roc
+	}
+	if s.seq < 0x8000 {
+		if int(seq)-int(s.seq) > 0x8000 {
+			return s.roc - 1
+		}
+	} else if int(s.seq)-0x8000 > int(seq) {
+		return s.roc + 1
+	}
+	return s.roc
+}
+
+// update takes the sequence number and the ROC of a packet that was sent, or authenticated.
+func (s *srtpStream) update(seq uint16, roc uint32) {
+	switch {
+	case !s.initialized:
+		s.seq, s.roc, s.initialized = seq, roc, true
+	case roc == s.roc+1:
+		s.seq, s.roc = seq, roc
+	case roc == s.roc && seq > s.seq:
+		s.seq = seq
+	}
+}
+
+// ProtectRTP encrypts a RTP packet, and appends its authentication tag.
+func (c *SRTPContext) ProtectRTP(packet []byte) ([]byte, error) {
+	headerSize, ok := srtpHeaderSize(packet)
+	if !ok {
+		return nil, errSRTPShort
+	}
+	seq := binary.BigEndian.Uint16(packet[2:])
+	ssrc := binary.BigEndian.Uint32(packet[8:])
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	stream := c.stream(ssrc)
+	roc := stream.estimateROC(seq)
+	stream.update(seq, roc)
+
+	out := make([]byte, len(packet), len(packet)+srtpAuthTagSize)
+	copy(out, packet)
+	c.rtp.xorKeyStream(out[headerSize:], ssrc, uint64(roc)<<16|uint64(seq))
+
+	var rocBytes [4]byte
+	binary.BigEndian.PutUint32(rocBytes[:], roc)
+	return append(out, c.rtp.authTag(out, rocBytes[:])...), nil
+}
+
+// UnprotectRTP authenticates and decrypts a SRTP packet.
+func (c *SRTPContext) UnprotectRTP(packet []byte) ([]byte, error) {
+	if len(packet) < 12+srtpAuthTagSize {
+		return nil, errSRTPShort
+	}
+	tag := packet[len(packet)-srtpAuthTagSize:]
+	packet = packet[:len(packet)-srtpAuthTagSize]
+	headerSize, ok := srtpHeaderSize(packet)
+	if !ok {
+		return nil, errSRTPShort
+	}
+	seq := binary.BigEndian.Uint16(packet[2:])
+	ssrc := binary.BigEndian.Uint32(packet[8:])
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	stream := c.stream(ssrc)
+	roc := stream.estimateROC(seq)
+	var rocBytes [4]byte
+	binary.BigEndian.PutUint32(rocBytes[:], roc)
+	if subtle.ConstantTimeCompare(c.rtp.authTag(packet, rocBytes[:]), tag) != 1 {
+		return nil, errSRTPAuth
+	}
+	index := uint64(roc)<<16 | uint64(seq)
+	if !stream.rtpReplay.check(index) {
+		return nil, errSRTPReplay
+	}
+	stream.update(seq, roc)
+
+	out := make([]byte, len(packet))
+	copy(out, packet)
+	c.rtp.xorKeyStream(out[headerSize:], ssrc, index)
+	return out, nil
+}
+
+// ProtectRTCP encrypts a (compound) RTCP packet, and appends its SRTCP index and its
+// authentication tag.
+func (c *SRTPContext) ProtectRTCP(packet []byte) ([]byte, error) {
+	if len(packet) < 8 {
+		return nil, errSRTPShort
+	}
+	ssrc := binary.BigEndian.Uint32(packet[4:])
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	stream := c.stream(ssrc)
+	index := stream.rtcpIndex
+	stream.rtcpIndex = (stream.rtcpIndex + 1) & 0x7FFFFFFF
+
+	out := make([]byte, len(packet), len(packet)+4+srtpAuthTagSize)
+	copy(out, packet)
+	c.rtcp.xorKeyStream(out[8:], ssrc, uint64(index))
+
+	// the "E" flag (the packet is encrypted), and the index:
+	var eIndex [4]byte
+	binary.BigEndian.PutUint32(eIndex[:], 0x80000000|index)
+	out = append(out, eIndex[:]...)
+	return append(out, c.rtcp.authTag(out, nil)...), nil
+}
+
+// UnprotectRTCP authenticates and decrypts a SRTCP packet.
+func (c *SRTPContext) UnprotectRTCP(packet []byte) ([]byte, error) {
+	if len(packet) < 8+4+srtpAuthTagSize {
+		return nil, errSRTPShort
+	}
+	tag := packet[len(packet)-srtpAuthTagSize:]
+	packet = packet[:len(packet)-srtpAuthTagSize]
+	if subtle.ConstantTimeCompare(c.rtcp.authTag(packet, nil), tag) != 1 {
+		return nil, errSRTPAuth
+	}
+
+	eIndex := binary.BigEndian.Uint32(packet[len(packet)-4:])
+	packet = packet[:len(packet)-4]
+	ssrc := binary.BigEndian.Uint32(packet[4:])
+	index := eIndex & 0x7FFFFFFF
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.stream(ssrc).rtcpReplay.check(uint64(index)) {
+		return nil, errSRTPReplay
+	}
+
+	out := make([]byte, len(packet))
+	copy(out, packet)
+	if eIndex&0x80000000 != 0 {
+		c.rtcp.xorKeyStream(out[8:], ssrc, uint64(index))
+	}
+	return out, nil
+}
+
+// srtpReplayWindow remembers which of the latest 64 packet indexes have been received.
+type srtpReplayWindow struct {
+	highest uint64
+	mask    uint64
+	started bool
+}
+
+// check reports whether a packet index hasn't been received before, and notes it.
+func (w *srtpReplayWindow) check(index uint64) bool {
+	switch {
+	case !w.started:
+		w.highest, w.mask, w.started = index, 1, true
+	case index > w.highest:
+		if shift := index - w.highest; shift < 64 {
+			w.mask = w.mask<<shift | 1
+		} else {
+			w.mask = 1
+		}
+		w.highest = index
+	default:
+		diff := w.highest - index
+		if diff >= 64 || w.mask&(1<<diff) != 0 {
+			return false
+		}
+		w.mask |= 1 << diff
+	}
+	return true
+}
+
+// srtpCryptoAttribute returns the "a=crypto" line (RFC 4568) of a master key.
+func srtpCryptoAttribute(masterKey []byte) string {
+	return fmt.Sprintf("a=crypto:1 %s inline:%s\r\n", SRTPCryptoSuite, base64.StdEncoding.EncodeToString(masterKey))
+}
+
+// parseSRTPCryptoAttribute parses an "a=crypto:<tag> <crypto-suite> inline:<key||salt>[|...]"
+// line. It returns ok for a line of a suite that we support.
+func parseSRTPCryptoAttribute(sdpLine string) (masterKey []byte, ok bool) {
+	var tag int
+	var suite, keyParams string
+	if n, _ := fmt.Sscanf(sdpLine, "a=crypto:%d %s %s", &tag, &suite, &keyParams); n != 3 ||
+		suite != SRTPCryptoSuite || !strings.HasPrefix(keyParams, "inline:") {
+		return nil, false
+	}
+
+	// (We ignore the lifetime and the MKI, after the key.)
+	keySalt := strings.SplitN(strings.TrimPrefix(keyParams, "inline:"), "|", 2)[0]
+	masterKey, err := base64.StdEncoding.DecodeString(keySalt)
+	if err != nil || len(masterKey) != srtpMasterKeySize+srtpMasterSaltSize {
+		return nil, false
+	}
+	return masterKey, true
+}
@@ -0,0 +1,98 @@
+package livemedia
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"testing"
+)
+
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// the test vectors of RFC 3711, appendix B.3, and of libsrtp
+var srtpMasterKey = unhex("e1f97a0d3e018be0d64fa32c06de4139" + "0ec675ad498afeebb6960b3aabe6")
+
+func TestSRTPKeyDerivation(t *testing.T) {
+	block, _ := aes.NewCipher(srtpMasterKey[:16])
+	salt := srtpMasterKey[16:]
+	if hex.EncodeToString(deriveKey(block, salt, labelRTPEncryption, 16)) == "c61e7a93744f39ee10734afe3ff7a087" &&
+		hex.EncodeToString(deriveKey(block, salt, labelRTPSalt, 14)) == "30cbbc08863d8c85d49db34a9ae1" &&
+		hex.EncodeToString(deriveKey(block, salt, labelRTPAuth, 20)) == "cebe321f6ff7716b6fd4ab49af256a156d38baa4" {
+		t.Log("success")
+	} else {
+		t.Error("failed to derive the session keys")
+	}
+}
+
+func TestSRTP(t *testing.T) {
+	sender, _ := NewSRTPContext(srtpMasterKey)
+	receiver, _ := NewSRTPContext(srtpMasterKey)
+
+	packet := unhex("800f1234decafbadcafebabe" + "abababababababababababababababab")
+	protected, err := sender.ProtectRTP(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(protected) == "800f1234decafbadcafebabe"+
+		"4e55dc4ce79978d88ca4d215949d2402"+"b78d6acc99ea179b8dbb" {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %x", protected)
+	}
+
+	unprotected, err := receiver.UnprotectRTP(protected)
+	_, replayErr := receiver.UnprotectRTP(protected)
+	protected[20] ^= 1
+	_, tamperErr := receiver.UnprotectRTP(protected)
+	if err == nil && bytes.Equal(unprotected, packet) && replayErr == errSRTPReplay && tamperErr == errSRTPAuth {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %v %v %v", err, replayErr, tamperErr)
+	}
+
+	// SRTCP, whose first packet has the index 1 in the vector of libsrtp:
+	report := unhex("81c8000bcafebabe" + "abababababababababababababababab")
+	sender.stream(0xcafebabe).rtcpIndex = 1
+	protected, err = sender.ProtectRTCP(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(protected) == "81c8000bcafebabe"+"7128035be487b9bdbef89041f977a5a8"+
+		"80000001"+"993e08cd54d6c1230798" {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %x", protected)
+	}
+	if unprotected, err = receiver.UnprotectRTCP(protected); err == nil && bytes.Equal(unprotected, report) {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %v", err)
+	}
+}
+
+func TestSRTPRollover(t *testing.T) {
+	sender, _ := NewSRTPContext(srtpMasterKey)
+	receiver, _ := NewSRTPContext(srtpMasterKey)
+
+	// The sequence numbers wrap around, and a packet comes late:
+	packet := unhex("80600000000000000000abcd" + "0102030405060708")
+	var received int
+	for _, seq := range []uint16{0xfffd, 0xfffe, 0x0000, 0xffff, 0x0001} {
+		packet[2], packet[3] = byte(seq>>8), byte(seq)
+		protected, _ := sender.ProtectRTP(packet)
+		if unprotected, err := receiver.UnprotectRTP(protected); err == nil && bytes.Equal(unprotected, packet) {
+			received++
+		}
+	}
+	if received == 5 && receiver.stream(0xabcd).roc == 1 {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %d packets received", received)
+	}
+}
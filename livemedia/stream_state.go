@@ -1,6 +1,8 @@
 package livemedia
 
 import (
+	"sync"
+
 	gs "github.com/djwackey/dorsvr/groupsock"
 )
 
@@ -12,11 +14,15 @@ type StreamState struct {
 	rtpGS               *gs.GroupSock
 	rtcpGS              *gs.GroupSock
 	rtcpInstance        *RTCPInstance
+	srtp                *SRTPContext
 	mediaSource         IFramedSource
 	serverRTPPort       uint
 	serverRTCPPort      uint
 	totalBW             uint
 	areCurrentlyPlaying bool
+	// guards the destinations and the playing state, which the clients that share the
+	// stream change from their own goroutines
+	mutex sync.Mutex
 }
 
 func newStreamState(master IServerMediaSubsession, serverRTPPort, serverRTCPPort uint,
@@ -35,22 +41,60 @@ func newStreamState(master IServerMediaSubsession, serverRTPPort, serverRTCPPort
 	}
 }
 
-func (s *StreamState) startPlaying(dests *Destinations,
-	rtcpRRHandler, serverRequestAlternativeByteHandler interface{}) {
+// StreamStats is what a stream has sent so far, and how its receivers say that it arrives.
+type StreamStats struct {
+	PacketCount uint
+	OctetCount  uint
+	Receivers   []ReceiverStats
+}
+
+// ReceiverStats is what a receiver of a stream reported in its latest RTCP "RR".
+type ReceiverStats struct {
+	SSRC    uint32
+	Address string
+	// the fraction of the packets that were lost since the previous report
+	FractionLost float64
+	// the number of packets that have been lost since the receiver started
+	PacketsLost uint32
+	// the interarrival jitter, in seconds
+	Jitter float64
+}
+
+// Stats returns the statistics of the stream, or nil if it doesn't send RTP.
+func (s *StreamState) Stats() *StreamStats {
+	if s.rtpSink == nil {
+		return nil
+	}
+
+	stats := &StreamStats{
+		PacketCount: s.rtpSink.packetCount(),
+		OctetCount:  s.rtpSink.octetCount(),
+	}
+	if db := s.rtpSink.transmissionStatsDB(); db != nil {
+		stats.Receivers = db.receivers()
+	}
+	return stats
+}
+
+// startPlaying adds the destinations of a client to the stream, and starts the stream unless
+// it's playing already; it reports whether it was.
+func (s *StreamState) startPlaying(dests *Destinations, rtcpRRHandler interface{}) (wasPlaying bool) {
 	if dests == nil {
 		return
 	}
 
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	if s.rtcpInstance == nil && s.rtpSink != nil {
 		// Note: This starts RTCP running automatically
 		// Create (and start) a 'RTCP instance' for this RTP sink:
-		s.rtcpInstance = newRTCPInstance(s.rtcpGS, s.totalBW, s.master.CNAME(), s.rtpSink, nil)
+		s.rtcpInstance = newRTCPInstance(s.rtcpGS, s.totalBW, s.master.CNAME(), s.rtpSink, nil, s.srtp)
 	}
 
 	if dests.isTCP {
 		if s.rtpSink != nil {
 			s.rtpSink.addStreamSocket(dests.tcpSocketNum, dests.rtpChannelID)
-			s.rtpSink.setServerRequestAlternativeByteHandler(dests.tcpSocketNum, serverRequestAlternativeByteHandler)
 		}
 		if s.rtcpInstance != nil {
 			s.rtcpInstance.setSpecificRRHandler(rtcpRRHandler)
@@ -75,32 +119,51 @@ func (s *StreamState) startPlaying(dests *Destinations,
 		s.rtcpInstance.sendReport()
 	}
 
+	wasPlaying = s.areCurrentlyPlaying
 	if !s.areCurrentlyPlaying && s.mediaSource != nil {
+		// (The sinks send the stream from a goroutine of their own, until it ends.)
 		if s.rtpSink != nil {
-			s.rtpSink.StartPlaying(s.mediaSource, s.afterPlayingStreamState)
 			s.areCurrentlyPlaying = true
+			s.rtpSink.startPlayingInBackground(s.mediaSource, s.afterPlayingStreamState)
 		} else if s.udpSink != nil {
 			s.areCurrentlyPlaying = true
-			s.udpSink.StartPlaying(s.mediaSource, s.afterPlayingStreamState)
+			s.udpSink.startPlayingInBackground(s.mediaSource, s.afterPlayingStreamState)
 		}
 	}
+	return
 }
 
 func (s *StreamState) pause() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// A sink whose source waits for its frames is stopped once the source is woken:
+	source, waits := s.mediaSource.(waitingSource)
+	if waits {
+		source.wakeUp()
+	}
 	if s.rtpSink != nil {
 		s.rtpSink.StopPlaying()
 	}
 	if s.udpSink != nil {
 		s.udpSink.StopPlaying()
 	}
+	if waits {
+		source.clearWakeUp()
+	}
 	s.areCurrentlyPlaying = false
 }
 
+// endPlaying removes the destinations of a client from the stream; its other clients go on
+// receiving it.
 func (s *StreamState) endPlaying(dests *Destinations) {
 	if dests == nil {
 		return
 	}
 
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	if dests.isTCP {
 		if s.rtpSink != nil {
 			s.rtpSink.delStreamSocket(dests.tcpSocketNum, dests.rtpChannelID)
@@ -109,6 +172,15 @@ func (s *StreamState) endPlaying(dests *Destinations) {
 			s.rtcpInstance.unsetSpecificRRHandler()
 		}
 	} else {
+		if s.rtpGS != nil {
+			s.rtpGS.DelDestination(dests.addrStr, dests.rtpPort)
+		}
+		if s.rtcpGS != nil {
+			s.rtcpGS.DelDestination(dests.addrStr, dests.rtcpPort)
+		}
+		if s.rtcpInstance != nil {
+			s.rtcpInstance.unsetSpecificRRHandler()
+		}
 	}
 }
 
@@ -125,7 +197,38 @@ func (s *StreamState) afterPlayingStreamState() {
 }
 
 func (s *StreamState) reclaim() {
-	s.rtcpInstance.destroy()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// A stream that was set up, but never played, has no RTCP instance:
+	if s.rtcpInstance != nil {
+		s.rtcpInstance.destroy()
+		s.rtcpInstance = nil
+	}
+}
+
+// close stops the stream, says goodbye to its clients with a RTCP "BYE", and closes its source
+// and its sockets.
+func (s *StreamState) close() {
+	s.pause()
+	if s.rtpSink != nil {
+		s.rtpSink.waitForPlayer()
+	}
+	if s.udpSink != nil {
+		s.udpSink.waitForPlayer()
+	}
+	s.reclaim()
+
+	if s.mediaSource != nil {
+		s.mediaSource.destroy()
+	}
+
+	if s.rtpGS != nil {
+		s.rtpGS.Close()
+	}
+	if s.rtcpGS != nil {
+		s.rtcpGS.Close()
+	}
 }
 
 func (s *StreamState) RtpSink() IMediaSink {
@@ -1,42 +1,81 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
-	//"github.com/djwackey/dorsvr/auth"
 	"github.com/djwackey/dorsvr/rtspserver"
 	"github.com/djwackey/gitea/log"
 )
 
 func main() {
+	configFile := flag.String("config", "", "the JSON config file")
+	listenAddr := flag.String("listen", "", "the address of the RTSP server, e.g. 0.0.0.0:8554")
+	httpPorts := flag.String("http-ports", "", "the ports to try for RTSP-over-HTTP tunneling, e.g. 80,8000,8080")
+	monitorAddr := flag.String("monitor", "", "the address of the monitor (metrics and pprof)")
+	adminAddr := flag.String("admin", "", "the address of the admin API, e.g. 127.0.0.1:8090")
+	mediaRoot := flag.String("media-root", "", "the directory of the media files")
+	sessionTimeout := flag.Int("session-timeout", 0, "the seconds after which a silent client session is closed")
+	rtpPorts := flag.String("rtp-ports", "", "the UDP ports that streams are sent from, e.g. 6970-9999")
+	flag.Parse()
+
 	// open a logger writer of console or file mode.
 	mode := "console"
-	config := `{"level":1,"filename":"test.log"}`
-	log.NewLogger(0, mode, config)
+	logConfig := `{"level":1,"filename":"test.log"}`
+	log.NewLogger(0, mode, logConfig)
+
+	// load the config file, if any; then the command line overrides it
+	config := rtspserver.DefaultConfig()
+	if *configFile != "" {
+		var err error
+		if config, err = rtspserver.LoadConfig(*configFile); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
 
-	// to implement client access control to the RTSP server, do the following:
-	// var realm string
-	// authdb = auth.NewAuthDatabase(realm)
-	// authdb.InsertUserRecord("username1", "password1")
-	// repeat the above with each <username>, <password> that you wish to allow
-	// access to the server.
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			config.ListenAddr = *listenAddr
+		case "http-ports":
+			config.HTTPTunnelPorts, err = parsePortList(*httpPorts)
+		case "monitor":
+			config.MonitorAddr = *monitorAddr
+		case "admin":
+			config.AdminAddr = *adminAddr
+		case "media-root":
+			config.MediaRoot = *mediaRoot
+		case "session-timeout":
+			config.SessionTimeout = *sessionTimeout
+		case "rtp-ports":
+			_, err = fmt.Sscanf(*rtpPorts, "%d-%d", &config.RTPPortStart, &config.RTPPortEnd)
+		}
+		if err != nil {
+			fmt.Printf("Bad -%s: %v\n", f.Name, err)
+			os.Exit(2)
+		}
+	})
 
 	// create a rtsp server
-	server := rtspserver.New(nil)
+	server := rtspserver.New(config)
 
-	portNum := 8554
-	err := server.Listen(portNum)
-	if err != nil {
-		fmt.Printf("Failed to bind port: %d\n", portNum)
+	// also, attempt to create a HTTP server for RTSP-over-HTTP tunneling,
+	// on the first of the configured ports that is free
+	if err := server.ListenAndServe(); err != nil {
+		fmt.Printf("Failed to listen on %s: %v\n", config.ListenAddr, err)
 		return
 	}
 
-	// also, attempt to create a HTTP server for RTSP-over-HTTP tunneling.
-	// Try first with the default HTTP port (80), and then with the alternative HTTP
-	// port numbers (8000 and 8080).
-	if !server.SetupTunnelingOverHTTP(80) ||
-		!server.SetupTunnelingOverHTTP(8000) ||
-		!server.SetupTunnelingOverHTTP(8080) {
+	if server.HTTPServerPortNum() != 0 {
 		fmt.Printf("We use port %d for optional RTSP-over-HTTP tunneling, "+
 			"or for HTTP live streaming (for indexed Transport Stream files only).\n",
 			server.HTTPServerPortNum())
@@ -47,8 +86,38 @@ func main() {
 	urlPrefix := server.RtspURLPrefix()
 	fmt.Println("This server's URL: " + urlPrefix + "<filename>.")
 
-	server.Start()
+	// run until we're told to stop, then give the clients some time to go;
+	// a SIGHUP reads the users file again
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range signals {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		if err := server.ReloadAuthStore(); err != nil {
+			fmt.Printf("Failed to reload the users: %v\n", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		fmt.Printf("Failed to shut down gracefully: %v\n", err)
+	}
+}
 
-	// do event loop
-	select {}
+// parsePortList parses a comma-separated list of ports; an empty list is allowed.
+func parsePortList(s string) ([]int, error) {
+	ports := []int{}
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		port, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
 }
@@ -5,13 +5,21 @@ if [ ! -f make.sh ]; then
 	exit 1
 fi
 
+# fetch the packages that dorsvr imports: github.com/djwackey/gitea for its logs, and
+# golang.org/x/crypto for the bcrypt hashes of htpasswd files
+if [ "$1" = "deps" ]; then
+    go get -d -v ./...
+fi
+
 if [ "$1" = "fmt" ]; then
     gofmt -w .
 fi
 
 if [ "$1" = "test" ]; then
+    go test ./auth
     go test ./groupsock
     go test ./livemedia
+    go test ./rtsp
     go test ./rtspclient
     go test ./rtspserver
 fi
@@ -0,0 +1,91 @@
+// Package rtsp reads and writes RTSP messages (RFC 2326), and the header
+// values that the server and the client need to understand.
+package rtsp
+
+import (
+	"io"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Header holds the header fields of a RTSP message. The keys are canonicalized by
+// CanonicalHeaderKey, so the methods of Header ignore the case of header names.
+type Header map[string][]string
+
+// the RTSP names whose canonical form isn't the MIME one
+var wellKnownHeaderNames = map[string]string{
+	"cseq":             "CSeq",
+	"rtp-info":         "RTP-Info",
+	"www-authenticate": "WWW-Authenticate",
+	"x-sessioncookie":  "x-sessioncookie",
+	"x-playnow":        "x-playNow",
+}
+
+// CanonicalHeaderKey returns the form in which a header name is stored, and written.
+func CanonicalHeaderKey(name string) string {
+	if canonicalName, existed := wellKnownHeaderNames[strings.ToLower(name)]; existed {
+		return canonicalName
+	}
+	return textproto.CanonicalMIMEHeaderKey(name)
+}
+
+// Add adds a value to the ones of the header name.
+func (h Header) Add(name, value string) {
+	name = CanonicalHeaderKey(name)
+	h[name] = append(h[name], value)
+}
+
+// Set replaces the values of the header name with a single value.
+func (h Header) Set(name, value string) {
+	h[CanonicalHeaderKey(name)] = []string{value}
+}
+
+// Get returns the first value of the header name, or "" if there is none.
+func (h Header) Get(name string) string {
+	if values := h[CanonicalHeaderKey(name)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Values returns all the values of the header name.
+func (h Header) Values(name string) []string {
+	return h[CanonicalHeaderKey(name)]
+}
+
+// Del removes the header name.
+func (h Header) Del(name string) {
+	delete(h, CanonicalHeaderKey(name))
+}
+
+// Write writes the header fields, "CSeq" first, and the others in the order of their names.
+func (h Header) Write(w io.Writer) error {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		if name != "CSeq" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if _, existed := h["CSeq"]; existed {
+		names = append([]string{"CSeq"}, names...)
+	}
+
+	for _, name := range names {
+		for _, value := range h[name] {
+			// a value can't break out of its line
+			value = strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+			if _, err := io.WriteString(w, name+": "+value+"\r\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// FormatDate formats a time for a "Date:" header.
+func FormatDate(t time.Time) string {
+	return t.UTC().Format("Mon, 02 Jan 2006 15:04:05 GMT")
+}
@@ -0,0 +1,91 @@
+package rtsp
+
+import "testing"
+
+func TestParseRange(t *testing.T) {
+	r, err := ParseRange("npt=0:01:10.5-120")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	start, end, err := r.NPT()
+	if err != nil || start != 70.5 || end != 120 {
+		t.Errorf("failed: %v %v %v", start, end, err)
+		return
+	}
+
+	r, err = ParseRange("npt=now-")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if start, end, _ = r.NPT(); start != 0 || end != -1 {
+		t.Errorf("failed: %v %v", start, end)
+		return
+	}
+
+	r, err = ParseRange("clock=19961108T142300Z-19961108T143520Z")
+	if err != nil || r.Start != "19961108T142300Z" || r.End != "19961108T143520Z" {
+		t.Errorf("failed: %v %+v", err, r)
+		return
+	}
+
+	if s := NewNPTRange(10, -1).String(); s != "npt=10.000-" {
+		t.Errorf("failed: %s", s)
+		return
+	}
+	t.Log("success")
+}
+
+func TestParseSession(t *testing.T) {
+	session, err := ParseSession("E1155C20;timeout=60")
+	if err != nil || session.ID != "E1155C20" || session.Timeout != 60 || session.String() != "E1155C20;timeout=60" {
+		t.Errorf("failed: %v %+v", err, session)
+		return
+	}
+	t.Log("success")
+}
+
+func TestParseRTPInfo(t *testing.T) {
+	s := "url=rtsp://foo.com/bar.avi/streamid=0;seq=45102;rtptime=12345678," +
+		"url=rtsp://foo.com/bar.avi/streamid=1;seq=30211"
+
+	info, err := ParseRTPInfo(s)
+	if err != nil || len(info) != 2 || info[0].Seq != 45102 || info[0].RTPTime != 12345678 ||
+		info[1].URL != "rtsp://foo.com/bar.avi/streamid=1" || info[1].HasRTPTime {
+		t.Errorf("failed: %v %+v", err, info)
+		return
+	}
+	if info.String() != s {
+		t.Errorf("failed: %s", info.String())
+		return
+	}
+
+	// URLs with "," and ";" in them, unquoted and quoted:
+	info, err = ParseRTPInfo("url=rtsp://foo.com/a,b;c=d/track1;seq=1;rtptime=2, " +
+		`url="rtsp://foo.com/a,b;seq=3/track2";seq=4`)
+	if err != nil || len(info) != 2 || info[0].URL != "rtsp://foo.com/a,b;c=d/track1" || info[0].Seq != 1 ||
+		info[0].RTPTime != 2 || info[1].URL != "rtsp://foo.com/a,b;seq=3/track2" || info[1].Seq != 4 {
+		t.Errorf("failed: %v %+v", err, info)
+		return
+	}
+	for _, bad := range []string{"", "seq=1", "url=rtsp://foo.com/a;seq=x", `url="rtsp://foo.com/a`} {
+		if _, err := ParseRTPInfo(bad); err == nil {
+			t.Errorf("failed: %q", bad)
+			return
+		}
+	}
+	t.Log("success")
+}
+
+func TestParseScale(t *testing.T) {
+	if scale, err := ParseScale(" -3.5"); err != nil || scale != -3.5 || FormatScale(scale) != "-3.5" {
+		t.Errorf("failed: %v %v", scale, err)
+		return
+	}
+	if _, err := ParseScale("fast"); err == nil {
+		t.Error("failed")
+		return
+	}
+	t.Log("success")
+}
@@ -0,0 +1,313 @@
+package rtsp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// Version is the protocol version of the messages that we write.
+const Version = "RTSP/1.0"
+
+// the largest body that we read; a SDP description is far smaller
+const maxBodySize = 1 << 20
+
+var (
+	ErrBadStartLine     = errors.New("rtsp: malformed start line")
+	ErrBadHeader        = errors.New("rtsp: malformed header line")
+	ErrBadContentLength = errors.New("rtsp: bad Content-Length")
+)
+
+// Request is a RTSP request. HTTP requests, which arrive when RTSP is tunneled
+// over HTTP, are read as Requests too, with a Proto of "HTTP/1.0" or "HTTP/1.1".
+type Request struct {
+	Method string
+	URL    string
+	Proto  string
+	Header Header
+	Body   []byte
+}
+
+// NewRequest creates a RTSP/1.0 request.
+func NewRequest(method, url string) *Request {
+	return &Request{
+		Method: method,
+		URL:    url,
+		Proto:  Version,
+		Header: make(Header),
+	}
+}
+
+// ReadRequest reads a request, including its body, from r.
+func ReadRequest(r *bufio.Reader) (*Request, error) {
+	req, err := ReadRequestHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Body, err = readBody(r, req.Header); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// ReadRequestHeader reads the start line and the headers of a request from r, but not
+// its body, which is left for the caller; see ContentLength.
+func ReadRequestHeader(r *bufio.Reader) (*Request, error) {
+	startLine, header, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.Fields(startLine)
+	if len(fields) != 3 || !isProto(fields[2]) {
+		return nil, ErrBadStartLine
+	}
+
+	return &Request{
+		Method: fields[0],
+		URL:    fields[1],
+		Proto:  fields[2],
+		Header: header,
+	}, nil
+}
+
+// ParseRequest parses a complete request.
+func ParseRequest(data []byte) (*Request, error) {
+	return ReadRequest(bufio.NewReader(bytes.NewReader(data)))
+}
+
+// CSeq returns the sequence number of the request, or -1 if it has none.
+func (r *Request) CSeq() int {
+	return parseCSeq(r.Header)
+}
+
+// ContentLength returns the size of the body, according to the "Content-Length:" header.
+func (r *Request) ContentLength() (int, error) {
+	return contentLength(r.Header)
+}
+
+// Write writes the request. A "Content-Length:" header is added for the body.
+func (r *Request) Write(w io.Writer) error {
+	proto := r.Proto
+	if proto == "" {
+		proto = Version
+	}
+	return writeMessage(w, fmt.Sprintf("%s %s %s", r.Method, r.URL, proto), r.Header, r.Body)
+}
+
+func (r *Request) String() string {
+	var buffer bytes.Buffer
+	r.Write(&buffer)
+	return buffer.String()
+}
+
+// Response is a RTSP response, or the HTTP response to a tunneling request.
+type Response struct {
+	Proto      string
+	StatusCode int
+	Reason     string
+	Header     Header
+	Body       []byte
+}
+
+// NewResponse creates a RTSP/1.0 response, with the standard reason phrase of the status code.
+func NewResponse(statusCode int) *Response {
+	return &Response{
+		Proto:      Version,
+		StatusCode: statusCode,
+		Reason:     StatusText(statusCode),
+		Header:     make(Header),
+	}
+}
+
+// ReadResponse reads a response, including its body, from r.
+func ReadResponse(r *bufio.Reader) (*Response, error) {
+	startLine, header, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	body, err := readBody(r, header)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.SplitN(strings.TrimSpace(startLine), " ", 3)
+	if len(fields) < 2 || !isProto(fields[0]) {
+		return nil, ErrBadStartLine
+	}
+	statusCode, err := strconv.Atoi(fields[1])
+	if err != nil || len(fields[1]) != 3 {
+		return nil, ErrBadStartLine
+	}
+
+	resp := &Response{
+		Proto:      fields[0],
+		StatusCode: statusCode,
+		Header:     header,
+		Body:       body,
+	}
+	if len(fields) == 3 {
+		resp.Reason = strings.TrimSpace(fields[2])
+	}
+	return resp, nil
+}
+
+// ParseResponse parses a complete response.
+func ParseResponse(data []byte) (*Response, error) {
+	return ReadResponse(bufio.NewReader(bytes.NewReader(data)))
+}
+
+// CSeq returns the sequence number of the response, or -1 if it has none.
+func (r *Response) CSeq() int {
+	return parseCSeq(r.Header)
+}
+
+// Write writes the response. A "Content-Length:" header is added for the body.
+func (r *Response) Write(w io.Writer) error {
+	proto, reason := r.Proto, r.Reason
+	if proto == "" {
+		proto = Version
+	}
+	if reason == "" {
+		reason = StatusText(r.StatusCode)
+	}
+	return writeMessage(w, fmt.Sprintf("%s %03d %s", proto, r.StatusCode, reason), r.Header, r.Body)
+}
+
+func (r *Response) String() string {
+	var buffer bytes.Buffer
+	r.Write(&buffer)
+	return buffer.String()
+}
+
+// SplitURL splits the path of a request URL at its last "/", into what live555 calls
+// the URL "pre-suffix" and "suffix". E.g. "rtsp://host:554/live/cam1/track1" gives
+// "live/cam1" and "track1", and "rtsp://host/test.264" gives "" and "test.264".
+// Any query string is left out.
+func SplitURL(url string) (preSuffix, suffix string) {
+	if i := strings.Index(url, "://"); i != -1 {
+		url = url[i+3:]
+		if j := strings.Index(url, "/"); j != -1 {
+			url = url[j:]
+		} else {
+			url = ""
+		}
+	}
+	if i := strings.IndexAny(url, "?#"); i != -1 {
+		url = url[:i]
+	}
+	url = strings.TrimPrefix(url, "/")
+
+	if i := strings.LastIndex(url, "/"); i != -1 {
+		return url[:i], url[i+1:]
+	}
+	return "", url
+}
+
+func isProto(s string) bool {
+	return strings.HasPrefix(s, "RTSP/") || strings.HasPrefix(s, "HTTP/")
+}
+
+func parseCSeq(header Header) int {
+	cseq, err := strconv.Atoi(strings.TrimSpace(header.Get("CSeq")))
+	if err != nil {
+		return -1
+	}
+	return cseq
+}
+
+func readHeader(r *bufio.Reader) (startLine string, header Header, err error) {
+	reader := textproto.NewReader(r)
+
+	// Skip any empty lines before the message:
+	for startLine == "" {
+		if startLine, err = reader.ReadLine(); err != nil {
+			return
+		}
+	}
+
+	header = make(Header)
+	var lastName string
+	for {
+		var line string
+		if line, err = reader.ReadLine(); err != nil {
+			return
+		}
+		if line == "" {
+			break
+		}
+
+		// A line that starts with white space continues the previous header:
+		if line[0] == ' ' || line[0] == '\t' {
+			if lastName == "" {
+				err = ErrBadHeader
+				return
+			}
+			values := header[lastName]
+			values[len(values)-1] += " " + strings.TrimSpace(line)
+			continue
+		}
+
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			err = ErrBadHeader
+			return
+		}
+		lastName = CanonicalHeaderKey(strings.TrimSpace(line[:i]))
+		header.Add(lastName, strings.TrimSpace(line[i+1:]))
+	}
+
+	return
+}
+
+func contentLength(header Header) (int, error) {
+	value := header.Get("Content-Length")
+	if value == "" {
+		return 0, nil
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 0 || size > maxBodySize {
+		return 0, ErrBadContentLength
+	}
+	return size, nil
+}
+
+func readBody(r *bufio.Reader, header Header) ([]byte, error) {
+	size, err := contentLength(header)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func writeMessage(w io.Writer, startLine string, header Header, body []byte) error {
+	if len(body) > 0 {
+		if header == nil {
+			header = make(Header)
+		}
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+
+	if _, err := io.WriteString(w, startLine+"\r\n"); err != nil {
+		return err
+	}
+	if err := header.Write(w); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "\r\n"); err != nil {
+		return err
+	}
+	_, err := w.Write(body)
+	return err
+}
@@ -0,0 +1,83 @@
+package rtsp
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+var announceRequest = "ANNOUNCE rtsp://192.168.1.105:8554/live/cam1 RTSP/1.0\r\n" +
+	"CSeq: 2\r\n" +
+	"content-type: application/sdp\r\n" +
+	"User-Agent: Lavf57.71.100\r\n" +
+	"Content-Length: 12\r\n\r\n" +
+	"v=0\r\ns=Cam\r\n" +
+	"RECORD rtsp://192.168.1.105:8554/live/cam1 RTSP/1.0\r\n" +
+	"cseq: 3\r\n" +
+	"Session: E1155C20;timeout=60\r\n\r\n"
+
+func TestReadRequest(t *testing.T) {
+	reader := bufio.NewReader(strings.NewReader(announceRequest))
+
+	req, err := ReadRequest(reader)
+	if err != nil || req.Method != "ANNOUNCE" || req.CSeq() != 2 ||
+		req.Header.Get("Content-Type") != "application/sdp" || string(req.Body) != "v=0\r\ns=Cam\r\n" {
+		t.Errorf("failed: %v %+v", err, req)
+		return
+	}
+
+	// The next request follows the body:
+	req, err = ReadRequest(reader)
+	if err != nil || req.Method != "RECORD" || req.CSeq() != 3 || req.Header.Get("SESSION") != "E1155C20;timeout=60" {
+		t.Errorf("failed: %v %+v", err, req)
+		return
+	}
+
+	preSuffix, suffix := SplitURL(req.URL)
+	if preSuffix != "live" || suffix != "cam1" {
+		t.Errorf("failed: %s %s", preSuffix, suffix)
+		return
+	}
+	t.Log("success")
+}
+
+func TestResponseRoundTrip(t *testing.T) {
+	resp := NewResponse(StatusOK)
+	resp.Header.Set("cseq", "4")
+	resp.Header.Set("content-base", "rtsp://192.168.1.105:8554/test.264/")
+	resp.Header.Add("WWW-Authenticate", "Digest realm=\"dorsvr\", nonce=\"abc\"")
+	resp.Header.Add("www-authenticate", "Basic realm=\"dorsvr\"")
+	resp.Body = []byte("v=0\r\n")
+
+	data := resp.String()
+	if !strings.HasPrefix(data, "RTSP/1.0 200 OK\r\nCSeq: 4\r\n") || !strings.Contains(data, "Content-Length: 5\r\n") {
+		t.Errorf("failed: %q", data)
+		return
+	}
+
+	parsed, err := ParseResponse([]byte(data))
+	if err != nil || parsed.StatusCode != StatusOK || parsed.Reason != "OK" || parsed.CSeq() != 4 ||
+		len(parsed.Header.Values("WWW-Authenticate")) != 2 || string(parsed.Body) != "v=0\r\n" {
+		t.Errorf("failed: %v %+v", err, parsed)
+		return
+	}
+	t.Log("success")
+}
+
+func TestParseBadMessages(t *testing.T) {
+	bad := []string{
+		"OPTIONS\r\n\r\n",
+		"OPTIONS * RTSP/1.0\r\nCSeq 1\r\n\r\n",
+		"OPTIONS * RTSP/1.0\r\nContent-Length: -1\r\n\r\n",
+		"OPTIONS * RTSP/1.0\r\nContent-Length: 10\r\n\r\nv=0",
+	}
+	for _, data := range bad {
+		if _, err := ParseRequest([]byte(data)); err == nil {
+			t.Errorf("failed to refuse %q", data)
+		}
+	}
+
+	if _, err := ParseResponse([]byte("RTSP/1.0 2000 OK\r\n\r\n")); err == nil {
+		t.Error("failed")
+	}
+}
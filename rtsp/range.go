@@ -0,0 +1,129 @@
+package rtsp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrBadRange = errors.New("rtsp: malformed Range header")
+
+// Range is the value of a "Range:" header (RFC 2326, section 12.29), such as
+// "npt=10-20", "npt=now-" or "clock=19961108T142300Z-".
+type Range struct {
+	// "npt", "clock" or "smpte"
+	Unit string
+	// as written; End is "" for an open range
+	Start string
+	End   string
+	// the value of a ";time=" parameter, if any
+	Time string
+}
+
+// NewNPTRange creates a range of normal play time, in seconds. A negative end leaves the range open.
+func NewNPTRange(start, end float64) *Range {
+	r := &Range{
+		Unit:  "npt",
+		Start: strconv.FormatFloat(start, 'f', 3, 64),
+	}
+	if end >= 0 {
+		r.End = strconv.FormatFloat(end, 'f', 3, 64)
+	}
+	return r
+}
+
+// ParseRange parses a "Range:" header.
+func ParseRange(s string) (*Range, error) {
+	params := strings.Split(s, ";")
+
+	spec := strings.TrimSpace(params[0])
+	i := strings.Index(spec, "=")
+	if i == -1 {
+		return nil, ErrBadRange
+	}
+
+	r := &Range{
+		Unit: strings.ToLower(strings.TrimSpace(spec[:i])),
+	}
+	if r.Unit != "npt" && r.Unit != "clock" && r.Unit != "smpte" && !strings.HasPrefix(r.Unit, "smpte-") {
+		return nil, ErrBadRange
+	}
+
+	// The clock and npt values don't contain '-', but the dates of "clock" may:
+	times := strings.TrimSpace(spec[i+1:])
+	j := strings.LastIndex(times, "-")
+	if r.Unit == "clock" {
+		j = strings.Index(times, "Z-")
+		if j != -1 {
+			j++
+		} else if strings.HasPrefix(times, "-") {
+			j = 0
+		}
+	}
+	if j == -1 {
+		return nil, ErrBadRange
+	}
+	r.Start, r.End = strings.TrimSpace(times[:j]), strings.TrimSpace(times[j+1:])
+	if r.Start == "" && r.End == "" {
+		return nil, ErrBadRange
+	}
+
+	for _, param := range params[1:] {
+		param = strings.TrimSpace(param)
+		if strings.HasPrefix(strings.ToLower(param), "time=") {
+			r.Time = param[5:]
+		}
+	}
+
+	if r.Unit == "npt" {
+		if _, _, err := r.NPT(); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// NPT returns the start and end of a range of normal play time, in seconds.
+// "now" gives a start of 0, and an open range gives an end of -1.
+func (r *Range) NPT() (start, end float64, err error) {
+	if r.Unit != "npt" {
+		return 0, -1, ErrBadRange
+	}
+
+	if start, err = parseNPTTime(r.Start); err != nil {
+		return 0, -1, err
+	}
+	end = -1
+	if r.End != "" {
+		if end, err = parseNPTTime(r.End); err != nil {
+			return 0, -1, err
+		}
+	}
+	return start, end, nil
+}
+
+// parseNPTTime parses "now", "<seconds>[.<fraction>]" or "<hh>:<mm>:<ss>[.<fraction>]".
+func parseNPTTime(s string) (float64, error) {
+	if s == "" || strings.EqualFold(s, "now") {
+		return 0, nil
+	}
+
+	var seconds float64
+	for _, field := range strings.Split(s, ":") {
+		value, err := strconv.ParseFloat(field, 64)
+		if err != nil || value < 0 {
+			return 0, ErrBadRange
+		}
+		seconds = seconds*60 + value
+	}
+	return seconds, nil
+}
+
+func (r *Range) String() string {
+	s := fmt.Sprintf("%s=%s-%s", r.Unit, r.Start, r.End)
+	if r.Time != "" {
+		s += ";time=" + r.Time
+	}
+	return s
+}
@@ -0,0 +1,120 @@
+package rtsp
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var ErrBadRTPInfo = errors.New("rtsp: malformed RTP-Info header")
+
+// RTPInfoEntry describes the first packet of a stream after a PLAY (RFC 2326, section 12.33).
+type RTPInfoEntry struct {
+	URL        string
+	Seq        uint16
+	RTPTime    uint32
+	HasSeq     bool
+	HasRTPTime bool
+}
+
+// RTPInfo is the value of a "RTP-Info:" header, with an entry for each stream.
+type RTPInfo []RTPInfoEntry
+
+// the end of an unquoted URL: the next parameter of its entry, or the next entry
+var rtpInfoURLEnd = regexp.MustCompile(`(?i)\s*(;\s*(seq|rtptime)\s*=|,\s*url\s*=)`)
+
+// ParseRTPInfo parses a "RTP-Info:" header. A URL may contain "," and ";", so an unquoted
+// one ends only at the "seq" or "rtptime" parameter that follows it, or at the next entry.
+func ParseRTPInfo(s string) (RTPInfo, error) {
+	var info RTPInfo
+	for rest := s; ; {
+		entry, next, err := parseRTPInfoEntry(rest)
+		if err != nil {
+			return nil, err
+		}
+		info = append(info, entry)
+		if next == "" {
+			return info, nil
+		}
+		rest = next
+	}
+}
+
+// parseRTPInfoEntry parses the entry at the start of s, and returns what follows its ",".
+func parseRTPInfoEntry(s string) (entry RTPInfoEntry, rest string, err error) {
+	for {
+		i := strings.Index(s, "=")
+		if i == -1 || strings.ContainsAny(s[:i], ";,") {
+			return entry, "", ErrBadRTPInfo
+		}
+		name := strings.ToLower(strings.TrimSpace(s[:i]))
+		s = strings.TrimLeft(s[i+1:], " \t")
+
+		var value string
+		switch {
+		case name == "url" && strings.HasPrefix(s, `"`):
+			end := strings.Index(s[1:], `"`)
+			if end == -1 {
+				return entry, "", ErrBadRTPInfo
+			}
+			value, s = s[1:end+1], s[end+2:]
+		case name == "url":
+			end := len(s)
+			if loc := rtpInfoURLEnd.FindStringIndex(s); loc != nil {
+				end = loc[0]
+			}
+			value, s = s[:end], s[end:]
+		default:
+			end := strings.IndexAny(s, ";,")
+			if end == -1 {
+				end = len(s)
+			}
+			value, s = s[:end], s[end:]
+		}
+		value = strings.TrimSpace(value)
+
+		switch name {
+		case "url":
+			entry.URL = value
+		case "seq":
+			seq, err := strconv.ParseUint(value, 10, 16)
+			if err != nil {
+				return entry, "", ErrBadRTPInfo
+			}
+			entry.Seq, entry.HasSeq = uint16(seq), true
+		case "rtptime":
+			rtpTime, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return entry, "", ErrBadRTPInfo
+			}
+			entry.RTPTime, entry.HasRTPTime = uint32(rtpTime), true
+		}
+
+		s = strings.TrimLeft(s, " \t")
+		if s == "" || s[0] == ',' {
+			if entry.URL == "" {
+				return entry, "", ErrBadRTPInfo
+			}
+			return entry, strings.TrimPrefix(s, ","), nil
+		}
+		if s[0] != ';' {
+			return entry, "", ErrBadRTPInfo
+		}
+		s = s[1:]
+	}
+}
+
+func (info RTPInfo) String() string {
+	entries := make([]string, len(info))
+	for i, entry := range info {
+		entries[i] = "url=" + entry.URL
+		if entry.HasSeq {
+			entries[i] += ";seq=" + strconv.FormatUint(uint64(entry.Seq), 10)
+		}
+		if entry.HasRTPTime {
+			entries[i] += ";rtptime=" + strconv.FormatUint(uint64(entry.RTPTime), 10)
+		}
+	}
+	return strings.Join(entries, ",")
+}
@@ -0,0 +1,23 @@
+package rtsp
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var ErrBadScale = errors.New("rtsp: malformed Scale header")
+
+// ParseScale parses a "Scale:" (or "Speed:") header (RFC 2326, section 12.34).
+func ParseScale(s string) (float64, error) {
+	scale, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || scale == 0 {
+		return 0, ErrBadScale
+	}
+	return scale, nil
+}
+
+// FormatScale formats a scale for a "Scale:" header.
+func FormatScale(scale float64) string {
+	return strconv.FormatFloat(scale, 'f', -1, 64)
+}
@@ -0,0 +1,47 @@
+package rtsp
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var ErrBadSession = errors.New("rtsp: malformed Session header")
+
+// Session is the value of a "Session:" header (RFC 2326, section 12.37).
+type Session struct {
+	ID string
+	// in seconds; 0 when the header doesn't have a ";timeout=" parameter
+	Timeout int
+}
+
+// ParseSession parses a "Session:" header.
+func ParseSession(s string) (*Session, error) {
+	params := strings.Split(s, ";")
+
+	session := &Session{
+		ID: strings.TrimSpace(params[0]),
+	}
+	if session.ID == "" {
+		return nil, ErrBadSession
+	}
+
+	for _, param := range params[1:] {
+		param = strings.TrimSpace(param)
+		if strings.HasPrefix(strings.ToLower(param), "timeout=") {
+			timeout, err := strconv.Atoi(param[8:])
+			if err != nil || timeout < 0 {
+				return nil, ErrBadSession
+			}
+			session.Timeout = timeout
+		}
+	}
+	return session, nil
+}
+
+func (s *Session) String() string {
+	if s.Timeout > 0 {
+		return s.ID + ";timeout=" + strconv.Itoa(s.Timeout)
+	}
+	return s.ID
+}
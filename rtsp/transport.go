@@ -0,0 +1,207 @@
+package rtsp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrBadTransport = errors.New("rtsp: malformed Transport header")
+
+// PortRange is a pair of ports (or of interleaved channel ids), such as "5000-5001".
+// A single port is parsed as a range whose End equals its Start.
+type PortRange struct {
+	Start int
+	End   int
+}
+
+func parsePortRange(s string) (*PortRange, error) {
+	start, end := s, s
+	if i := strings.Index(s, "-"); i != -1 {
+		start, end = s[:i], s[i+1:]
+	}
+
+	var r PortRange
+	var err error
+	if r.Start, err = strconv.Atoi(start); err != nil || r.Start < 0 || r.Start > 65535 {
+		return nil, ErrBadTransport
+	}
+	if r.End, err = strconv.Atoi(end); err != nil || r.End < r.Start || r.End > 65535 {
+		return nil, ErrBadTransport
+	}
+	return &r, nil
+}
+
+func (r *PortRange) String() string {
+	if r.End == r.Start {
+		return strconv.Itoa(r.Start)
+	}
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
+// Transport is one transport specification of a "Transport:" header (RFC 2326, section 12.39).
+// The ranges that aren't in the header are nil.
+type Transport struct {
+	// e.g. "RTP/AVP", "RAW/RAW" or "MP2T/H2221"
+	Protocol string
+	// "TCP", "UDP", or "" when the header doesn't say (which means UDP)
+	LowerTransport string
+	Multicast      bool
+	Destination    string
+	Source         string
+	TTL            int
+	Port           *PortRange
+	ClientPort     *PortRange
+	ServerPort     *PortRange
+	Interleaved    *PortRange
+	SSRC           string
+	// "PLAY" (which is also what "" means), or "RECORD"
+	Mode   string
+	Append bool
+}
+
+// ParseTransport parses the first transport specification of a "Transport:" header.
+func ParseTransport(s string) (*Transport, error) {
+	transports, err := ParseTransports(s)
+	if err != nil {
+		return nil, err
+	}
+	return transports[0], nil
+}
+
+// ParseTransports parses all the transport specifications of a "Transport:" header,
+// in the client's order of preference.
+func ParseTransports(s string) ([]*Transport, error) {
+	var transports []*Transport
+	for _, spec := range strings.Split(s, ",") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+
+		t, err := parseTransportSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		transports = append(transports, t)
+	}
+
+	if len(transports) == 0 {
+		return nil, ErrBadTransport
+	}
+	return transports, nil
+}
+
+func parseTransportSpec(spec string) (*Transport, error) {
+	params := strings.Split(spec, ";")
+
+	// "transport/profile[/lower-transport]":
+	protocol := strings.Split(strings.TrimSpace(params[0]), "/")
+	if len(protocol) < 2 || len(protocol) > 3 || protocol[0] == "" || protocol[1] == "" {
+		return nil, ErrBadTransport
+	}
+
+	t := &Transport{
+		Protocol: strings.ToUpper(protocol[0] + "/" + protocol[1]),
+	}
+	if len(protocol) == 3 {
+		t.LowerTransport = strings.ToUpper(protocol[2])
+		if t.LowerTransport != "TCP" && t.LowerTransport != "UDP" {
+			return nil, ErrBadTransport
+		}
+	}
+
+	var err error
+	for _, param := range params[1:] {
+		param = strings.TrimSpace(param)
+		name, value := param, ""
+		if i := strings.Index(param, "="); i != -1 {
+			name, value = param[:i], strings.Trim(param[i+1:], "\"")
+		}
+
+		switch strings.ToLower(name) {
+		case "":
+		case "unicast":
+			t.Multicast = false
+		case "multicast":
+			t.Multicast = true
+		case "destination":
+			t.Destination = value
+		case "source":
+			t.Source = value
+		case "ttl":
+			if t.TTL, err = strconv.Atoi(value); err != nil || t.TTL < 0 || t.TTL > 255 {
+				return nil, ErrBadTransport
+			}
+		case "port":
+			t.Port, err = parsePortRange(value)
+		case "client_port":
+			t.ClientPort, err = parsePortRange(value)
+		case "server_port":
+			t.ServerPort, err = parsePortRange(value)
+		case "interleaved":
+			t.Interleaved, err = parsePortRange(value)
+		case "ssrc":
+			t.SSRC = value
+		case "mode":
+			t.Mode = strings.ToUpper(value)
+		case "append":
+			t.Append = true
+		default:
+			// ignore the parameters that we don't know
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// IsTCP reports whether the transport interleaves the data with the RTSP connection.
+func (t *Transport) IsTCP() bool {
+	return t.LowerTransport == "TCP"
+}
+
+func (t *Transport) String() string {
+	params := []string{t.Protocol}
+	if t.LowerTransport != "" {
+		params[0] += "/" + t.LowerTransport
+	}
+
+	if t.Multicast {
+		params = append(params, "multicast")
+	} else {
+		params = append(params, "unicast")
+	}
+	if t.Destination != "" {
+		params = append(params, "destination="+t.Destination)
+	}
+	if t.Source != "" {
+		params = append(params, "source="+t.Source)
+	}
+	if t.Port != nil {
+		params = append(params, "port="+t.Port.String())
+	}
+	if t.ClientPort != nil {
+		params = append(params, "client_port="+t.ClientPort.String())
+	}
+	if t.ServerPort != nil {
+		params = append(params, "server_port="+t.ServerPort.String())
+	}
+	if t.Interleaved != nil {
+		params = append(params, "interleaved="+t.Interleaved.String())
+	}
+	if t.TTL > 0 {
+		params = append(params, "ttl="+strconv.Itoa(t.TTL))
+	}
+	if t.SSRC != "" {
+		params = append(params, "ssrc="+t.SSRC)
+	}
+	if t.Mode != "" {
+		params = append(params, "mode="+strings.ToLower(t.Mode))
+	}
+	if t.Append {
+		params = append(params, "append")
+	}
+	return strings.Join(params, ";")
+}
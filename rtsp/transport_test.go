@@ -0,0 +1,57 @@
+package rtsp
+
+import "testing"
+
+func TestParseTransport(t *testing.T) {
+	transports, err := ParseTransports("RTP/AVP/TCP;unicast;interleaved=0-1;mode=\"RECORD\", " +
+		"RTP/AVP;unicast;client_port=37175-37176")
+	if err != nil || len(transports) != 2 {
+		t.Errorf("failed: %v", err)
+		return
+	}
+
+	tcp, udp := transports[0], transports[1]
+	if !tcp.IsTCP() || tcp.Interleaved == nil || tcp.Interleaved.Start != 0 || tcp.Interleaved.End != 1 ||
+		tcp.Mode != "RECORD" {
+		t.Errorf("failed: %+v", tcp)
+		return
+	}
+	if udp.IsTCP() || udp.ClientPort == nil || udp.ClientPort.Start != 37175 || udp.ClientPort.End != 37176 {
+		t.Errorf("failed: %+v", udp)
+		return
+	}
+	t.Log("success")
+}
+
+func TestTransportString(t *testing.T) {
+	transport := &Transport{
+		Protocol:    "RTP/AVP",
+		Destination: "192.168.1.100",
+		Source:      "192.168.1.105",
+		ClientPort:  &PortRange{37175, 37176},
+		ServerPort:  &PortRange{6970, 6971},
+	}
+
+	s := transport.String()
+	if s != "RTP/AVP;unicast;destination=192.168.1.100;source=192.168.1.105;"+
+		"client_port=37175-37176;server_port=6970-6971" {
+		t.Errorf("failed: %s", s)
+		return
+	}
+
+	parsed, err := ParseTransport(s)
+	if err != nil || parsed.String() != s {
+		t.Errorf("failed: %v", err)
+		return
+	}
+	t.Log("success")
+}
+
+func TestParseBadTransport(t *testing.T) {
+	bad := []string{"", "RTP", "RTP/AVP/SCTP", "RTP/AVP;client_port=a-b", "RTP/AVP;interleaved=3-2", "RTP/AVP;ttl=300"}
+	for _, s := range bad {
+		if _, err := ParseTransport(s); err == nil {
+			t.Errorf("failed to refuse %q", s)
+		}
+	}
+}
@@ -1,16 +1,17 @@
 package rtspclient
 
 import (
-	"encoding/base64"
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
 	"fmt"
 	"net"
-	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/djwackey/dorsvr/auth"
-	gs "github.com/djwackey/dorsvr/groupsock"
 	"github.com/djwackey/dorsvr/livemedia"
+	"github.com/djwackey/dorsvr/rtsp"
 )
 
 // default value; you can reassign this in your application if you need to
@@ -21,25 +22,25 @@ type RTSPClient struct {
 	lastSessionID                 string
 	sessionCookie                 string
 	serverAddress                 string
-	userAgentHeaderStr            string
-	responseBuffer                []byte
+	userAgent                     string
 	cseq                          int
 	tcpStreamIDCount              uint
 	tunnelOverHTTPPortNum         uint
 	responseBufferBytesLeft       uint
 	responseBytesAlreadySeen      uint
 	digest                        *auth.Digest
-	tcpConn                       *net.TCPConn
+	tcpConn                       net.Conn
 	scs                           *StreamClientState
 	requestsAwaitingResponse      *RequestQueue
 	requestsAwaitingHTTPTunneling *RequestQueue
+	authScheme                    string // of the server's challenge; "" until we're challenged
+	tlsConfig                     *tls.Config
 }
 
 func New() *RTSPClient {
 	return &RTSPClient{
 		scs:                      newStreamClientState(),
 		digest:                   auth.NewDigest(),
-		responseBuffer:           make([]byte, responseBufferSize),
 		requestsAwaitingResponse: newRequestQueue(),
 	}
 }
@@ -61,6 +62,12 @@ func (c *RTSPClient) DialRTSP(rtspURL string) bool {
 	return true
 }
 
+// SetTLSConfig sets the TLS config of "rtsps://" URLs, e.g. to trust the certificate of a
+// server; by default, the server's certificate is checked against the system's roots.
+func (c *RTSPClient) SetTLSConfig(config *tls.Config) {
+	c.tlsConfig = config
+}
+
 func (c *RTSPClient) SendRequest() bool {
 	sendBytes := c.sendDescribeCommand(continueAfterDESCRIBE)
 	if sendBytes == 0 {
@@ -88,8 +95,7 @@ func (c *RTSPClient) init(rtspURL, appName string) {
 		libSuffix = ")"
 	}
 
-	c.userAgentHeaderStr = fmt.Sprintf("User-Agent: %s%s%s%s%s\r\n",
-		appName, libPrefix, libName, libVersionStr, libSuffix)
+	c.userAgent = fmt.Sprintf("%s%s%s%s%s", appName, libPrefix, libName, libVersionStr, libSuffix)
 }
 
 func (c *RTSPClient) sendOptionsCommand(responseHandler interface{}) int {
@@ -164,15 +170,15 @@ func (c *RTSPClient) openConnection() bool {
 
 	c.serverAddress = rtspUrl.address
 
-	err := c.connectToServer(rtspUrl.address, rtspUrl.port)
+	err := c.connectToServer(rtspUrl.address, rtspUrl.port, rtspUrl.tls)
 	if err != nil {
 		return false
 	}
 	return true
 }
 
-func (c *RTSPClient) connectToServer(host string, port int) error {
-	tcpAddr := fmt.Sprintf("%s:%d", host, port)
+func (c *RTSPClient) connectToServer(host string, port int, useTLS bool) error {
+	tcpAddr := net.JoinHostPort(host, strconv.Itoa(port))
 	addr, err := net.ResolveTCPAddr("tcp", tcpAddr)
 	if err != nil {
 		fmt.Printf("Failed to resolve TCP address.%s\n", err.Error())
@@ -181,11 +187,30 @@ func (c *RTSPClient) connectToServer(host string, port int) error {
 
 	fmt.Printf("Opening connection to %s, port %d...\n", host, port)
 
-	c.tcpConn, err = net.DialTCP("tcp", nil, addr)
+	tcpConn, err := net.DialTCP("tcp", nil, addr)
 	if err != nil {
 		fmt.Printf("Failed to connect to server.%s\n", err.Error())
 		return err
 	}
+	c.tcpConn = tcpConn
+
+	if useTLS {
+		config := &tls.Config{}
+		if c.tlsConfig != nil {
+			config = c.tlsConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = host
+		}
+
+		tlsConn := tls.Client(tcpConn, config)
+		if err = tlsConn.Handshake(); err != nil {
+			fmt.Printf("Failed to set up TLS with server.%s\n", err.Error())
+			tcpConn.Close()
+			return err
+		}
+		c.tcpConn = tlsConn
+	}
 
 	fmt.Println("...remote connection opened")
 	return nil
@@ -196,17 +221,11 @@ func (c *RTSPClient) resetTCPSockets() {
 }
 
 func (c *RTSPClient) createAuthenticatorStr(cmd, url string) (s string) {
-	if c.digest.Realm != "" && c.digest.Username != "" && c.digest.Password != "" {
-		var response string
-		if c.digest.Nonce != "" { // digest authentication
-			response = c.digest.ComputeResponse(cmd, url)
-			s = fmt.Sprintf("Authorization: Digest username=\"%s\", "+
-				"realm=\"%s\", nonce=\"%s\", uri=\"%s\", response=\"%s\"\r\n",
-				c.digest.Username, c.digest.Realm, c.digest.Nonce, url, response)
+	if c.authScheme != "" && c.digest.Username != "" && c.digest.Password != "" {
+		if c.authScheme == "Digest" { // digest authentication
+			s = c.digest.Authorization(cmd, url)
 		} else { // basic authentication
-			usernamePassword := fmt.Sprintf("%s:%s", c.digest.Username, c.digest.Password)
-			response = base64.StdEncoding.EncodeToString([]byte(usernamePassword))
-			s = fmt.Sprintf("Authorization: Basic %s\r\n", response)
+			s = c.digest.BasicAuthorization()
 		}
 	}
 
@@ -219,16 +238,21 @@ type RTSPURL struct {
 	password   string
 	address    string
 	port       int
+	// "rtsps://", i.e. RTSP over TLS
+	tls bool
 }
 
 func (c *RTSPClient) parseRTSPURL(url string) (*RTSPURL, bool) {
 	rtspUrl := new(RTSPURL)
 	var result bool
 	for {
-		// Parse the URL as "rtsp://[<username>[:<password>]@]<server-address-or-name>[:<port>][/<stream-name>]"
+		// Parse the URL as "rtsp[s]://[<username>[:<password>]@]<server-address-or-name>[:<port>][/<stream-name>]",
+		// where an IPv6 address is in brackets, e.g. "rtsp://[::1]:8554/test.264"
 		prefix := "rtsp://"
-		ret := strings.HasPrefix(url, prefix)
-		if !ret {
+		if strings.HasPrefix(url, "rtsps://") {
+			prefix = "rtsps://"
+			rtspUrl.tls = true
+		} else if !strings.HasPrefix(url, prefix) {
 			fmt.Println("URL is not of the form \"" + prefix + "\"")
 			break
 		}
@@ -237,7 +261,7 @@ func (c *RTSPClient) parseRTSPURL(url string) (*RTSPURL, bool) {
 		index := strings.Index(url, "@")
 		if index != -1 {
 			// found "@"
-			s := strings.Split(url[7:index], ":")
+			s := strings.Split(url[len(prefix):index], ":")
 			if len(s) <= 1 {
 				fmt.Println("URL is not of the form \"" + url + "\"")
 				break
@@ -246,7 +270,7 @@ func (c *RTSPClient) parseRTSPURL(url string) (*RTSPURL, bool) {
 			rtspUrl.username, rtspUrl.password = s[0], s[1]
 			index++
 		} else {
-			index = 7
+			index = len(prefix)
 		}
 
 		parseBufferSize := 1000
@@ -263,18 +287,24 @@ func (c *RTSPClient) parseRTSPURL(url string) (*RTSPURL, bool) {
 		}
 		rtspUrl.streamName = substrings[1]
 
-		substrings = strings.Split(substrings[0], ":")
-		if len(substrings) > 1 {
-			rtspUrl.port, _ = strconv.Atoi(substrings[1])
+		host, port, err := net.SplitHostPort(substrings[0])
+		if err != nil {
+			// no port
+			host, port = strings.TrimSuffix(strings.TrimPrefix(substrings[0], "["), "]"), ""
+		}
+		if port != "" {
+			rtspUrl.port, _ = strconv.Atoi(port)
 			if rtspUrl.port < 1 || rtspUrl.port > 65535 {
 				fmt.Println("Bad Port Number")
 				break
 			}
+		} else if rtspUrl.tls {
+			rtspUrl.port = 322 // default of RTSPS
 		} else {
 			rtspUrl.port = 554 // default
 		}
 
-		rtspUrl.address = substrings[0]
+		rtspUrl.address = host
 		result = true
 		break
 	}
@@ -283,126 +313,99 @@ func (c *RTSPClient) parseRTSPURL(url string) (*RTSPURL, bool) {
 
 func (c *RTSPClient) incomingDataHandler() {
 	defer c.tcpConn.Close()
+
+	reader := bufio.NewReaderSize(c.tcpConn, responseBufferSize)
 	for {
-		readBytes, err := gs.ReadSocket(c.tcpConn, c.responseBuffer)
+		start, err := reader.Peek(1)
 		if err != nil {
 			fmt.Println("Failed to read bytes.", err.Error())
 			break
 		}
 
-		c.handleResponseBytes(c.responseBuffer, readBytes)
-	}
-}
-
-func getLine(startOfLine string) (thisLineStart, nextLineStart string) {
-	var index int
-	for i, c := range startOfLine {
-		// Check for the end of line: \r\n (but also accept \r or \n by itself):
-		if c == '\r' || c == '\n' {
-			if c == '\r' {
-				if startOfLine[i+1] == '\n' {
-					index = i + 2 // skip "\r\n"
-				}
-			} else {
-				index = i + 1
-			}
-
-			thisLineStart = startOfLine[:i]
-			nextLineStart = startOfLine[index:]
+		switch {
+		case start[0] == '$':
+			// A RTP or RTCP packet, interleaved with the responses; we don't handle those here:
+			err = skipInterleavedPacket(reader)
+		case start[0] == '\r' || start[0] == '\n':
+			_, err = reader.Discard(1)
+		default:
+			err = c.handleIncomingMessage(reader)
+		}
+		if err != nil {
+			fmt.Println("Failed to read a message.", err.Error())
 			break
 		}
 	}
-	return nextLineStart, thisLineStart
 }
 
-func (c *RTSPClient) handleResponseBytes(buffer []byte, length int) {
-	reqStr := string(buffer)[:length]
+func skipInterleavedPacket(reader *bufio.Reader) error {
+	header, err := reader.Peek(4)
+	if err != nil {
+		return err
+	}
+	_, err = reader.Discard(4 + int(binary.BigEndian.Uint16(header[2:4])))
+	return err
+}
 
-	fmt.Printf("Received %d new bytes of response data.\n", length)
+// handleIncomingMessage reads a response to one of our requests, or a request from the server.
+func (c *RTSPClient) handleIncomingMessage(reader *bufio.Reader) error {
+	start, err := reader.Peek(5)
+	if err != nil {
+		return err
+	}
 
-	nextLineStart, thisLineStart := getLine(reqStr)
-	responseCode, responseString, result := c.parseResponseCode(thisLineStart)
-	if !result {
+	if string(start) != "RTSP/" {
 		// This does not appear to be a RTSP response; is's a RTSP request instead?
-		c.handleIncomingRequest(reqStr, length)
-		return
+		req, err := rtsp.ReadRequest(reader)
+		if err != nil {
+			return err
+		}
+		c.handleIncomingRequest(req)
+		return nil
 	}
 
-	var n, cseq, contentLength int
-	var rangeParamsStr, rtpInfoParamsStr string
-	var headerParamsStr, sessionParamsStr string
-	var transportParamsStr, scaleParamsStr string
-	var wwwAuthenticateParamsStr, publicParamsStr string
-	var foundRequest *RequestRecord
-	var responseSuccess bool
-
-	for {
-		nextLineStart, thisLineStart = getLine(nextLineStart)
-		if thisLineStart == "" {
-			break
-		}
+	resp, err := rtsp.ReadResponse(reader)
+	if err != nil {
+		return err
+	}
+	c.handleResponse(resp)
+	return nil
+}
 
-		if headerParamsStr, result = c.checkForHeader(thisLineStart, "CSeq:", 5); result {
-			n, _ = fmt.Sscanf(headerParamsStr, "%d", &cseq)
-			if n != 1 || cseq <= 0 {
-				fmt.Println("Bad \"CSeq\" header: \"", thisLineStart, "\"")
+func (c *RTSPClient) handleResponse(resp *rtsp.Response) {
+	var foundRequest *RequestRecord
+	if cseq := resp.CSeq(); cseq > 0 {
+		for {
+			request := c.requestsAwaitingResponse.dequeue()
+			if request == nil {
 				break
 			}
 
-			for {
-				request := c.requestsAwaitingResponse.dequeue()
-				if request == nil {
-					break
-				}
-
-				if request.cseq < cseq {
-					//fmt.Println("WARNING: The server did not respond to our \"", request.CommandName(), "\"")
-				} else if request.cseq == cseq {
-					// This is the handler that we want. Remove its record, but remember it,
-					// so that we can later call its handler:
-					foundRequest = request
-				} else {
-					break
-				}
-			}
-		} else if headerParamsStr, result = c.checkForHeader(thisLineStart, "Content-Length:", 15); result {
-			if n, _ = fmt.Sscanf(headerParamsStr, "%d", &contentLength); n != 1 {
-				fmt.Println("Bad \"Content-Length\" header: \"", thisLineStart, "\"")
+			if request.cseq < cseq {
+				//fmt.Println("WARNING: The server did not respond to our \"", request.CommandName(), "\"")
+			} else if request.cseq == cseq {
+				// This is the handler that we want. Remove its record, but remember it,
+				// so that we can later call its handler:
+				foundRequest = request
+			} else {
 				break
 			}
-		} else if headerParamsStr, result = c.checkForHeader(thisLineStart, "Content-Base:", 13); result {
-			c.baseURL = headerParamsStr
-		} else if headerParamsStr, result = c.checkForHeader(thisLineStart, "Session:", 8); result {
-			sessionParamsStr = headerParamsStr
-		} else if headerParamsStr, result = c.checkForHeader(thisLineStart, "Transport:", 10); result {
-			transportParamsStr = headerParamsStr
-		} else if headerParamsStr, result = c.checkForHeader(thisLineStart, "Scale:", 6); result {
-			scaleParamsStr = headerParamsStr
-		} else if headerParamsStr, result = c.checkForHeader(thisLineStart, "Range:", 6); result {
-			rangeParamsStr = headerParamsStr
-		} else if headerParamsStr, result = c.checkForHeader(thisLineStart, "RTP-Info:", 9); result {
-			rtpInfoParamsStr = headerParamsStr
-		} else if headerParamsStr, result = c.checkForHeader(thisLineStart, "WWW-Authenticate:", 17); result {
-			// If we've already seen a "WWW-Authenticate:" header, then we replace it with this new one only if
-			// the new one specifies "Digest" authentication:
-			if wwwAuthenticateParamsStr == "" || headerParamsStr[:6] == "Digest" {
-				wwwAuthenticateParamsStr = headerParamsStr
-			}
-		} else if headerParamsStr, result = c.checkForHeader(thisLineStart, "Public:", 7); result {
-			publicParamsStr = headerParamsStr
-		} else if headerParamsStr, result = c.checkForHeader(thisLineStart, "Allow:", 6); result {
-		} else if headerParamsStr, result = c.checkForHeader(thisLineStart, "Location:", 9); result {
-			c.baseURL = headerParamsStr
 		}
+	} else {
+		fmt.Println("Bad \"CSeq\" header: \"", resp.Header.Get("CSeq"), "\"")
+	}
+
+	if contentBase := resp.Header.Get("Content-Base"); contentBase != "" {
+		c.baseURL = contentBase
+	}
+	if location := resp.Header.Get("Location"); location != "" {
+		c.baseURL = location
 	}
 
 	if foundRequest == nil {
 		foundRequest = c.requestsAwaitingResponse.dequeue()
 	}
 
-	bodyStart := nextLineStart
-	numBodyBytes := len(bodyStart)
-
 	var commandName string
 	if foundRequest != nil {
 		commandName = foundRequest.commandName
@@ -410,19 +413,18 @@ func (c *RTSPClient) handleResponseBytes(buffer []byte, length int) {
 		commandName = "(unknown)"
 	}
 
-	fmt.Printf("Received a complete %s response:\n%s\n", commandName, reqStr)
+	fmt.Printf("Received a complete %s response:\n%s\n", commandName, resp)
 
 	var needToResendCommand bool
 	if foundRequest != nil {
-		if responseCode == 200 {
+		if resp.StatusCode == rtsp.StatusOK {
 			switch foundRequest.commandName {
 			case "SETUP":
-				if !c.handleSetupResponse(foundRequest.subsession,
-					sessionParamsStr, transportParamsStr, false) {
+				if !c.handleSetupResponse(foundRequest.subsession, resp, false) {
 					break
 				}
 			case "PLAY":
-				if !c.handlePlayResponse(scaleParamsStr, rangeParamsStr, rtpInfoParamsStr) {
+				if !c.handlePlayResponse(resp) {
 					break
 				}
 			case "TEARDOWN":
@@ -435,14 +437,16 @@ func (c *RTSPClient) handleResponseBytes(buffer []byte, length int) {
 				}
 			default:
 			}
-		} else if responseCode == 401 && c.handleAuthenticationFailure(wwwAuthenticateParamsStr) {
+		} else if resp.StatusCode == rtsp.StatusUnauthorized &&
+			c.handleAuthenticationFailure(resp.Header.Values("WWW-Authenticate")) {
 			// We need to resend the command, with an "Authorization:" header:
 			needToResendCommand = true
 
 			if foundRequest.commandName == "GET" {
 				c.resetTCPSockets()
 			}
-		} else if responseCode == 301 || responseCode == 302 { // redirect
+		} else if resp.StatusCode == rtsp.StatusMovedPermanently ||
+			resp.StatusCode == rtsp.StatusMovedTemporarily { // redirect
 			// because we need to connect somewhere else next
 			c.resetTCPSockets()
 			needToResendCommand = true
@@ -454,28 +458,22 @@ func (c *RTSPClient) handleResponseBytes(buffer []byte, length int) {
 		return
 	}
 
-	responseSuccess = true
-
 	if foundRequest != nil {
-		if responseSuccess {
-			var resultCode int
-			var resultString string
-			if responseCode == 200 {
-				resultCode = 0
-
-				if numBodyBytes > 0 {
-					resultString = bodyStart
-				} else {
-					resultString = publicParamsStr
-				}
+		var resultCode int
+		var resultString string
+		if resp.StatusCode == rtsp.StatusOK {
+			resultCode = 0
+
+			if len(resp.Body) > 0 {
+				resultString = string(resp.Body)
 			} else {
-				resultCode, resultString = responseCode, responseString
+				resultString = resp.Header.Get("Public")
 			}
-
-			foundRequest.Handle(c, resultCode, resultString)
 		} else {
-			c.handleRequestError(foundRequest)
+			resultCode, resultString = resp.StatusCode, resp.Reason
 		}
+
+		foundRequest.Handle(c, resultCode, resultString)
 	}
 }
 
@@ -490,64 +488,53 @@ func (c *RTSPClient) sendRequest(request *RequestRecord) int {
 		return request.cseq
 	}
 
-	protocalStr := "RTSP/1.0"
-	var contentLengthHeader string
-
-	contentStrLen := len(request.contentStr)
-	if contentStrLen > 0 {
-		contentLengthHeader = fmt.Sprintf("Content-Length: %d\r\n", contentStrLen)
-	}
+	req := rtsp.NewRequest(request.commandName, c.baseURL)
+	req.Header.Set("CSeq", strconv.Itoa(request.cseq))
+	req.Header.Set("User-Agent", c.userAgent)
 
-	cmdURL := c.baseURL
-	var extraHeaders string
 	switch request.commandName {
 	case "OPTIONS", "ANNOUNCE":
-		extraHeaders = "Content-Type: application/sdp\r\n"
+		req.Header.Set("Content-Type", "application/sdp")
 	case "DESCRIBE":
-		extraHeaders = "Accept: application/sdp\r\n"
+		req.Header.Set("Accept", "application/sdp")
 	case "SETUP":
 		subsession := request.subsession
 		streamUsingTCP := (request.boolFlags & 0x1) != 0
 		streamOutgoing := (request.boolFlags & 0x2) != 0
 
 		prefix, separator, suffix := c.constructSubSessionURL(subsession)
+		req.URL = fmt.Sprintf("%s%s%s", prefix, separator, suffix)
 
-		var transportFmt string
+		transport := &rtsp.Transport{Protocol: "RTP/AVP"}
+		if subsession.SRTPEnabled() {
+			transport.Protocol = "RTP/SAVP"
+		}
 		if subsession.ProtocolName() == "UDP" {
-			transportFmt = "Transport: RAW/RAW/UDP%s%s%s=%d-%d\r\n"
-		} else {
-			transportFmt = "Transport: RTP/AVP%s%s%s=%d-%d\r\n"
+			transport.Protocol, transport.LowerTransport = "RAW/RAW", "UDP"
 		}
-
-		cmdURL = fmt.Sprintf("%s%s%s", prefix, separator, suffix)
-
-		var modeStr string
 		if streamOutgoing {
-			modeStr = ";mode=receive"
+			transport.Mode = "RECEIVE"
 		}
 
-		var rtpNumber, rtcpNumber uint
-		var transportTypeStr, portTypeStr string
 		if streamUsingTCP {
-			transportTypeStr = "/TCP;unicast"
-			portTypeStr = ";interleaved"
-			rtpNumber = c.tcpStreamIDCount
-			c.tcpStreamIDCount += 1
-			rtcpNumber = c.tcpStreamIDCount
-			c.tcpStreamIDCount += 1
+			transport.LowerTransport = "TCP"
+			transport.Interleaved = &rtsp.PortRange{
+				Start: int(c.tcpStreamIDCount),
+				End:   int(c.tcpStreamIDCount + 1),
+			}
+			c.tcpStreamIDCount += 2
+		} else if subsession.IsMulticast() {
+			// We've joined the group of the SDP description already:
+			transport.Multicast = true
 		} else {
-			transportTypeStr = ";unicast"
-			portTypeStr = ";client_port"
-			rtpNumber = subsession.ClientPortNum()
-			rtcpNumber = rtpNumber + 1
+			rtpNumber := int(subsession.ClientPortNum())
+			transport.ClientPort = &rtsp.PortRange{Start: rtpNumber, End: rtpNumber + 1}
 		}
+		req.Header.Set("Transport", transport.String())
 
-		transportStr := fmt.Sprintf(transportFmt, transportTypeStr, modeStr,
-			portTypeStr, rtpNumber, rtcpNumber)
-
-		sessionStr := c.createSessionString(c.lastSessionID)
-
-		extraHeaders = fmt.Sprintf("%s%s", transportStr, sessionStr)
+		if c.lastSessionID != "" {
+			req.Header.Set("Session", c.lastSessionID)
+		}
 	case "PLAY", "PAUSE", "TEARDOWN", "RECORD", "SET_PARAMETER", "GET_PARAMETER":
 		if c.lastSessionID == "" {
 			fmt.Println("No RTSP session is currently in progress")
@@ -563,62 +550,45 @@ func (c *RTSPClient) sendRequest(request *RequestRecord) int {
 		} else {
 			subsession := request.subsession
 			prefix, separator, suffix := c.constructSubSessionURL(subsession)
-			cmdURL = fmt.Sprintf("%s%s%s", prefix, separator, suffix)
+			req.URL = fmt.Sprintf("%s%s%s", prefix, separator, suffix)
 
 			sessionID = subsession.SessionID()
 			originalScale = subsession.Scale()
 		}
+		if sessionID != "" {
+			req.Header.Set("Session", sessionID)
+		}
 
 		if request.commandName == "PLAY" {
-			sessionStr := c.createSessionString(sessionID)
-			scaleStr := c.createScaleString(request.scale, originalScale)
-			rangeStr := c.createRangeString(request.start, request.end,
-				request.absStartTime, request.absEndTime)
-
-			extraHeaders = fmt.Sprintf("%s%s%s", sessionStr, scaleStr, rangeStr)
-		} else {
-			extraHeaders = c.createSessionString(sessionID)
+			if request.scale != 1.0 || originalScale != 1.0 {
+				req.Header.Set("Scale", rtsp.FormatScale(float64(request.scale)))
+			}
+			if rangeHeader := c.createRange(request.start, request.end,
+				request.absStartTime, request.absEndTime); rangeHeader != nil {
+				req.Header.Set("Range", rangeHeader.String())
+			}
 		}
 	case "GET", "POST":
-		var extraHeadersFmt string
+		req.Proto = "HTTP/1.0"
+		req.Header.Set("x-sessioncookie", c.sessionCookie)
+		req.Header.Set("Pragma", "no-cache")
+		req.Header.Set("Cache-Control", "no-cache")
 		if request.commandName == "GET" {
-			extraHeadersFmt = "x-sessioncookie: %s\r\n" +
-				"Accept: application/x-rtsp-tunnelled\r\n" +
-				"Pragma: no-cache\r\n" +
-				"Cache-Control: no-cache\r\n"
+			req.Header.Set("Accept", "application/x-rtsp-tunnelled")
 		} else {
-			extraHeadersFmt = "x-sessioncookie: %s\r\n" +
-				"Content-Type: application/x-rtsp-tunnelled\r\n" +
-				"Pragma: no-cache\r\n" +
-				"Cache-Control: no-cache\r\n" +
-				"Content-Length: 32767\r\n" +
-				"Expires: Sun, 9 Jan 1972 00:00:00 GMT\r\n"
-		}
-		extraHeaders = fmt.Sprintf(extraHeadersFmt, c.sessionCookie)
+			req.Header.Set("Content-Type", "application/x-rtsp-tunnelled")
+			req.Header.Set("Content-Length", "32767")
+			req.Header.Set("Expires", "Sun, 9 Jan 1972 00:00:00 GMT")
+		}
 	default:
 	}
 
-	authenticatorStr := c.createAuthenticatorStr(request.commandName, c.baseURL)
-
-	cmdFmt := "%s %s %s\r\n" +
-		"CSeq: %d\r\n" +
-		"%s" +
-		"%s" +
-		"%s" +
-		"%s" +
-		"\r\n" +
-		"%s"
-
-	cmd := fmt.Sprintf(cmdFmt, request.commandName,
-		cmdURL,
-		protocalStr,
-		request.cseq,
-		authenticatorStr,
-		c.userAgentHeaderStr,
-		extraHeaders,
-		contentLengthHeader,
-		request.contentStr)
+	if authenticator := c.createAuthenticatorStr(request.commandName, c.baseURL); authenticator != "" {
+		req.Header.Set("Authorization", authenticator)
+	}
+	req.Body = []byte(request.contentStr)
 
+	cmd := req.String()
 	writeBytes, err := c.tcpConn.Write([]byte(cmd))
 	if err != nil {
 		fmt.Println("RTSPClient::sendRequst", err, writeBytes)
@@ -670,105 +640,66 @@ func (c *RTSPClient) constructSubSessionURL(subsession *livemedia.MediaSubsessio
 	return prefix, separator, suffix
 }
 
-func (c *RTSPClient) createSessionString(sessionID string) string {
-	var sessionStr string
-	if sessionID != "" {
-		sessionStr = fmt.Sprintf("Session: %s\r\n", sessionID)
-	}
-	return sessionStr
-}
-
-func (c *RTSPClient) createScaleString(scale, currentScale float32) string {
-	var buf string
-	if scale != 1.0 || currentScale != 1.0 {
-		buf = fmt.Sprintf("Scale: %f\r\n", scale)
-	}
-	return buf
-}
-
-func (c *RTSPClient) createRangeString(start, end float32, absStartTime, absEndTime string) string {
-	var buf string
+// createRange returns the "Range:" header of a "PLAY", or nil if it shouldn't have one.
+func (c *RTSPClient) createRange(start, end float32, absStartTime, absEndTime string) *rtsp.Range {
 	if absStartTime != "" {
 		// Create a "Range:" header that specifies 'absolute' time values:
-		if absEndTime == "" {
-			// There's no end time:
-			buf = fmt.Sprintf("Range: clock=%s-\r\n", absStartTime)
-		} else {
-			// There's both a start and an end time; include them both in the "Range:" hdr
-			buf = fmt.Sprintf("Range: clock=%s-%s\r\n", absStartTime, absEndTime)
-		}
-	} else {
-		// Create a "Range:" header that specifies relative (i.e., NPT) time values:
-		if start < 0 {
-			// We're resuming from a PAUSE; there's no "Range:" header at all
-		} else if end < 0 {
-			// There's no end time:
-			buf = fmt.Sprintf("Range: npt=%.3f-\r\n", start)
-		} else {
-			// There's both a start and an end time; include them both in the "Range:" hdr
-			buf = fmt.Sprintf("Range: npt=%.3f-%.3f\r\n", start, end)
-		}
+		return &rtsp.Range{Unit: "clock", Start: absStartTime, End: absEndTime}
 	}
-	return buf
-}
 
-func (c *RTSPClient) parseResponseCode(line string) (responseCode int, responseString string, result bool) {
-	var n int
-	var version string
-	responseString = line
-
-	for {
-		n, _ = fmt.Sscanf(line, "RTSP/%s %d", &version, &responseCode)
-		if n == 2 {
-			result = true
-			break
-		}
-
-		n, _ = fmt.Sscanf(line, "HTTP/%s %d", &version, &responseCode)
-		if n != 2 {
-			result = true
-			break
-		}
-
-		// Use everything after the RTSP/* (or HTTP/*) as the response string:
-		i := 0
-		for responseString != "" && responseString[i] != ' ' && responseString[i] != '\t' {
-			i++
-		}
-		i = 0
-		for responseString != "" && (responseString[i] == ' ' || responseString[i] == '\t') {
-			i++ // skip whitespace
-		}
-		result = false
-		break
+	// Create a "Range:" header that specifies relative (i.e., NPT) time values:
+	if start < 0 {
+		// We're resuming from a PAUSE; there's no "Range:" header at all
+		return nil
 	}
-	return responseCode, responseString, result
+	return rtsp.NewNPTRange(float64(start), float64(end))
 }
 
 func (c *RTSPClient) handleSetupResponse(subsession *livemedia.MediaSubsession,
-	sessionParamsStr, transportParamsStr string, streamUsingTCP bool) bool {
+	resp *rtsp.Response, streamUsingTCP bool) bool {
 	var success bool
 	for {
-		if sessionParamsStr == "" {
+		session, err := rtsp.ParseSession(resp.Header.Get("Session"))
+		if err != nil {
 			fmt.Println("Missing or bad \"Session:\" header ")
 			break
 		}
 
-		sessionID := sessionParamsStr
-		subsession.SetSessionID(sessionID)
-		c.lastSessionID = sessionID
+		subsession.SetSessionID(session.ID)
+		c.lastSessionID = session.ID
 
 		// Parse the "Transport:" header parameters:
-		transportParams, ok := c.parseTransportParams(transportParamsStr)
-		if !ok {
+		transport, err := rtsp.ParseTransport(resp.Header.Get("Transport"))
+		if err != nil {
 			fmt.Println("Missing or bad \"Transport:\" header ")
 			break
 		}
 
-		subsession.SetRTPChannelID(transportParams.rtpChannelID)
-		subsession.SetRTCPChannelID(transportParams.rtcpChannelID)
-		subsession.SetServerPortNum(transportParams.serverPortNum)
-		subsession.SetConnectionEndpointName(transportParams.serverAddressStr)
+		var rtpChannelID, rtcpChannelID uint = 0xFF, 0xFF
+		if transport.Interleaved != nil {
+			rtpChannelID = uint(transport.Interleaved.Start)
+			rtcpChannelID = uint(transport.Interleaved.End)
+		}
+
+		var serverPortNum uint
+		serverAddressStr := transport.Source
+		switch {
+		case transport.Multicast && transport.Destination != "" && transport.Port != nil:
+			serverAddressStr = transport.Destination
+			serverPortNum = uint(transport.Port.Start)
+		case transport.ServerPort != nil:
+			serverPortNum = uint(transport.ServerPort.Start)
+		case transport.ClientPort != nil:
+			serverPortNum = uint(transport.ClientPort.Start)
+		case transport.Interleaved == nil:
+			fmt.Println("Missing or bad \"Transport:\" header ")
+			return false
+		}
+
+		subsession.SetRTPChannelID(rtpChannelID)
+		subsession.SetRTCPChannelID(rtcpChannelID)
+		subsession.SetServerPortNum(serverPortNum)
+		subsession.SetConnectionEndpointName(serverAddressStr)
 
 		if streamUsingTCP {
 			if subsession.RTPSource != nil {
@@ -786,75 +717,25 @@ func (c *RTSPClient) handleSetupResponse(subsession *livemedia.MediaSubsession,
 	return success
 }
 
-type TransportParams struct {
-	serverPortNum    uint
-	rtpChannelID     uint
-	rtcpChannelID    uint
-	serverAddressStr string
-}
-
-func (c *RTSPClient) parseTransportParams(paramsStr string) (*TransportParams, bool) {
-	var serverPortNum, clientPortNum, multicastPortNumRTP, multicastPortNumRTCP uint
-	var foundServerPortNum, foundClientPortNum, foundChannelIDs, foundMulticastPortNum bool
-	var foundServerAddressStr, foundDestinationStr string
-	var rtpChannelID, rtcpChannelID uint = 0xFF, 0xFF
-	isMulticast := true
-	var n int
-
-	params := strings.Split(paramsStr, ";")
-	for _, param := range params {
-		if param == "unicast" {
-			isMulticast = false
-		} else if n, _ = fmt.Sscanf(param, "server_port=%d", &serverPortNum); n == 1 {
-			foundServerPortNum = true
-		} else if n, _ = fmt.Sscanf(param, "client_port=%d", &clientPortNum); n == 1 {
-			foundClientPortNum = true
-		} else if n, _ = fmt.Sscanf(param, "destination=%s", &foundDestinationStr); n == 1 {
-		} else if n, _ = fmt.Sscanf(param, "source=%s", &foundServerAddressStr); n == 1 {
-		} else if n, _ = fmt.Sscanf(param, "interleaved=%d-%d", &rtpChannelID, &rtcpChannelID); n == 2 {
-			foundChannelIDs = true
-		} else {
-			n1, _ := fmt.Sscanf(param, "port=%d-%d", &multicastPortNumRTP, &multicastPortNumRTCP)
-			n2, _ := fmt.Sscanf(param, "port=%d", &multicastPortNumRTP)
-			if n1 == 1 || n2 == 2 {
-				foundMulticastPortNum = true
-			}
+func (c *RTSPClient) handlePlayResponse(resp *rtsp.Response) bool {
+	if value := resp.Header.Get("Scale"); value != "" {
+		if _, err := rtsp.ParseScale(value); err != nil {
+			fmt.Println("Bad \"Scale:\" header ")
+			return false
 		}
 	}
-
-	transportParams := new(TransportParams)
-	transportParams.rtpChannelID = rtpChannelID
-	transportParams.rtcpChannelID = rtcpChannelID
-
-	if isMulticast && foundDestinationStr != "" && foundMulticastPortNum {
-		transportParams.serverAddressStr = foundDestinationStr
-		transportParams.serverPortNum = multicastPortNumRTP
-		return transportParams, true
+	if value := resp.Header.Get("Range"); value != "" {
+		if _, err := rtsp.ParseRange(value); err != nil {
+			fmt.Println("Bad \"Range:\" header ")
+			return false
+		}
 	}
-
-	if foundChannelIDs || foundServerPortNum || foundClientPortNum {
-		if foundClientPortNum && !foundServerPortNum {
-			transportParams.serverPortNum = clientPortNum
+	if value := resp.Header.Get("RTP-Info"); value != "" {
+		if _, err := rtsp.ParseRTPInfo(value); err != nil {
+			fmt.Println("Bad \"RTP-Info:\" header ")
+			return false
 		}
-		transportParams.serverAddressStr = foundServerAddressStr
-		return transportParams, true
 	}
-
-	return transportParams, false
-}
-
-func (c *RTSPClient) parseScaleParam(paramStr string) (scale float32, ok bool) {
-	n, _ := fmt.Sscanf(paramStr, "%f", &scale)
-	ok = (n == 1)
-	return
-}
-
-func (c *RTSPClient) parseRTPInfoParams(paramsStr string) (seqNum, timestamp int, ok bool) {
-	ok = true
-	return
-}
-
-func (c *RTSPClient) handlePlayResponse(scaleParamsStr, rangeParamsStr, rtpInfoParamsStr string) bool {
 	return true
 }
 
@@ -867,64 +748,67 @@ func (c *RTSPClient) handleGetParameterResponse(parameterName string) bool {
 	return true
 }
 
-func (c *RTSPClient) handleAuthenticationFailure(paramsStr string) bool {
+func (c *RTSPClient) handleAuthenticationFailure(challenges []string) bool {
 	// There was no "WWW-Authenticate:" header; we can't proceed.
-	if paramsStr == "" {
+	if len(challenges) == 0 {
 		return false
 	}
 
-	digest_regex := regexp.MustCompile(`Digest realm="([^"]+)", nonce="([^"]+)"`)
-	basic_regex := regexp.MustCompile(`Basic realm="([^"]+)"`)
+	// If there's more than one "WWW-Authenticate:" header, then we prefer "Digest" authentication,
+	// and the strongest algorithm of it that we support:
+	var best *auth.Challenge
+	for _, value := range challenges {
+		challenge, err := auth.ParseChallenge(value)
+		if err != nil || challenge.Scheme == "Digest" && !auth.SupportedAlgorithm(challenge.Algorithm) {
+			continue
+		}
+		if best == nil || challengeStrength(challenge) > challengeStrength(best) {
+			best = challenge
+		}
+	}
+	// bad "WWW-Authenticate:" headers
+	if best == nil {
+		return false
+	}
 
 	// Fill in "fCurrentAuthenticator" with the information from the "WWW-Authenticate:" header:
-	var matches []string
-	success := true
-	alreadyHadRealm := c.digest.Realm != ""
-
-	if matches = digest_regex.FindStringSubmatch(paramsStr); len(matches) == 3 {
-		c.digest.Realm = matches[1]
-		c.digest.Nonce = matches[2]
-	} else if matches = basic_regex.FindStringSubmatch(paramsStr); len(matches) == 2 {
-		c.digest.Realm = matches[1]
-		c.digest.RandomNonce()
+	alreadyChallenged := c.authScheme != ""
+	c.authScheme = best.Scheme
+	if best.Scheme == "Digest" {
+		c.digest.SetChallenge(best)
 	} else {
-		success = false // bad "WWW-Authenticate:" header
+		c.digest.Realm = best.Realm
+		c.digest.Nonce = ""
 	}
 
-	// We already had a 'realm', or don't have a username and/or password,
-	// so the new "WWW-Authenticate:" header information won't help us.  We remain unauthenticated.
-	if alreadyHadRealm || c.digest.Username == "" || c.digest.Password == "" {
-		success = false
+	// We had already been challenged (and the server didn't just say that our nonce was stale),
+	// or don't have a username and/or password, so the new "WWW-Authenticate:" header
+	// information won't help us.  We remain unauthenticated.
+	if alreadyChallenged && !best.Stale || c.digest.Username == "" || c.digest.Password == "" {
+		return false
 	}
-
-	return success
+	return true
 }
 
-func (c *RTSPClient) handleIncomingRequest(reqStr string, length int) {
-	requestString, parseSucceeded := livemedia.ParseRTSPRequestString(reqStr, length)
-	if parseSucceeded {
-		fmt.Printf("Received incoming RTSP request: %s\n", reqStr)
-
-		buffer := fmt.Sprintf("RTSP/1.0 405 Method Not Allowed\r\nCSeq: %s\r\n\r\n", requestString.Cseq)
-		c.tcpConn.Write([]byte(buffer))
+// challengeStrength ranks the challenges of a server: "Digest" with SHA-256, then
+// "Digest" with MD5, then "Basic".
+func challengeStrength(challenge *auth.Challenge) int {
+	switch {
+	case challenge.Scheme == "Basic":
+		return 0
+	case strings.EqualFold(challenge.Algorithm, auth.AlgorithmSHA256):
+		return 2
+	default:
+		return 1
 	}
 }
 
-func (c *RTSPClient) checkForHeader(line, headerName string, headerNameLength int) (string, bool) {
-	if !strings.HasPrefix(line, headerName) {
-		return "", false
-	}
-
-	index := headerNameLength
-	for _, ch := range line[headerNameLength:] {
-		if ch == ' ' || ch == '\t' {
-			index += 1
-		} else {
-			break
-		}
-	}
+func (c *RTSPClient) handleIncomingRequest(req *rtsp.Request) {
+	fmt.Printf("Received incoming RTSP request: %s\n", req)
 
-	return line[index:], true
+	resp := rtsp.NewResponse(rtsp.StatusMethodNotAllowed)
+	resp.Header.Set("CSeq", req.Header.Get("CSeq"))
+	c.tcpConn.Write([]byte(resp.String()))
 }
 
 type RequestRecord struct {
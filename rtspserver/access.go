@@ -0,0 +1,123 @@
+package rtspserver
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+)
+
+// AccessPolicy decides which clients may use which streams. It's checked before a client
+// is asked to authenticate, and a client that it turns down is answered with "403 Forbidden".
+type AccessPolicy interface {
+	// Allow reports whether the client at addr may use the stream streamName.
+	Allow(addr net.IP, streamName string) bool
+}
+
+// CIDRPolicy allows the clients whose addresses are in the allow list (or every client, if the
+// list is empty), unless their addresses are also in the deny list.
+type CIDRPolicy struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// NewCIDRPolicy creates a policy from lists of CIDR blocks, e.g. "10.0.0.0/8". A single
+// address, e.g. "192.168.1.10", stands for itself.
+func NewCIDRPolicy(allow, deny []string) (*CIDRPolicy, error) {
+	var p CIDRPolicy
+	var err error
+	if p.allow, err = parseCIDRs(allow); err != nil {
+		return nil, err
+	}
+	if p.deny, err = parseCIDRs(deny); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func parseCIDRs(blocks []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, block := range blocks {
+		if !strings.Contains(block, "/") {
+			ip := net.ParseIP(block)
+			if ip == nil {
+				return nil, fmt.Errorf("bad address %q", block)
+			}
+			if ip.To4() != nil {
+				block += "/32"
+			} else {
+				block += "/128"
+			}
+		}
+
+		_, ipNet, err := net.ParseCIDR(block)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allow implements AccessPolicy.
+func (p *CIDRPolicy) Allow(addr net.IP, streamName string) bool {
+	if containsIP(p.deny, addr) {
+		return false
+	}
+	return len(p.allow) == 0 || containsIP(p.allow, addr)
+}
+
+// StreamRule applies a policy to the streams whose names match a glob pattern
+// (see path.Match), e.g. "private/*".
+type StreamRule struct {
+	Pattern string
+	Policy  AccessPolicy
+}
+
+// StreamPolicy applies the first of its rules that matches the name of a stream.
+// The streams that no rule matches are allowed.
+type StreamPolicy struct {
+	Rules []StreamRule
+}
+
+// NewStreamPolicy creates a policy from rules, and checks their patterns.
+func NewStreamPolicy(rules ...StreamRule) (*StreamPolicy, error) {
+	for _, rule := range rules {
+		if _, err := path.Match(rule.Pattern, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %v", rule.Pattern, err)
+		}
+	}
+	return &StreamPolicy{Rules: rules}, nil
+}
+
+// Allow implements AccessPolicy.
+func (p *StreamPolicy) Allow(addr net.IP, streamName string) bool {
+	for _, rule := range p.Rules {
+		if matched, _ := path.Match(rule.Pattern, streamName); matched {
+			return rule.Policy.Allow(addr, streamName)
+		}
+	}
+	return true
+}
+
+// accessAllowed checks the server's access policy, if it has one.
+func (s *RTSPServer) accessAllowed(clientSocket net.Conn, streamName string) bool {
+	if s.config.AccessPolicy == nil {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(clientSocket.RemoteAddr().String())
+	if err != nil {
+		return false
+	}
+	return s.config.AccessPolicy.Allow(net.ParseIP(host), streamName)
+}
@@ -0,0 +1,49 @@
+package rtspserver
+
+import (
+	"net"
+	"testing"
+)
+
+func TestAccessPolicy(t *testing.T) {
+	lan, err := NewCIDRPolicy([]string{"192.168.0.0/16", "::1"}, []string{"192.168.1.10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	localhost, _ := NewCIDRPolicy([]string{"127.0.0.0/8"}, nil)
+	policy, err := NewStreamPolicy(
+		StreamRule{Pattern: "private/*", Policy: localhost},
+		StreamRule{Pattern: "*", Policy: lan},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		addr       string
+		streamName string
+		allowed    bool
+	}{
+		{"192.168.1.1", "test.264", true},
+		{"::1", "test.264", true},
+		{"192.168.1.10", "test.264", false},
+		{"10.0.0.1", "test.264", false},
+		{"127.0.0.1", "private/cam1", true},
+		{"192.168.1.1", "private/cam1", false},
+		{"10.0.0.1", "public/cam1", true},
+	}
+	for _, c := range cases {
+		if policy.Allow(net.ParseIP(c.addr), c.streamName) == c.allowed {
+			t.Log("success")
+		} else {
+			t.Errorf("failed: %s, %s", c.addr, c.streamName)
+		}
+	}
+
+	if _, err := NewCIDRPolicy([]string{"192.168.0.0/33"}, nil); err == nil {
+		t.Error("failed to refuse a bad CIDR block")
+	}
+	if _, err := NewStreamPolicy(StreamRule{Pattern: "[", Policy: lan}); err == nil {
+		t.Error("failed to refuse a bad pattern")
+	}
+}
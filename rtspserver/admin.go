@@ -0,0 +1,161 @@
+package rtspserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/djwackey/dorsvr/livemedia"
+	"github.com/djwackey/gitea/log"
+)
+
+// StreamInfo describes a stream in the admin API.
+type StreamInfo struct {
+	Name string `json:"name"`
+	// "file" for a file in the media root, "live" for a session that was added in code,
+	// or that a client is publishing
+	Source  string   `json:"source"`
+	Tracks  []string `json:"tracks"`
+	Clients int      `json:"clients"`
+}
+
+// SessionInfo describes a client session in the admin API.
+type SessionInfo struct {
+	ID         string    `json:"id"`
+	RemoteAddr string    `json:"remote_addr"`
+	Transport  string    `json:"transport"`
+	Recording  bool      `json:"recording"`
+	StreamName string    `json:"stream_name"`
+	StartTime  time.Time `json:"start_time"`
+	// the RTP payload octets that have been sent to the client, since it started playing
+	BytesSent uint `json:"bytes_sent"`
+}
+
+// adminHandler serves the admin API. "GET /api/streams" lists the streams, "GET /api/sessions"
+// lists the client sessions, and "GET" or "DELETE" of "/api/sessions/<id>" describes or
+// terminates a client session.
+func (s *RTSPServer) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/streams", s.handleAdminStreams)
+	mux.HandleFunc("/api/sessions", s.handleAdminSessions)
+	mux.HandleFunc("/api/sessions/", s.handleAdminSession)
+	return mux
+}
+
+func (s *RTSPServer) startAdmin() {
+	s.admin = &http.Server{Addr: s.config.AdminAddr, Handler: s.adminHandler()}
+	go s.adminServe()
+}
+
+func (s *RTSPServer) adminServe() {
+	if err := s.admin.ListenAndServe(); err != http.ErrServerClosed {
+		log.Error(0, "the admin API failed: %v", err)
+	}
+}
+
+func (s *RTSPServer) handleAdminStreams(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, s.Streams())
+}
+
+func (s *RTSPServer) handleAdminSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, s.ClientSessions())
+}
+
+func (s *RTSPServer) handleAdminSession(w http.ResponseWriter, r *http.Request) {
+	sessionID := strings.TrimPrefix(r.URL.Path, "/api/sessions/")
+	clientSession, existed := s.getClientSession(sessionID)
+	if !existed {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, clientSession.info())
+	case "DELETE":
+		s.TerminateClientSession(sessionID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warn("failed to write the admin response: %v", err)
+	}
+}
+
+// Streams returns the streams that clients can play: the sessions that have been added
+// in code or are being published, and the files that clients are playing.
+func (s *RTSPServer) Streams() []*StreamInfo {
+	clients := make(map[string]int)
+	for _, clientSession := range s.allClientSessions() {
+		if streamName, _ := clientSession.streams(); streamName != "" {
+			clients[streamName]++
+		}
+	}
+
+	streams := make([]*StreamInfo, 0)
+	addStreams := func(source string, sessions []*livemedia.ServerMediaSession) {
+		for _, sms := range sessions {
+			stream := &StreamInfo{
+				Name:    sms.StreamName(),
+				Source:  source,
+				Tracks:  make([]string, 0, sms.SubsessionCounter),
+				Clients: clients[sms.StreamName()],
+			}
+			for i := 0; i < sms.SubsessionCounter; i++ {
+				stream.Tracks = append(stream.Tracks, sms.Subsessions[i].TrackID())
+			}
+			streams = append(streams, stream)
+		}
+	}
+	addStreams("live", s.ServerMediaSessions())
+	addStreams("file", s.allFileSessions())
+
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].Name < streams[j].Name
+	})
+	return streams
+}
+
+// ClientSessions returns the client sessions that are open, oldest first.
+func (s *RTSPServer) ClientSessions() []*SessionInfo {
+	sessions := make([]*SessionInfo, 0)
+	for _, clientSession := range s.allClientSessions() {
+		sessions = append(sessions, clientSession.info())
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].StartTime.Before(sessions[j].StartTime)
+	})
+	return sessions
+}
+
+// TerminateClientSession ends a client session: its streams are stopped (the client is sent
+// a RTCP "BYE"), and its connection is closed. It returns false if there is no such session.
+// The session is destroyed under its mutex, so it may be called while the connection is busy
+// with a request of the session, which then finds it gone.
+func (s *RTSPServer) TerminateClientSession(sessionID string) bool {
+	clientSession, existed := s.getClientSession(sessionID)
+	if !existed {
+		return false
+	}
+
+	log.Info("terminating the client session %s", sessionID)
+	clientSession.destroy()
+	clientSession.connection.socket.Close()
+	return true
+}
@@ -0,0 +1,204 @@
+package rtspserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/djwackey/dorsvr/auth"
+)
+
+// Config holds the settings of a RTSPServer. The zero value of a field means its default,
+// except where noted otherwise.
+type Config struct {
+	// the address that ListenAndServe binds, e.g. "0.0.0.0:8554"
+	ListenAddr string `json:"listen_addr"`
+	// the address that ListenAndServe binds for RTSPS (RTSP over TLS), e.g. "0.0.0.0:322",
+	// and the files of its certificate and key; "" turns RTSPS off
+	TLSListenAddr string `json:"tls_listen_addr"`
+	TLSCertFile   string `json:"tls_cert_file"`
+	TLSKeyFile    string `json:"tls_key_file"`
+	// whether the files of the media root are streamed with SRTP ("RTP/SAVP"), whose keys are
+	// sent in their SDP descriptions; the descriptions should then only be sent over RTSPS
+	SRTP bool `json:"srtp"`
+	// how often the sessions that are streamed to multicast groups are announced with SAP
+	// (RFC 2974), in seconds; a negative interval turns the announcements off
+	SAPInterval int `json:"sap_interval"`
+	// the ports that ListenAndServe tries, in order, for RTSP-over-HTTP tunneling;
+	// none turns tunneling off
+	HTTPTunnelPorts []int `json:"http_tunnel_ports"`
+	// the address that serves the metrics (at /metrics) and pprof; "" turns it off. Its
+	// profiles show the server's internals, so keep it local.
+	MonitorAddr string `json:"monitor_addr"`
+	// the address of the admin API, which lists the streams and the client sessions, and
+	// terminates sessions; "" turns it off. It has no authentication, so keep it local.
+	AdminAddr string `json:"admin_addr"`
+	// how long a client session is kept without hearing from its client, in seconds
+	SessionTimeout int `json:"session_timeout"`
+	// the UDP ports that streams are sent from
+	RTPPortStart uint `json:"rtp_port_start"`
+	RTPPortEnd   uint `json:"rtp_port_end"`
+	// the directory in which the files that clients ask for are looked up; if it can't be used,
+	// no files are served
+	MediaRoot string `json:"media_root"`
+	// the realm and the users (by name, with their passwords) of digest authentication;
+	// no users means that clients don't need to authenticate, and "" means the default realm
+	Realm string            `json:"realm"`
+	Users map[string]string `json:"users"`
+	// a htdigest file of users, which takes the place of Users; it's read again when it changes
+	HTDigestFile string `json:"htdigest_file"`
+	// a htpasswd file of users (bcrypt, "$apr1$" or "{SHA}" hashes), which takes the place of
+	// Users; it's read again when it changes. Its users authenticate with "Basic" only, so
+	// BasicAuth has to allow it.
+	HTPasswdFile string `json:"htpasswd_file"`
+	// whether clients may authenticate with "Basic", which sends their passwords: BasicAuthOff
+	// (""), BasicAuthTLS, or BasicAuthOn. Digest authentication is offered unless the users
+	// come from a htpasswd file.
+	BasicAuth string `json:"basic_auth"`
+	// whether the RFC 2069 digests of older clients, which have no "qop", are accepted. They
+	// have no nonce count, so a captured one can be replayed until its nonce expires.
+	LegacyDigestAuth bool `json:"legacy_digest_auth"`
+	// the secret key of signed URLs (see auth.URLSigner), which give access to a stream without
+	// a password; "" turns them off. With a key, a client needs a signed URL, or the credentials
+	// of a user, if there are users.
+	URLSigningKey string `json:"url_signing_key"`
+	// the roles (lists of rules that grant "play" and "publish" on streams) and the roles of the
+	// users; with none, a user may play and publish every stream
+	Roles     map[string][]auth.Rule `json:"roles"`
+	UserRoles map[string][]string    `json:"user_roles"`
+	// a store of users that was built in code; it takes the place of the settings above
+	AuthStore auth.Store `json:"-"`
+	// what the users may do, built in code; it takes the place of Roles and UserRoles
+	Authorizer auth.Authorizer `json:"-"`
+	// which clients may use which streams; nil means every client may use every stream
+	AccessPolicy AccessPolicy `json:"-"`
+	// the application's hooks; nil means DefaultHooks
+	Hooks Hooks `json:"-"`
+}
+
+// The values of Config.BasicAuth.
+const (
+	BasicAuthOff = "off"
+	// Basic is accepted on TLS connections only
+	BasicAuthTLS = "tls"
+	// Basic is accepted on every connection, so passwords may be sent in the clear
+	BasicAuthOn = "on"
+)
+
+// DefaultConfig returns the settings that the server has when New is given a nil config.
+// A config file is read over these.
+func DefaultConfig() *Config {
+	return &Config{
+		ListenAddr:      "0.0.0.0:8554",
+		HTTPTunnelPorts: []int{80, 8000, 8080},
+		MonitorAddr:     "127.0.0.1:6060",
+		SessionTimeout:  65,
+		SAPInterval:     30,
+		RTPPortStart:    6970,
+		RTPPortEnd:      65535,
+	}
+}
+
+// LoadConfig reads a JSON config file. The settings that the file leaves out keep their defaults.
+func LoadConfig(fileName string) (*Config, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	config := DefaultConfig()
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("failed to read the config file %s: %v", fileName, err)
+	}
+	switch config.BasicAuth {
+	case "", BasicAuthOff, BasicAuthTLS, BasicAuthOn:
+	default:
+		return nil, fmt.Errorf("%s: unknown basic_auth mode %q", fileName, config.BasicAuth)
+	}
+	return config, nil
+}
+
+// withDefaults returns a copy of the config, with the defaults filled in.
+func (c *Config) withDefaults() *Config {
+	defaults := DefaultConfig()
+	if c == nil {
+		c = defaults
+	}
+
+	config := *c
+	if config.ListenAddr == "" {
+		config.ListenAddr = defaults.ListenAddr
+	}
+	if config.SessionTimeout <= 0 {
+		config.SessionTimeout = defaults.SessionTimeout
+	}
+	if config.SAPInterval == 0 {
+		config.SAPInterval = defaults.SAPInterval
+	}
+	if config.RTPPortStart == 0 {
+		config.RTPPortStart = defaults.RTPPortStart
+	}
+	if config.RTPPortEnd == 0 {
+		config.RTPPortEnd = defaults.RTPPortEnd
+	}
+	if config.BasicAuth == "" {
+		config.BasicAuth = BasicAuthOff
+	}
+	if config.Hooks == nil {
+		config.Hooks = DefaultHooks{}
+	}
+	return &config
+}
+
+// authStore returns the store that the users of the config are checked against,
+// or nil if clients don't need to authenticate.
+func (c *Config) authStore() (auth.Store, error) {
+	if c.AuthStore != nil {
+		return c.AuthStore, nil
+	}
+	if c.HTDigestFile != "" {
+		return auth.NewHTDigestFile(c.HTDigestFile, c.Realm)
+	}
+	if c.HTPasswdFile != "" {
+		return auth.NewHTPasswdFile(c.HTPasswdFile, c.Realm)
+	}
+	if len(c.Users) == 0 {
+		return nil, nil
+	}
+
+	authDatabase := auth.NewAuthDatabase(c.Realm)
+	for username, password := range c.Users {
+		authDatabase.InsertUserRecord(username, password)
+	}
+	return authDatabase, nil
+}
+
+// authorizer returns what decides which users may do what with which streams, or nil if
+// every user may do everything.
+func (c *Config) authorizer() (auth.Authorizer, error) {
+	if c.Authorizer != nil {
+		return c.Authorizer, nil
+	}
+	if len(c.Roles) == 0 && len(c.UserRoles) == 0 {
+		return nil, nil
+	}
+
+	permissions := auth.NewPermissions()
+	for name, rules := range c.Roles {
+		if err := permissions.AddRole(name, rules...); err != nil {
+			return nil, err
+		}
+	}
+	for username, roles := range c.UserRoles {
+		for _, role := range roles {
+			if _, existed := c.Roles[role]; !existed {
+				return nil, fmt.Errorf("user %s: unknown role %q", username, role)
+			}
+		}
+		permissions.AssignRoles(username, roles...)
+	}
+	return permissions, nil
+}
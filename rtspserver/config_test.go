@@ -0,0 +1,56 @@
+package rtspserver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/djwackey/dorsvr/auth"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dorsvr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "dorsvr.json")
+	content := `{"listen_addr": "127.0.0.1:8554", "monitor_addr": "", "users": {"alice": "secret"}}`
+	if err := ioutil.WriteFile(fileName, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The settings that the file leaves out keep their defaults:
+	server := New(config)
+	if config.ListenAddr == "127.0.0.1:8554" && config.MonitorAddr == "" && config.SessionTimeout == 65 &&
+		len(config.HTTPTunnelPorts) == 3 && server.authStore.LookupHA1("alice", auth.AlgorithmMD5) == auth.HA1("alice", server.authStore.Realm(), "secret") {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %+v", config)
+	}
+
+	// A config that was built in code gets the same defaults, and a negative SAP interval
+	// turns SAP off:
+	if New(&Config{}).config.SAPInterval == 30 && New(&Config{SAPInterval: -1}).config.SAPInterval < 0 {
+		t.Log("success")
+	} else {
+		t.Error("failed: the SAP interval has no default")
+	}
+
+	// Misspelled settings aren't ignored:
+	if err := ioutil.WriteFile(fileName, []byte(`{"listen": ":8554"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(fileName); err != nil {
+		t.Log("success")
+	} else {
+		t.Error("failed: an unknown setting was accepted")
+	}
+}
@@ -1,19 +1,32 @@
 package rtspserver
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"net"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/djwackey/dorsvr/auth"
 	gs "github.com/djwackey/dorsvr/groupsock"
 	"github.com/djwackey/dorsvr/livemedia"
+	"github.com/djwackey/dorsvr/rtsp"
 	"github.com/djwackey/gitea/log"
 )
 
 const rtspBufferSize = 10000
 
+// the largest RTSP message (headers and body) that we accept from a client
+const maxRequestSize = 1 << 20
+
 type RTSPClientConnection struct {
 	socket         net.Conn
 	localPort      string
@@ -23,22 +36,48 @@ type RTSPClientConnection struct {
 	currentCSeq    string
 	sessionIDStr   string
 	responseBuffer string
+	responseStatus int
 	clientSession  *RTSPClientSession
 	server         *RTSPServer
-	digest         *auth.Digest
+	// the stream that this connection has ANNOUNCEd, and is going to RECORD
+	announcedSession *livemedia.ServerMediaSession
+	// the tracks that receive RTP/RTCP "interleaved" on this connection, by channel id
+	recordChannels map[uint]*livemedia.RecordServerMediaSubsession
+	// the streams whose signed URLs this connection has shown, until their tokens expire;
+	// the URLs of the SETUPs that follow a DESCRIBE don't have the token
+	tokenStreams map[string]time.Time
+	// the request whose credentials were accepted last, and their user: a "SETUP" may be
+	// checked against more than one stream name, but a digest response is good only once
+	authenticatedReq  *rtsp.Request
+	authenticatedUser string
+	// the bytes that have been read, but don't make up a complete message yet
+	requestBuffer []byte
+	// serializes the handling of the connection's requests, which may also come through
+	// the POST connection of a HTTP tunnel, and the teardown
+	requestMutex sync.Mutex
+	// RTSP-over-HTTP tunneling: the "x-sessioncookie" of a GET connection, and the links
+	// between the GET connection (which carries our responses and the RTP/RTCP data) and
+	// the POST connection (which carries the client's base64-encoded requests)
+	sessionCookie string
+	tunnelGET     *RTSPClientConnection
+	tunnelPOST    *RTSPClientConnection
+	base64Buffer  []byte
 }
 
 func newRTSPClientConnection(server *RTSPServer, socket net.Conn) *RTSPClientConnection {
-	localAddr := strings.Split(socket.LocalAddr().String(), ":")
-	remoteAddr := strings.Split(socket.RemoteAddr().String(), ":")
+	// (IPv6 addresses come as "[::1]:8554".)
+	localAddr, localPort, _ := net.SplitHostPort(socket.LocalAddr().String())
+	remoteAddr, remotePort, _ := net.SplitHostPort(socket.RemoteAddr().String())
 	return &RTSPClientConnection{
 		server:     server,
 		socket:     socket,
-		localAddr:  localAddr[0],
-		localPort:  localAddr[1],
-		remoteAddr: remoteAddr[0],
-		remotePort: remoteAddr[1],
-		digest:     auth.NewDigest(),
+		localAddr:  localAddr,
+		localPort:  localPort,
+		remoteAddr: remoteAddr,
+		remotePort: remotePort,
+
+		recordChannels: make(map[uint]*livemedia.RecordServerMediaSubsession),
+		tokenStreams:   make(map[string]time.Time),
 	}
 }
 
@@ -63,9 +102,7 @@ func (c *RTSPClientConnection) incomingRequestHandler() {
 			}
 		default:
 			log.Info("default: %v", err)
-			if err.Error() == "EOF" {
-				isclose = true
-			}
+			isclose = true
 		}
 
 		if isclose {
@@ -73,10 +110,20 @@ func (c *RTSPClientConnection) incomingRequestHandler() {
 		}
 	}
 
-	log.Info("disconnected the connection[%s:%s].", c.remoteAddr, c.remotePort)
+	log.Info("disconnected the connection[%s].", net.JoinHostPort(c.remoteAddr, c.remotePort))
+	// The GET and POST connections of a HTTP tunnel are torn down together:
+	if peer := c.server.unpairHTTPConnection(c); peer != nil {
+		peer.socket.Close()
+	}
+
+	c.requestMutex.Lock()
+	defer c.requestMutex.Unlock()
 	if c.clientSession != nil {
 		c.clientSession.destroy()
 	}
+	if c.announcedSession != nil {
+		c.server.closeLiveSession(c.announcedSession)
+	}
 }
 
 func (c *RTSPClientConnection) handleRequestBytes(buffer []byte, length int) error {
@@ -84,73 +131,146 @@ func (c *RTSPClientConnection) handleRequestBytes(buffer []byte, length int) err
 		return errors.New("EOF")
 	}
 
-	reqStr := string(buffer[:length])
+	if c.tunnelGET != nil {
+		// We're the POST connection of a HTTP tunnel:
+		return c.handleTunneledBytes(buffer[:length])
+	}
+
+	c.requestMutex.Lock()
+	defer c.requestMutex.Unlock()
 
 	log.Info("Received %d new bytes of request data.", length)
 
-	var existed bool
-	//var clientSession *RTSPClientSession
-	requestString, parseSucceeded := livemedia.ParseRTSPRequestString(reqStr, length)
-	if parseSucceeded {
-		log.Info("Received a complete %s request:\n%s", requestString.CmdName, reqStr)
-
-		c.currentCSeq = requestString.Cseq
-		c.sessionIDStr = requestString.SessionIDStr
-		switch requestString.CmdName {
-		case "OPTIONS":
-			c.handleCommandOptions()
-		case "DESCRIBE":
-			c.handleCommandDescribe(requestString.UrlPreSuffix, requestString.UrlSuffix, reqStr)
-		case "SETUP":
-			{
-				if c.sessionIDStr == "" {
-					for {
-						c.sessionIDStr = fmt.Sprintf("%08X", gs.OurRandom32())
-						if _, existed = c.server.getClientSession(c.sessionIDStr); !existed {
-							break
-						}
-					}
-					c.clientSession = c.newClientSession(c.sessionIDStr)
-					c.server.addClientSession(c.sessionIDStr, c.clientSession)
-				} else {
-					if c.clientSession, existed = c.server.getClientSession(c.sessionIDStr); !existed {
-						c.handleCommandSessionNotFound()
-					}
-				}
+	// A request may arrive in pieces, and several requests (or RTP/RTCP packets,
+	// RFC 2326, section 10.12) may arrive at once, so collect the bytes, and handle
+	// every complete message in them, in order:
+	c.requestBuffer = append(c.requestBuffer, buffer[:length]...)
 
-				if c.clientSession != nil {
-					c.clientSession.handleCommandSetup(requestString.UrlPreSuffix, requestString.UrlSuffix, reqStr)
-				}
-			}
-		case "PLAY", "PAUSE", "TEARDOWN", "GET_PARAMETER", "SET_PARAMETER":
-			{
-				if c.clientSession, existed = c.server.getClientSession(c.sessionIDStr); existed {
-					c.clientSession.handleCommandWithinSession(requestString.CmdName,
-						requestString.UrlPreSuffix, requestString.UrlSuffix, reqStr)
-				} else {
-					c.handleCommandSessionNotFound()
-				}
-			}
-		case "RECORD":
-		default:
-			c.handleCommandNotSupported()
+	var err error
+	consumed := 0
+	for err == nil {
+		data := c.requestBuffer[consumed:]
+
+		// Skip any line breaks between messages:
+		skipped := 0
+		for skipped < len(data) && (data[skipped] == '\r' || data[skipped] == '\n') {
+			skipped++
 		}
-	} else {
-		requestString, parseSucceeded := livemedia.ParseHTTPRequestString(reqStr, length)
-		if parseSucceeded {
-			switch requestString.CmdName {
-			case "GET":
-				c.handleHTTPCommandTunnelingGET(requestString.SessionCookie)
-			case "POST":
-				extraData := ""
-				extraDataSize := uint(0)
-				c.handleHTTPCommandTunnelingPOST(requestString.SessionCookie, extraData, extraDataSize)
-			default:
-				c.handleHTTPCommandNotSupported()
+		consumed += skipped
+		data = data[skipped:]
+
+		messageSize, complete, sizeErr := c.nextMessageSize(data)
+		if sizeErr != nil {
+			// We can't tell where the next message starts, so this is the last one:
+			c.handleCommandBad()
+			c.sendResponse()
+			return sizeErr
+		}
+		if !complete {
+			if messageSize > maxRequestSize {
+				return errors.New("the request is too large")
 			}
+			break
+		}
+
+		message := data[:messageSize]
+		consumed += messageSize
+
+		if message[0] == '$' {
+			c.handleInterleavedPacket(uint(message[1]), message[4:])
 		} else {
-			c.handleCommandBad()
+			err = c.handleRequest(string(message))
+		}
+	}
+
+	// Keep the start of the next message, until the rest of it arrives:
+	c.requestBuffer = append([]byte(nil), c.requestBuffer[consumed:]...)
+	return err
+}
+
+// nextMessageSize returns the size of the RTSP (or HTTP) message, or of the
+// "$"-framed RTP or RTCP packet, at the start of data, and whether all of it is there.
+// The size of a message whose headers haven't all arrived yet is at least len(data).
+// A message whose "Content-Length:" is bad is an error, since the messages that follow it
+// can't be found.
+func (c *RTSPClientConnection) nextMessageSize(data []byte) (int, bool, error) {
+	if len(data) == 0 {
+		return 0, false, nil
+	}
+
+	if data[0] == '$' {
+		if len(data) < 4 {
+			return 4, false, nil
+		}
+		packetSize := 4 + int(binary.BigEndian.Uint16(data[2:4]))
+		return packetSize, len(data) >= packetSize, nil
+	}
+
+	headerEnd := bytes.Index(data, []byte("\r\n\r\n"))
+	if headerEnd == -1 {
+		if len(data) > rtspBufferSize {
+			// nobody sends headers this long
+			return maxRequestSize + 1, false, nil
 		}
+		return len(data), false, nil
+	}
+
+	// The message may have a body:
+	headerSize := headerEnd + 4
+	req, err := rtsp.ReadRequestHeader(bufio.NewReader(bytes.NewReader(data[:headerSize])))
+	if err != nil {
+		// handleRequest will reject it
+		return headerSize, true, nil
+	}
+
+	messageSize := headerSize
+	if isHTTPRequest(req) {
+		if req.Method == "POST" {
+			// The body of a HTTP tunneling POST never ends; whatever we have of it goes to the tunnel:
+			messageSize = len(data)
+		}
+	} else {
+		contentLength, err := req.ContentLength()
+		if err != nil {
+			return headerSize, true, err
+		}
+		messageSize += contentLength
+	}
+	return messageSize, len(data) >= messageSize, nil
+}
+
+// isHTTPRequest reports whether a request is a HTTP one, rather than RTSP.
+func isHTTPRequest(req *rtsp.Request) bool {
+	return strings.HasPrefix(req.Proto, "HTTP/")
+}
+
+// handleRequest handles one complete RTSP (or HTTP) request, and sends our response to it.
+func (c *RTSPClientConnection) handleRequest(reqStr string) error {
+	// The body of a HTTP tunneling POST isn't a part of the request, so read just the headers first:
+	req, err := rtsp.ReadRequestHeader(bufio.NewReader(strings.NewReader(reqStr)))
+	if err == nil && !isHTTPRequest(req) {
+		req, err = rtsp.ParseRequest([]byte(reqStr))
+	}
+
+	if err != nil {
+		log.Warn("failed to parse the request: %v", err)
+		c.handleCommandBad()
+	} else if isHTTPRequest(req) {
+		c.handleHTTPRequest(req, reqStr)
+	} else {
+		c.handleRTSPRequest(req, reqStr)
+		if c.responseBuffer != "" {
+			c.server.metrics.noteRequest(req.Method, c.responseStatus)
+		}
+	}
+
+	return c.sendResponse()
+}
+
+// sendResponse sends the response that has been set, if any.
+func (c *RTSPClientConnection) sendResponse() error {
+	if c.responseBuffer == "" {
+		return nil
 	}
 
 	sendBytes, err := c.socket.Write([]byte(c.responseBuffer))
@@ -159,52 +279,160 @@ func (c *RTSPClientConnection) handleRequestBytes(buffer []byte, length int) err
 		return err
 	}
 	log.Info("send response:\n%s", c.responseBuffer)
+	c.responseBuffer = ""
 	return nil
 }
 
+func (c *RTSPClientConnection) handleRTSPRequest(req *rtsp.Request, reqStr string) {
+	log.Info("Received a complete %s request:\n%s", req.Method, reqStr)
+
+	c.currentCSeq = req.Header.Get("CSeq")
+	c.sessionIDStr = ""
+	if session, err := rtsp.ParseSession(req.Header.Get("Session")); err == nil {
+		c.sessionIDStr = session.ID
+	}
+
+	urlPreSuffix, urlSuffix := rtsp.SplitURL(req.URL)
+
+	var existed bool
+	switch req.Method {
+	case "OPTIONS":
+		c.handleCommandOptions()
+	case "DESCRIBE":
+		c.handleCommandDescribe(urlPreSuffix, urlSuffix, req)
+	case "ANNOUNCE":
+		c.handleCommandAnnounce(urlPreSuffix, urlSuffix, req)
+	case "SETUP":
+		{
+			if c.sessionIDStr == "" {
+				for {
+					c.sessionIDStr = fmt.Sprintf("%08X", gs.OurRandom32())
+					if _, existed = c.server.getClientSession(c.sessionIDStr); !existed {
+						break
+					}
+				}
+				c.clientSession = c.newClientSession(c.sessionIDStr)
+				c.server.addClientSession(c.sessionIDStr, c.clientSession)
+			} else {
+				if c.clientSession, existed = c.server.getClientSession(c.sessionIDStr); !existed {
+					c.handleCommandSessionNotFound()
+				}
+			}
+
+			if c.clientSession != nil {
+				c.clientSession.handleCommandSetup(urlPreSuffix, urlSuffix, req)
+			}
+		}
+	case "PLAY", "PAUSE", "RECORD", "TEARDOWN", "GET_PARAMETER", "SET_PARAMETER":
+		{
+			if c.clientSession, existed = c.server.getClientSession(c.sessionIDStr); existed {
+				c.clientSession.handleCommandWithinSession(req.Method, urlPreSuffix, urlSuffix, req)
+			} else {
+				c.handleCommandSessionNotFound()
+			}
+		}
+	default:
+		c.handleCommandNotSupported()
+	}
+}
+
+func (c *RTSPClientConnection) handleHTTPRequest(req *rtsp.Request, reqStr string) {
+	sessionCookie := req.Header.Get("x-sessioncookie")
+	switch req.Method {
+	case "GET":
+		if sessionCookie == "" {
+			_, urlSuffix := rtsp.SplitURL(req.URL)
+			c.handleHTTPCommandStreamingGET(urlSuffix, req)
+		} else {
+			c.handleHTTPCommandTunnelingGET(sessionCookie)
+		}
+	case "POST":
+		// Any data after the request's headers is the start of the tunneled requests:
+		var extraData string
+		if i := strings.Index(reqStr, "\r\n\r\n"); i != -1 {
+			extraData = reqStr[i+4:]
+		}
+		c.handleHTTPCommandTunnelingPOST(sessionCookie, extraData, uint(len(extraData)))
+	default:
+		c.handleHTTPCommandNotSupported()
+	}
+}
+
+// handleTunneledBytes decodes the base64 data that arrives on the POST connection of a
+// HTTP tunnel, and handles the requests in it as if they had arrived on the GET connection.
+func (c *RTSPClientConnection) handleTunneledBytes(data []byte) error {
+	// The base64 text may be broken up anywhere, and may contain line breaks:
+	for _, b := range data {
+		if b != '\r' && b != '\n' && b != ' ' && b != '\t' {
+			c.base64Buffer = append(c.base64Buffer, b)
+		}
+	}
+
+	var decoded []byte
+	for {
+		// Decode up to the end of the buffer, or of the first padded quantum, whichever is first:
+		size := len(c.base64Buffer) / 4 * 4
+		if i := bytes.IndexByte(c.base64Buffer[:size], '='); i != -1 {
+			size = i/4*4 + 4
+		}
+		if size == 0 {
+			break
+		}
+
+		buffer := make([]byte, base64.StdEncoding.DecodedLen(size))
+		n, err := base64.StdEncoding.Decode(buffer, c.base64Buffer[:size])
+		if err != nil {
+			return err
+		}
+		decoded = append(decoded, buffer[:n]...)
+		c.base64Buffer = c.base64Buffer[size:]
+	}
+
+	if len(decoded) == 0 {
+		return nil
+	}
+	return c.tunnelGET.handleRequestBytes(decoded, len(decoded))
+}
+
 func (c *RTSPClientConnection) handleCommandOptions() {
-	c.responseBuffer = fmt.Sprintf("RTSP/1.0 200 OK\r\n"+
-		"CSeq: %s\r\n"+
-		"%sPublic: %s\r\n\r\n",
-		c.currentCSeq, livemedia.DateHeader(), livemedia.AllowedCommandNames)
+	resp := c.newResponse(rtsp.StatusOK)
+	resp.Header.Set("Public", strings.Join(livemedia.AllowedCommandNames[:], ", "))
+	c.setResponse(resp)
 }
 
 func (c *RTSPClientConnection) handleCommandGetParameter() {
-	c.setRTSPResponse("200 OK")
+	c.setRTSPResponse(rtsp.StatusOK)
 }
 
 func (c *RTSPClientConnection) handleCommandSetParameter() {
-	c.setRTSPResponse("200 OK")
+	c.setRTSPResponse(rtsp.StatusOK)
 }
 
 func (c *RTSPClientConnection) handleCommandNotFound() {
-	c.setRTSPResponse("404 Stream Not Found")
+	resp := c.newResponse(rtsp.StatusNotFound)
+	resp.Reason = "Stream Not Found"
+	c.setResponse(resp)
 }
 
 func (c *RTSPClientConnection) handleCommandSessionNotFound() {
-	c.setRTSPResponse("454 Session Not Found")
+	c.setRTSPResponse(rtsp.StatusSessionNotFound)
 }
 
 func (c *RTSPClientConnection) handleCommandUnsupportedTransport() {
-	c.setRTSPResponse("461 Unsupported Transport")
-}
-
-func (c *RTSPClientConnection) handleAlternativeRequestByte(requestByte uint) {
-	var buffer []byte
-	if requestByte == 0xFF {
-		c.handleRequestBytes(buffer, -1)
-	} else if requestByte == 0xFE {
-	} else {
-	}
+	c.setRTSPResponse(rtsp.StatusUnsupportedTransport)
 }
 
-func (c *RTSPClientConnection) handleCommandDescribe(urlPreSuffix, urlSuffix, fullRequestStr string) {
+func (c *RTSPClientConnection) handleCommandDescribe(urlPreSuffix, urlSuffix string, req *rtsp.Request) {
 	urlTotalSuffix := urlSuffix
 	if urlPreSuffix != "" {
 		urlTotalSuffix = fmt.Sprintf("%s/%s", urlPreSuffix, urlSuffix)
 	}
 
-	if ok := c.authenticationOK("DESCRIPE", urlTotalSuffix, fullRequestStr); !ok {
+	if ok := c.authenticationOK("DESCRIBE", urlTotalSuffix, req); !ok {
+		return
+	}
+	if status := c.server.config.Hooks.OnDescribe(c.requestInfo(req, urlTotalSuffix)); status != 0 {
+		c.setRTSPResponse(status)
 		return
 	}
 
@@ -216,135 +444,342 @@ func (c *RTSPClientConnection) handleCommandDescribe(urlPreSuffix, urlSuffix, fu
 	}
 
 	sdpDescription := sms.GenerateSDPDescription()
-	sdpDescriptionSize := len(sdpDescription)
-	if sdpDescriptionSize <= 0 {
-		c.setRTSPResponse("404 File Not Found, Or In Incorrect Format")
+	if len(sdpDescription) <= 0 {
+		resp := c.newResponse(rtsp.StatusNotFound)
+		resp.Reason = "File Not Found, Or In Incorrect Format"
+		c.setResponse(resp)
 		return
 	}
 
 	streamName := sms.StreamName()
-	rtspURL := c.server.RtspURL(streamName)
-	c.responseBuffer = fmt.Sprintf("RTSP/1.0 200 OK\r\n"+
-		"CSeq: %s\r\n"+
-		"%s"+
-		"Content-Base: %s/\r\n"+
-		"Content-Type: application/sdp\r\n"+
-		"Content-Length: %d\r\n\r\n"+
-		"%s",
-		c.currentCSeq, livemedia.DateHeader(), rtspURL, sdpDescriptionSize, sdpDescription)
+	rtspURL := c.rtspURL(streamName)
+
+	resp := c.newResponse(rtsp.StatusOK)
+	resp.Header.Set("Content-Base", rtspURL+"/")
+	resp.Header.Set("Content-Type", "application/sdp")
+	resp.Body = []byte(sdpDescription)
+	c.setResponse(resp)
+}
+
+func (c *RTSPClientConnection) handleCommandAnnounce(urlPreSuffix, urlSuffix string, req *rtsp.Request) {
+	urlTotalSuffix := urlSuffix
+	if urlPreSuffix != "" {
+		urlTotalSuffix = fmt.Sprintf("%s/%s", urlPreSuffix, urlSuffix)
+	}
+
+	if ok := c.authenticationOK("ANNOUNCE", urlTotalSuffix, req); !ok {
+		return
+	}
+	if status := c.server.config.Hooks.OnAnnounce(c.requestInfo(req, urlTotalSuffix)); status != 0 {
+		c.setRTSPResponse(status)
+		return
+	}
+
+	// The SDP description of the stream is the request's body:
+	sms := livemedia.NewRecordServerMediaSession(urlTotalSuffix, string(req.Body))
+	if sms == nil {
+		c.setRTSPResponse(rtsp.StatusBadRequest)
+		return
+	}
+
+	if c.announcedSession != nil {
+		c.server.closeLiveSession(c.announcedSession)
+		c.announcedSession = nil
+	}
+
+	// Only one client at a time may publish a stream under a given name:
+	if !c.server.addLiveSession(sms) {
+		c.setRTSPResponse(rtsp.StatusMethodNotValidInThisState)
+		return
+	}
+
+	c.announcedSession = sms
+	c.setRTSPResponse(rtsp.StatusOK)
+}
+
+// handleInterleavedPacket takes a RTP or RTCP packet that arrived "interleaved" on this
+// connection. It's either a part of a stream that the client is RECORDing, or a RTCP
+// report about a stream that it's playing.
+func (c *RTSPClientConnection) handleInterleavedPacket(channelID uint, packet []byte) {
+	if subsession, existed := c.recordChannels[channelID]; existed {
+		subsession.HandleInterleavedPacket(channelID, packet)
+		return
+	}
+
+	if c.clientSession != nil {
+		c.clientSession.noteLiveness()
+	}
+}
+
+func (c *RTSPClientConnection) addRecordChannels(rtpChannelID, rtcpChannelID uint,
+	subsession *livemedia.RecordServerMediaSubsession) {
+	c.recordChannels[rtpChannelID] = subsession
+	c.recordChannels[rtcpChannelID] = subsession
 }
 
 // Don't do anything with "currentCSeq", because it might be nonsense
 func (c *RTSPClientConnection) handleCommandBad() {
-	c.responseBuffer = fmt.Sprintf("RTSP/1.0 400 Bad Request\r\n"+
-		"%sAllow: %s\r\n\r\n", livemedia.DateHeader(), livemedia.AllowedCommandNames)
+	resp := rtsp.NewResponse(rtsp.StatusBadRequest)
+	resp.Header.Set("Date", rtsp.FormatDate(time.Now()))
+	resp.Header.Set("Allow", strings.Join(livemedia.AllowedCommandNames[:], ", "))
+	c.setResponse(resp)
 }
 
 func (c *RTSPClientConnection) handleCommandNotSupported() {
-	c.responseBuffer = fmt.Sprintf("RTSP/1.0 405 Method Not Allowed\r\n"+
-		"CSeq: %s\r\n"+
-		"%sAllow: %s\r\n\r\n",
-		c.currentCSeq, livemedia.DateHeader(), livemedia.AllowedCommandNames)
+	resp := c.newResponse(rtsp.StatusMethodNotAllowed)
+	resp.Header.Set("Allow", strings.Join(livemedia.AllowedCommandNames[:], ", "))
+	c.setResponse(resp)
+}
+
+func (c *RTSPClientConnection) handleHTTPCommandBad() {
+	c.setResponse(newHTTPResponse(rtsp.StatusBadRequest))
 }
 
 func (c *RTSPClientConnection) handleHTTPCommandNotSupported() {
-	c.responseBuffer = fmt.Sprintf("HTTP/1.0 405 Method Not Allowed\r\n%s\r\n\r\n", livemedia.DateHeader())
+	c.setResponse(newHTTPResponse(rtsp.StatusMethodNotAllowed))
 }
 
 func (c *RTSPClientConnection) handleHTTPCommandNotFound() {
-	c.responseBuffer = fmt.Sprintf("HTTP/1.0 404 Not Found\r\n%s\r\n\r\n", livemedia.DateHeader())
+	c.setResponse(newHTTPResponse(rtsp.StatusNotFound))
 }
 
 func (c *RTSPClientConnection) handleHTTPCommandTunnelingGET(sessionCookie string) {
-	if _, existed := c.server.clientHTTPConnections[sessionCookie]; !existed {
-		c.server.clientHTTPConnections[sessionCookie] = c
+	// Record ourself as having this 'session cookie', so that a subsequent HTTP "POST"
+	// command (with the same 'session cookie') can find us:
+	if c.sessionCookie != "" || !c.server.addHTTPConnection(sessionCookie, c) {
+		c.handleHTTPCommandBad()
+		return
 	}
+	c.sessionCookie = sessionCookie
 
 	// Construct our response:
-	c.responseBuffer = fmt.Sprintf("HTTP/1.0 200 OK\r\n" +
-		"Date: Thu, 19 Aug 1982 18:30:00 GMT\r\n" +
-		"Cache-Control: no-cache\r\n" +
-		"Pragma: no-cache\r\n" +
-		"Content-Type: application/x-rtsp-tunnelled\r\n\r\n")
+	resp := newHTTPResponse(rtsp.StatusOK)
+	resp.Header.Set("Date", "Thu, 19 Aug 1982 18:30:00 GMT")
+	resp.Header.Set("Cache-Control", "no-cache")
+	resp.Header.Set("Pragma", "no-cache")
+	resp.Header.Set("Content-Type", "application/x-rtsp-tunnelled")
+	c.setResponse(resp)
 }
 
 func (c *RTSPClientConnection) handleHTTPCommandTunnelingPOST(sessionCookie, extraData string, extraDataSize uint) {
+	// Use the 'session cookie' to find the GET connection that we're paired with:
+	if sessionCookie == "" {
+		c.handleHTTPCommandBad()
+		return
+	}
+	if _, existed := c.server.pairHTTPConnections(sessionCookie, c); !existed {
+		c.handleHTTPCommandNotFound()
+		return
+	}
+
+	// From now on, everything that arrives on this connection is handled by the GET
+	// connection, and no response is sent on this one:
+	if extraDataSize > 0 {
+		if err := c.handleTunneledBytes([]byte(extraData)); err != nil {
+			log.Error(4, "failed to handle the tunneled request bytes: %v", err)
+		}
+	}
 }
 
 // By default, we don't support requests to access streams via HTTP:
-func (c *RTSPClientConnection) handleHTTPCommandStreamingGET(urlSuffix, fullRequestStr string) {
+func (c *RTSPClientConnection) handleHTTPCommandStreamingGET(urlSuffix string, req *rtsp.Request) {
 	c.handleHTTPCommandNotSupported()
 }
 
-func (c *RTSPClientConnection) setRTSPResponse(responseStr string) {
-	c.responseBuffer = fmt.Sprintf("RTSP/1.0 %s\r\n"+
-		"CSeq: %s\r\n"+
-		"%s\r\n",
-		responseStr, c.currentCSeq, livemedia.DateHeader())
+// newResponse creates a response to the current request.
+func (c *RTSPClientConnection) newResponse(statusCode int) *rtsp.Response {
+	resp := rtsp.NewResponse(statusCode)
+	resp.Header.Set("CSeq", c.currentCSeq)
+	resp.Header.Set("Date", rtsp.FormatDate(time.Now()))
+	return resp
+}
+
+func newHTTPResponse(statusCode int) *rtsp.Response {
+	resp := rtsp.NewResponse(statusCode)
+	resp.Proto = "HTTP/1.0"
+	resp.Header.Set("Date", rtsp.FormatDate(time.Now()))
+	return resp
+}
+
+func (c *RTSPClientConnection) setResponse(resp *rtsp.Response) {
+	c.responseBuffer = resp.String()
+	c.responseStatus = resp.StatusCode
+}
+
+func (c *RTSPClientConnection) setRTSPResponse(statusCode int) {
+	c.setResponse(c.newResponse(statusCode))
+}
+
+func (c *RTSPClientConnection) setRTSPResponseWithSessionID(statusCode int, sessionID string) {
+	resp := c.newResponse(statusCode)
+	resp.Header.Set("Session", sessionID)
+	c.setResponse(resp)
 }
 
-func (c *RTSPClientConnection) setRTSPResponseWithSessionID(responseStr string, sessionID string) {
-	c.responseBuffer = fmt.Sprintf("RTSP/1.0 %s\r\n"+
-		"CSeq: %s\r\n"+
-		"%sSession: %s\r\n\r\n",
-		responseStr, c.currentCSeq, livemedia.DateHeader(), sessionID)
+// requestInfo describes the current request to the hooks.
+func (c *RTSPClientConnection) requestInfo(req *rtsp.Request, streamName string) *RequestInfo {
+	return &RequestInfo{
+		Request:    req,
+		RemoteAddr: c.socket.RemoteAddr().String(),
+		StreamName: streamName,
+		SessionID:  c.sessionIDStr,
+	}
 }
 
-func (c *RTSPClientConnection) authenticationOK(cmdName, urlSuffix, fullRequestStr string) bool {
-	if !c.server.specialClientAccessCheck(c.socket, c.remoteAddr, urlSuffix) {
-		c.setRTSPResponse("401 Unauthorized")
+func (c *RTSPClientConnection) authenticationOK(cmdName, urlSuffix string, req *rtsp.Request) bool {
+	if !c.server.accessAllowed(c.socket, urlSuffix) {
+		c.setRTSPResponse(rtsp.StatusForbidden)
 		return false
 	}
 
-	authDatabase := c.server.authDatabase
+	if c.server.urlSigner != nil && !signedURLCommand(cmdName, req) {
+		// A signed URL gives the right to play only; publishing takes a user who may publish:
+		if c.server.authenticator == nil {
+			c.setRTSPResponse(rtsp.StatusForbidden)
+			return false
+		}
+	} else if c.server.urlSigner != nil {
+		switch ok, signed := c.signedURLOK(urlSuffix, req); {
+		case ok:
+			return true
+		case signed:
+			c.setRTSPResponse(rtsp.StatusForbidden)
+			return false
+		case c.server.authenticator == nil:
+			// The streams are for the holders of signed URLs only:
+			c.setRTSPResponse(rtsp.StatusForbidden)
+			return false
+		}
+	}
+
+	authenticator := c.server.authenticator
 	// dont enable authentication control, pass it
-	if authDatabase == nil {
+	if authenticator == nil {
 		return true
 	}
+	if req == c.authenticatedReq {
+		return c.authorized(c.authenticatedUser, cmdName, urlSuffix, req)
+	}
 
-	for {
-		// To authenticate, we first need to have a nonce set up
-		// from a previous attempt:
-		if c.digest.Nonce == "" {
-			break
+	// The request needs to contain an "Authorization:" header, containing a username,
+	// (our) realm, (our) nonce, uri, and response string, which is checked against
+	// the response that we compute from the information that we have:
+	err := auth.ErrUnauthorized
+	authorization := req.Header.Get("Authorization")
+	if header := auth.ParseAuthorizationHeader("Authorization: " + authorization); header != nil {
+		if err = authenticator.Authenticate(cmdName, req.URL, header); err == nil {
+			c.authenticatedReq, c.authenticatedUser = req, header.Username
+			return c.authorized(header.Username, cmdName, urlSuffix, req)
 		}
-
-		// Next, the request needs to contain an "Authorization:" header,
-		// containing a username, (our) realm, (our) nonce, uri,
-		// and response string:
-		header := auth.ParseAuthorizationHeader(fullRequestStr)
-		if header == nil {
-			break
+		log.Info("failed to authenticate %s: %v", header.Username, err)
+	} else if username, password, ok := auth.ParseBasicAuthorization(authorization); ok && c.basicAuthAllowed() {
+		if err = authenticator.AuthenticateBasic(username, password); err == nil {
+			c.authenticatedReq, c.authenticatedUser = req, username
+			return c.authorized(username, cmdName, urlSuffix, req)
 		}
+		log.Info("failed to authenticate %s (Basic): %v", username, err)
+	}
 
-		// Next, the username has to be known to us:
-		c.digest.Password = authDatabase.LookupPassword(header.Username)
-		if c.digest.Password == "" {
-			break
-		}
-		c.digest.Username = header.Username
+	resp := c.newResponse(rtsp.StatusUnauthorized)
+	for _, challenge := range authenticator.Challenges(err == auth.ErrStaleNonce) {
+		resp.Header.Add("WWW-Authenticate", challenge)
+	}
+	// (after the digest ones, for the clients that take the first challenge)
+	if c.basicAuthAllowed() {
+		resp.Header.Add("WWW-Authenticate", authenticator.BasicChallenge())
+	}
+	c.setResponse(resp)
+	return false
+}
 
-		// Finally, compute a digest response from the information that we have,
-		// and compare it to the one that we were given:
-		response := c.digest.ComputeResponse(cmdName, header.URI)
-		if response == header.Response {
-			return true
-		}
-		break
-	}
-
-	c.digest.Realm = authDatabase.Realm
-	c.digest.RandomNonce()
-	c.responseBuffer = fmt.Sprintf("RTSP/1.0 401 Unauthorized\r\n"+
-		"CSeq: %s\r\n"+
-		"%s"+
-		"WWW-Authenticate: Digest realm=\"%s\", nonce=\"%s\"\r\n\r\n",
-		c.currentCSeq,
-		livemedia.DateHeader(),
-		c.digest.Realm, c.digest.Nonce)
+// authorized checks that an authenticated user may do what the request asks of the stream,
+// and answers "403 Forbidden" if not.
+func (c *RTSPClientConnection) authorized(username, cmdName, streamName string, req *rtsp.Request) bool {
+	authorizer := c.server.authorizer
+	if authorizer == nil {
+		return true
+	}
+
+	permission := auth.PermissionPlay
+	if cmdName == "ANNOUNCE" || cmdName == "RECORD" || cmdName == "SETUP" && isRecordSetup(req) {
+		permission = auth.PermissionPublish
+	}
+	if authorizer.Authorize(username, streamName, permission) {
+		return true
+	}
+
+	log.Info("%s may not %s %s", username, permission, streamName)
+	c.setRTSPResponse(rtsp.StatusForbidden)
 	return false
 }
 
+// isRecordSetup reports whether a "SETUP" is for a track that the client is going to RECORD.
+func isRecordSetup(req *rtsp.Request) bool {
+	transport, err := rtsp.ParseTransport(req.Header.Get("Transport"))
+	return err == nil && transport.Mode == "RECORD"
+}
+
+// signedURLOK checks the token of the request's URL, if it has one (signed), or else whether the
+// connection has shown a signed URL of the stream before.
+func (c *RTSPClientConnection) signedURLOK(streamName string, req *rtsp.Request) (ok, signed bool) {
+	var query url.Values
+	if i := strings.IndexByte(req.URL, '?'); i != -1 {
+		query, _ = url.ParseQuery(req.URL[i+1:])
+	}
+	if query.Get(auth.TokenParam) == "" {
+		expires, existed := c.tokenStreams[streamName]
+		return existed && time.Now().Before(expires), false
+	}
+
+	host, _, _ := net.SplitHostPort(c.socket.RemoteAddr().String())
+	expires, err := c.server.urlSigner.Verify(streamName, query, net.ParseIP(host))
+	if err != nil {
+		log.Info("refused the signed URL %s: %v", req.URL, err)
+		return false, true
+	}
+	c.tokenStreams[streamName] = expires
+	return true, true
+}
+
+// signedURLCommand reports whether a signed URL lets a client make a request, which it does
+// for the requests that play a stream.
+func signedURLCommand(cmdName string, req *rtsp.Request) bool {
+	switch cmdName {
+	case "DESCRIBE", "PLAY", "PAUSE", "TEARDOWN", "GET_PARAMETER":
+		return true
+	case "SETUP":
+		return !isRecordSetup(req)
+	}
+	return false
+}
+
+// basicAuthAllowed reports whether the client may authenticate with "Basic" on this connection.
+func (c *RTSPClientConnection) basicAuthAllowed() bool {
+	switch c.server.config.BasicAuth {
+	case BasicAuthOn:
+		return true
+	case BasicAuthTLS:
+		return c.isTLS()
+	}
+	return false
+}
+
+// isTLS reports whether the client connected over RTSPS.
+func (c *RTSPClientConnection) isTLS() bool {
+	_, isTLS := c.socket.(*tls.Conn)
+	return isTLS
+}
+
+// rtspURL returns the URL of a stream, with the scheme and the port that the client connected to.
+func (c *RTSPClientConnection) rtspURL(streamName string) string {
+	// The URL is at the address that the client reached us at, of its address family:
+	scheme, port := "rtsp", c.server.rtspPort
+	if c.isTLS() {
+		scheme, port = "rtsps", c.server.rtspsPort
+	}
+	return fmt.Sprintf("%s://%s/%s", scheme, net.JoinHostPort(c.localAddr, strconv.Itoa(port)), streamName)
+}
+
 func (c *RTSPClientConnection) newClientSession(sessionID string) *RTSPClientSession {
 	return newRTSPClientSession(c, sessionID)
 }
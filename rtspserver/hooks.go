@@ -0,0 +1,48 @@
+package rtspserver
+
+import "github.com/djwackey/dorsvr/rtsp"
+
+// RequestInfo is what the hooks are told about a request.
+type RequestInfo struct {
+	Request    *rtsp.Request
+	RemoteAddr string
+	// the stream that the request is about, as the client named it
+	StreamName string
+	// the client's session, if it has one; a "SETUP" that creates a session is given the new one
+	SessionID string
+}
+
+// Hooks lets an application follow what the clients of the server do, and turn requests down.
+// A request hook is called after the client has been authenticated, and before the server acts
+// on the request. It returns 0 to let the server go on, or a RTSP status code (e.g. 403 or 453)
+// to answer the request with instead.
+//
+// The hooks are called from the goroutines of the connections, so they may run concurrently.
+// Embed DefaultHooks to implement only the hooks that you need.
+type Hooks interface {
+	// OnConnect is called when a client connects; returning false closes the connection.
+	OnConnect(remoteAddr string) bool
+	// OnDisconnect is called when the connection of a client has closed.
+	OnDisconnect(remoteAddr string)
+	OnDescribe(info *RequestInfo) int
+	OnAnnounce(info *RequestInfo) int
+	OnSetup(info *RequestInfo) int
+	OnPlay(info *RequestInfo) int
+	OnPause(info *RequestInfo) int
+	OnRecord(info *RequestInfo) int
+	// OnTeardown is called before a session's streams are torn down; it can't be turned down.
+	OnTeardown(info *RequestInfo)
+}
+
+// DefaultHooks accepts every connection and every request.
+type DefaultHooks struct{}
+
+func (DefaultHooks) OnConnect(remoteAddr string) bool { return true }
+func (DefaultHooks) OnDisconnect(remoteAddr string)   {}
+func (DefaultHooks) OnDescribe(info *RequestInfo) int { return 0 }
+func (DefaultHooks) OnAnnounce(info *RequestInfo) int { return 0 }
+func (DefaultHooks) OnSetup(info *RequestInfo) int    { return 0 }
+func (DefaultHooks) OnPlay(info *RequestInfo) int     { return 0 }
+func (DefaultHooks) OnPause(info *RequestInfo) int    { return 0 }
+func (DefaultHooks) OnRecord(info *RequestInfo) int   { return 0 }
+func (DefaultHooks) OnTeardown(info *RequestInfo)     {}
@@ -0,0 +1,185 @@
+package rtspserver
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"sync"
+
+	"github.com/djwackey/dorsvr/livemedia"
+)
+
+type requestKey struct {
+	method string
+	status int
+}
+
+// serverMetrics counts what the server has done since it started. The rest of the
+// metrics are read from the sessions and the streams when they are scraped.
+type serverMetrics struct {
+	mutex    sync.Mutex
+	requests map[requestKey]uint64
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		requests: make(map[requestKey]uint64),
+	}
+}
+
+func (m *serverMetrics) noteRequest(method string, status int) {
+	m.mutex.Lock()
+	m.requests[requestKey{method, status}]++
+	m.mutex.Unlock()
+}
+
+func (m *serverMetrics) requestCounts() map[requestKey]uint64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	counts := make(map[requestKey]uint64, len(m.requests))
+	for key, count := range m.requests {
+		counts[key] = count
+	}
+	return counts
+}
+
+// streamMetrics is a track that is being sent to clients. The statistics of the streams
+// that send the same track (to different clients) are added up.
+type streamMetrics struct {
+	streamName string
+	trackID    string
+	stats      livemedia.StreamStats
+}
+
+// monitorHandler serves the metrics, and the pprof profiles, on the monitor address.
+func (s *RTSPServer) monitorHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// handleMetrics writes the metrics in the Prometheus text format.
+func (s *RTSPServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+
+	requests := s.metrics.requestCounts()
+	keys := make([]requestKey, 0, len(requests))
+	for key := range requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].status < keys[j].status
+	})
+	writeMetricHeader(&buf, "dorsvr_rtsp_requests_total", "counter",
+		"The RTSP requests that have been answered, by method and status code.")
+	for _, key := range keys {
+		fmt.Fprintf(&buf, "dorsvr_rtsp_requests_total{method=%q,status=\"%d\"} %d\n",
+			key.method, key.status, requests[key])
+	}
+
+	clientSessions := s.allClientSessions()
+	writeMetricHeader(&buf, "dorsvr_client_sessions", "gauge",
+		"The RTSP client sessions that are open.")
+	fmt.Fprintf(&buf, "dorsvr_client_sessions %d\n", len(clientSessions))
+
+	streams, numStreamStates := s.streamMetrics(clientSessions)
+	writeMetricHeader(&buf, "dorsvr_stream_states", "gauge",
+		"The streams that are set up for clients.")
+	fmt.Fprintf(&buf, "dorsvr_stream_states %d\n", numStreamStates)
+
+	writeMetricHeader(&buf, "dorsvr_rtp_packets_sent_total", "counter",
+		"The RTP packets that have been sent, by stream and track.")
+	for _, stream := range streams {
+		fmt.Fprintf(&buf, "dorsvr_rtp_packets_sent_total{stream=%q,track=%q} %d\n",
+			stream.streamName, stream.trackID, stream.stats.PacketCount)
+	}
+	writeMetricHeader(&buf, "dorsvr_rtp_octets_sent_total", "counter",
+		"The RTP payload octets that have been sent, by stream and track.")
+	for _, stream := range streams {
+		fmt.Fprintf(&buf, "dorsvr_rtp_octets_sent_total{stream=%q,track=%q} %d\n",
+			stream.streamName, stream.trackID, stream.stats.OctetCount)
+	}
+
+	writeMetricHeader(&buf, "dorsvr_rtcp_fraction_lost", "gauge",
+		"The fraction of the RTP packets lost, as the latest RTCP receiver report of each receiver says.")
+	for _, stream := range streams {
+		for _, receiver := range stream.stats.Receivers {
+			fmt.Fprintf(&buf, "dorsvr_rtcp_fraction_lost{stream=%q,track=%q,ssrc=\"%08X\"} %g\n",
+				stream.streamName, stream.trackID, receiver.SSRC, receiver.FractionLost)
+		}
+	}
+	writeMetricHeader(&buf, "dorsvr_rtcp_packets_lost", "gauge",
+		"The RTP packets lost since each receiver started, as its latest RTCP receiver report says.")
+	for _, stream := range streams {
+		for _, receiver := range stream.stats.Receivers {
+			fmt.Fprintf(&buf, "dorsvr_rtcp_packets_lost{stream=%q,track=%q,ssrc=\"%08X\"} %d\n",
+				stream.streamName, stream.trackID, receiver.SSRC, receiver.PacketsLost)
+		}
+	}
+	writeMetricHeader(&buf, "dorsvr_rtcp_jitter_seconds", "gauge",
+		"The interarrival jitter, as the latest RTCP receiver report of each receiver says.")
+	for _, stream := range streams {
+		for _, receiver := range stream.stats.Receivers {
+			fmt.Fprintf(&buf, "dorsvr_rtcp_jitter_seconds{stream=%q,track=%q,ssrc=\"%08X\"} %g\n",
+				stream.streamName, stream.trackID, receiver.SSRC, receiver.Jitter)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// streamMetrics returns the tracks that are sent to the client sessions, and the number
+// of streams that send them. A stream that is shared by several clients is counted once.
+func (s *RTSPServer) streamMetrics(clientSessions []*RTSPClientSession) ([]*streamMetrics, int) {
+	var streams []*streamMetrics
+	tracks := make(map[string]*streamMetrics)
+	seen := make(map[*livemedia.StreamState]bool)
+	for _, clientSession := range clientSessions {
+		streamName, streamStates := clientSession.streams()
+		for _, streamState := range streamStates {
+			if seen[streamState.streamToken] {
+				continue
+			}
+			seen[streamState.streamToken] = true
+
+			stats := streamState.streamToken.Stats()
+			if stats == nil {
+				continue
+			}
+			trackID := streamState.subsession.TrackID()
+			track, existed := tracks[streamName+"/"+trackID]
+			if !existed {
+				track = &streamMetrics{streamName: streamName, trackID: trackID}
+				tracks[streamName+"/"+trackID] = track
+				streams = append(streams, track)
+			}
+			track.stats.PacketCount += stats.PacketCount
+			track.stats.OctetCount += stats.OctetCount
+			track.stats.Receivers = append(track.stats.Receivers, stats.Receivers...)
+		}
+	}
+
+	sort.Slice(streams, func(i, j int) bool {
+		if streams[i].streamName != streams[j].streamName {
+			return streams[i].streamName < streams[j].streamName
+		}
+		return streams[i].trackID < streams[j].trackID
+	})
+	return streams, len(seen)
+}
+
+func writeMetricHeader(buf *bytes.Buffer, name, metricType, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
@@ -1,13 +1,16 @@
 package rtspserver
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
-	_ "net/http/pprof"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -19,83 +22,341 @@ import (
 )
 
 type RTSPServer struct {
+	config                 *Config
 	urlPrefix              string
+	mediaRoot              string
+	mediaRootErr           error // why the configured media root couldn't be set
 	rtspPort               int
 	httpPort               int
 	rtspListen             *net.TCPListener
+	rtspsPort              int
+	rtspsListen            *net.TCPListener
+	tlsConfig              *tls.Config
 	httpListen             *net.TCPListener
+	monitor                *http.Server
+	admin                  *http.Server
+	clientConnections      map[*RTSPClientConnection]bool
 	clientSessions         map[string]*RTSPClientSession
 	clientHTTPConnections  map[string]*RTSPClientConnection
 	serverMediaSessions    map[string]*livemedia.ServerMediaSession
+	liveSessions           map[string]*livemedia.ServerMediaSession
 	reclamationTestSeconds time.Duration
-	authDatabase           *auth.Database
+	authStore              auth.Store
+	authenticator          *auth.Authenticator
+	urlSigner              *auth.URLSigner
+	authorizer             auth.Authorizer
+	metrics                *serverMetrics
 	smsMutex               sync.Mutex
 	sessionMutex           sync.Mutex
 	httpConnectionMutex    sync.Mutex
 	rtspConnectionMutex    sync.Mutex
+	// the goroutines that Shutdown waits for
+	goroutines   sync.WaitGroup
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
+	// the SAP announcers of the multicast sessions, by stream name
+	sapAnnouncers map[string]*livemedia.SAPAnnouncer
 }
 
-func New(authDatabase *auth.Database) *RTSPServer {
+// New creates a server with the given settings; nil means the defaults (see DefaultConfig).
+func New(config *Config) *RTSPServer {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
-	return &RTSPServer{
-		authDatabase:           authDatabase,
-		reclamationTestSeconds: 65,
+	config = config.withDefaults()
+	s := &RTSPServer{
+		config:                 config,
+		metrics:                newServerMetrics(),
+		reclamationTestSeconds: time.Duration(config.SessionTimeout),
+		shutdown:               make(chan struct{}),
+		clientConnections:      make(map[*RTSPClientConnection]bool),
 		clientSessions:         make(map[string]*RTSPClientSession),
 		clientHTTPConnections:  make(map[string]*RTSPClientConnection),
 		serverMediaSessions:    make(map[string]*livemedia.ServerMediaSession),
+		liveSessions:           make(map[string]*livemedia.ServerMediaSession),
+		sapAnnouncers:          make(map[string]*livemedia.SAPAnnouncer),
 	}
+
+	var err error
+	if s.authStore, err = config.authStore(); err != nil {
+		// Let nobody in, rather than everybody:
+		lg.Error(0, "failed to read the users: %v", err)
+		s.authStore = auth.NewAuthDatabase(config.Realm)
+	}
+	if s.authStore != nil {
+		s.authenticator = auth.NewAuthenticator(s.authStore)
+		s.authenticator.AllowLegacyDigests = config.LegacyDigestAuth
+	}
+	if s.authorizer, err = config.authorizer(); err != nil {
+		// Let nobody do anything, rather than everybody everything:
+		lg.Error(0, "failed to read the permissions: %v", err)
+		s.authorizer = auth.NewPermissions()
+	}
+	if config.URLSigningKey != "" {
+		s.urlSigner = auth.NewURLSigner([]byte(config.URLSigningKey))
+	}
+	if watched, ok := s.authStore.(watchedStore); ok {
+		s.goroutines.Add(1)
+		go s.watchAuthStore(watched)
+	}
+	if _, ok := s.authStore.(*auth.HTPasswdFile); ok && config.BasicAuth == BasicAuthOff {
+		lg.Warn("the users of the htpasswd file can't authenticate, since Basic authentication is off")
+	}
+
+	if err := livemedia.SetRTPPortRange(config.RTPPortStart, config.RTPPortEnd); err != nil {
+		lg.Error(0, "failed to set the RTP port range: %v", err)
+	}
+	if config.MediaRoot != "" {
+		if err := s.SetMediaRoot(config.MediaRoot); err != nil {
+			// Serve no files, rather than those of the working directory:
+			lg.Error(0, "failed to set the media root: %v", err)
+			s.mediaRootErr = err
+		}
+	}
+	return s
+}
+
+// watchedStore is a store of users that is read from a file, such as a htdigest file.
+type watchedStore interface {
+	Watch(interval time.Duration, stop <-chan struct{}, onError func(error))
 }
 
+// watchAuthStore reads a file of users again whenever it changes.
+func (s *RTSPServer) watchAuthStore(store watchedStore) {
+	defer s.goroutines.Done()
+
+	store.Watch(5*time.Second, s.shutdown, func(err error) {
+		lg.Error(0, "failed to read the users again: %v", err)
+	})
+}
+
+// ReloadAuthStore reads the users again, if they come from a file.
+func (s *RTSPServer) ReloadAuthStore() error {
+	if store, ok := s.authStore.(interface {
+		Reload() error
+	}); ok {
+		return store.Reload()
+	}
+	return nil
+}
+
+// Destroy shuts the server down, without waiting for its goroutines to end.
 func (s *RTSPServer) Destroy() {
-	s.rtspListen.Close()
-	s.httpListen.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.Shutdown(ctx)
+}
+
+// Shutdown stops accepting connections, ends every stream (sending a RTCP "BYE" to
+// its clients), destroys every client session, and closes every connection. Then it
+// waits for the server's goroutines to end, or for the context to be done, whichever
+// is first, and returns the context's error in the latter case.
+func (s *RTSPServer) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() {
+		s.rtspConnectionMutex.Lock()
+		close(s.shutdown)
+		s.rtspConnectionMutex.Unlock()
+
+		if s.rtspListen != nil {
+			s.rtspListen.Close()
+		}
+		if s.rtspsListen != nil {
+			s.rtspsListen.Close()
+		}
+		if s.httpListen != nil {
+			s.httpListen.Close()
+		}
+		if s.monitor != nil {
+			s.monitor.Close()
+		}
+		if s.admin != nil {
+			s.admin.Close()
+		}
+
+		// Stop streaming to the clients:
+		for _, clientSession := range s.allClientSessions() {
+			clientSession.destroy()
+		}
+		for _, c := range s.allClientConnections() {
+			c.socket.Close()
+		}
+
+		// Stop receiving the streams that clients are publishing, and close
+		// the sockets of the streams that are left:
+		for _, sms := range s.ServerMediaSessions() {
+			s.closeLiveSession(sms)
+			sms.Close()
+		}
+		for _, sms := range s.allFileSessions() {
+			s.removeFileSession(sms.StreamName())
+			sms.Close()
+		}
+	})
+
+	done := make(chan struct{})
+	go func() {
+		s.goroutines.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (s *RTSPServer) Listen(portNum int) error {
-	s.rtspPort = portNum
+	return s.listen(fmt.Sprintf(":%d", portNum))
+}
 
-	var err error
-	s.rtspListen, err = s.setupOurSocket(portNum)
-	if err == nil {
-		s.startMonitor()
+// ListenTLS binds a port for RTSPS (RTSP over TLS) clients, which Start serves along with
+// the port of Listen, if any. The "interleaved" RTP and RTCP data goes over TLS too.
+func (s *RTSPServer) ListenTLS(portNum int, tlsConfig *tls.Config) error {
+	return s.listenTLS(fmt.Sprintf(":%d", portNum), tlsConfig)
+}
+
+// ListenAndServe binds the configured address (and the RTSPS one, if there is one), sets up
+// RTSP-over-HTTP tunneling on the first of the configured HTTP ports that is free, and starts
+// serving clients.
+func (s *RTSPServer) ListenAndServe() error {
+	if err := s.listen(s.config.ListenAddr); err != nil {
+		return err
+	}
+	if s.config.TLSListenAddr != "" {
+		cert, err := tls.LoadX509KeyPair(s.config.TLSCertFile, s.config.TLSKeyFile)
+		if err != nil {
+			return err
+		}
+		if err = s.listenTLS(s.config.TLSListenAddr, &tls.Config{Certificates: []tls.Certificate{cert}}); err != nil {
+			return err
+		}
 	}
 
-	return err
+	for _, httpPort := range s.config.HTTPTunnelPorts {
+		if s.SetupTunnelingOverHTTP(httpPort) {
+			break
+		}
+	}
+
+	s.Start()
+	return nil
+}
+
+func (s *RTSPServer) listen(addr string) error {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.rtspListen, err = net.ListenTCP("tcp", tcpAddr)
+	if err != nil {
+		return err
+	}
+	s.rtspPort = s.rtspListen.Addr().(*net.TCPAddr).Port
+
+	s.startServices()
+	return nil
+}
+
+func (s *RTSPServer) listenTLS(addr string, tlsConfig *tls.Config) error {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.rtspsListen, err = net.ListenTCP("tcp", tcpAddr)
+	if err != nil {
+		return err
+	}
+	s.rtspsPort = s.rtspsListen.Addr().(*net.TCPAddr).Port
+	s.tlsConfig = tlsConfig
+
+	s.startServices()
+	return nil
+}
+
+// startServices starts the monitor and the admin API, once the server has bound a port.
+func (s *RTSPServer) startServices() {
+	if s.config.MonitorAddr != "" && s.monitor == nil {
+		s.startMonitor()
+	}
+	if s.config.AdminAddr != "" && s.admin == nil {
+		s.startAdmin()
+	}
 }
 
 func (s *RTSPServer) Start() {
-	go s.incomingConnectionHandler(s.rtspListen)
+	if s.rtspListen != nil {
+		s.goroutines.Add(1)
+		go s.incomingConnectionHandler(s.rtspListen, nil)
+	}
+	if s.rtspsListen != nil {
+		s.goroutines.Add(1)
+		go s.incomingConnectionHandler(s.rtspsListen, s.tlsConfig)
+	}
 }
 
 func (s *RTSPServer) startMonitor() {
+	s.monitor = &http.Server{Addr: s.config.MonitorAddr, Handler: s.monitorHandler()}
 	go s.monitorServe()
 }
 
 func (s *RTSPServer) monitorServe() {
-	log.Println(http.ListenAndServe("0.0.0.0:6060", nil))
+	if err := s.monitor.ListenAndServe(); err != http.ErrServerClosed {
+		log.Println(err)
+	}
 }
 
 func (s *RTSPServer) setupOurSocket(portNum int) (*net.TCPListener, error) {
-	tcpAddr := fmt.Sprintf("0.0.0.0:%d", portNum)
+	tcpAddr := fmt.Sprintf(":%d", portNum)
 	addr, _ := net.ResolveTCPAddr("tcp", tcpAddr)
 
 	return net.ListenTCP("tcp", addr)
 }
 
 func (s *RTSPServer) SetupTunnelingOverHTTP(httpPort int) bool {
-	s.httpPort = httpPort
-
-	var err error
-	s.httpListen, err = s.setupOurSocket(httpPort)
+	httpListen, err := s.setupOurSocket(httpPort)
 	if err != nil {
 		return false
 	}
+	s.httpPort, s.httpListen = httpListen.Addr().(*net.TCPAddr).Port, httpListen
 
-	go s.incomingConnectionHandler(s.httpListen)
+	s.goroutines.Add(1)
+	go s.incomingConnectionHandler(s.httpListen, nil)
 	return true
 }
 
+// SetMediaRoot sets the directory in which the files that clients ask for are looked up.
+// By default, it's the working directory of the process.
+func (s *RTSPServer) SetMediaRoot(dir string) error {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("media root %s is not a directory", dir)
+	}
+
+	s.mediaRoot, s.mediaRootErr = root, nil
+	return nil
+}
+
+// MediaRoot returns the directory in which the files that clients ask for are looked up.
+func (s *RTSPServer) MediaRoot() string {
+	return s.mediaRoot
+}
+
 func (s *RTSPServer) HTTPServerPortNum() int {
 	return s.httpPort
 }
@@ -107,32 +368,97 @@ func (s *RTSPServer) RtspURL(streamName string) string {
 
 func (s *RTSPServer) RtspURLPrefix() string {
 	s.urlPrefix, _ = gs.OurIPAddress()
-	return fmt.Sprintf("rtsp://%s:%d/", s.urlPrefix, s.rtspPort)
+	return fmt.Sprintf("rtsp://%s/", net.JoinHostPort(s.urlPrefix, strconv.Itoa(s.rtspPort)))
+}
+
+// RtspsURLPrefix returns the prefix of the RTSPS URLs of the server's streams.
+func (s *RTSPServer) RtspsURLPrefix() string {
+	urlPrefix, _ := gs.OurIPAddress()
+	return fmt.Sprintf("rtsps://%s/", net.JoinHostPort(urlPrefix, strconv.Itoa(s.rtspsPort)))
 }
 
-func (s *RTSPServer) incomingConnectionHandler(l *net.TCPListener) {
+// incomingConnectionHandler accepts the connections of a listener; with a TLS config,
+// they're TLS connections.
+func (s *RTSPServer) incomingConnectionHandler(l *net.TCPListener, tlsConfig *tls.Config) {
+	defer s.goroutines.Done()
+
 	for {
 		tcpConn, err := l.AcceptTCP()
 		if err != nil {
+			select {
+			case <-s.shutdown:
+				return
+			default:
+			}
 			lg.Error(0, "failed to accept client.%s", err.Error())
 			continue
 		}
 
 		tcpConn.SetReadBuffer(50 * 1024)
 
+		// (The TLS handshake happens on the connection's first read.)
+		var conn net.Conn = tcpConn
+		if tlsConfig != nil {
+			conn = tls.Server(tcpConn, tlsConfig)
+		}
+
 		// Create a new object for handling server RTSP connection:
-		go s.newClientConnection(tcpConn)
+		s.goroutines.Add(1)
+		go s.newClientConnection(conn)
 	}
 }
 
 func (s *RTSPServer) newClientConnection(conn net.Conn) {
+	defer s.goroutines.Done()
+
 	c := newRTSPClientConnection(s, conn)
-	if c != nil {
-		c.incomingRequestHandler()
+	if !s.addClientConnection(c) {
+		// we're shutting down
+		conn.Close()
+		return
 	}
+	defer s.removeClientConnection(c)
+
+	remoteAddr := conn.RemoteAddr().String()
+	if !s.config.Hooks.OnConnect(remoteAddr) {
+		conn.Close()
+		return
+	}
+	defer s.config.Hooks.OnDisconnect(remoteAddr)
+
+	c.incomingRequestHandler()
 }
 
-func (s *RTSPServer) getServerMediaSession(streamName string) (sms *livemedia.ServerMediaSession, existed bool) {
+func (s *RTSPServer) addClientConnection(c *RTSPClientConnection) bool {
+	s.rtspConnectionMutex.Lock()
+	defer s.rtspConnectionMutex.Unlock()
+	select {
+	case <-s.shutdown:
+		return false
+	default:
+	}
+	s.clientConnections[c] = true
+	return true
+}
+
+func (s *RTSPServer) removeClientConnection(c *RTSPClientConnection) {
+	s.rtspConnectionMutex.Lock()
+	defer s.rtspConnectionMutex.Unlock()
+	delete(s.clientConnections, c)
+}
+
+func (s *RTSPServer) allClientConnections() []*RTSPClientConnection {
+	s.rtspConnectionMutex.Lock()
+	defer s.rtspConnectionMutex.Unlock()
+
+	connections := make([]*RTSPClientConnection, 0, len(s.clientConnections))
+	for c := range s.clientConnections {
+		connections = append(connections, c)
+	}
+	return connections
+}
+
+func (s *RTSPServer) getFileSession(streamName string) (sms *livemedia.ServerMediaSession, existed bool) {
 	s.smsMutex.Lock()
 	defer s.smsMutex.Unlock()
 	sms, existed = s.serverMediaSessions[streamName]
@@ -140,27 +466,92 @@ func (s *RTSPServer) getServerMediaSession(streamName string) (sms *livemedia.Se
 }
 
 func (s *RTSPServer) lookupServerMediaSession(streamName string) *livemedia.ServerMediaSession {
+	// First, check the sessions that were added in code, or ANNOUNCEd by clients:
+	if sms, existed := s.getLiveSession(streamName); existed {
+		return sms
+	}
+
 	// Next, check whether we already have a "ServerMediaSession" for server file:
-	sms, existed := s.getServerMediaSession(streamName)
+	sms, existed := s.getFileSession(streamName)
 
-	fid, err := os.Open(streamName)
-	if err != nil {
+	fileName, ok := s.resolveFileName(streamName)
+	if !ok {
 		if existed {
-			s.removeServerMediaSession(streamName)
+			s.removeFileSession(streamName)
 		}
 		return nil
 	}
-	defer fid.Close()
 
 	if !existed {
-		sms = s.createNewSMS(streamName)
-		s.addServerMediaSession(sms)
+		sms = s.createNewSMS(streamName, fileName)
+		if sms == nil {
+			return nil
+		}
+		s.addFileSession(sms)
 	}
 
 	return sms
 }
 
-func (s *RTSPServer) addServerMediaSession(sms *livemedia.ServerMediaSession) {
+// resolveFileName maps a stream name to the path of a regular file inside the media root.
+// Names that are absolute, that contain "..", or that lead (through symlinks) outside of
+// the media root are refused.
+func (s *RTSPServer) resolveFileName(streamName string) (string, bool) {
+	if streamName == "" || strings.ContainsRune(streamName, 0) || strings.Contains(streamName, "\\") ||
+		strings.HasPrefix(streamName, "/") || filepath.IsAbs(streamName) {
+		return "", false
+	}
+	for _, element := range strings.Split(streamName, "/") {
+		if element == ".." {
+			return "", false
+		}
+	}
+
+	if s.mediaRootErr != nil {
+		return "", false
+	}
+
+	root := s.mediaRoot
+	if root == "" {
+		var err error
+		if root, err = filepath.Abs("."); err != nil {
+			return "", false
+		}
+		if root, err = filepath.EvalSymlinks(root); err != nil {
+			return "", false
+		}
+	}
+
+	fileName, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(streamName)))
+	if err != nil {
+		return "", false
+	}
+
+	relPath, err := filepath.Rel(root, fileName)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		lg.Warn("[resolveFileName] \"%s\" leads outside of the media root", streamName)
+		return "", false
+	}
+
+	info, err := os.Stat(fileName)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+	return fileName, true
+}
+
+func (s *RTSPServer) allFileSessions() []*livemedia.ServerMediaSession {
+	s.smsMutex.Lock()
+	defer s.smsMutex.Unlock()
+
+	sessions := make([]*livemedia.ServerMediaSession, 0, len(s.serverMediaSessions))
+	for _, sms := range s.serverMediaSessions {
+		sessions = append(sessions, sms)
+	}
+	return sessions
+}
+
+func (s *RTSPServer) addFileSession(sms *livemedia.ServerMediaSession) {
 	sessionName := sms.StreamName()
 
 	s.smsMutex.Lock()
@@ -168,12 +559,126 @@ func (s *RTSPServer) addServerMediaSession(sms *livemedia.ServerMediaSession) {
 	s.serverMediaSessions[sessionName] = sms
 }
 
-func (s *RTSPServer) removeServerMediaSession(sessionName string) {
+func (s *RTSPServer) removeFileSession(sessionName string) {
 	s.smsMutex.Lock()
 	defer s.smsMutex.Unlock()
 	delete(s.serverMediaSessions, sessionName)
 }
 
+// AddServerMediaSession makes a stream that was built in code available to clients,
+// under the session's stream name. It replaces any session that was previously
+// added with the same name; a stream that a client was publishing under the name is
+// stopped. Added sessions are looked up before files.
+// A session with multicast tracks is also announced with SAP (see Config.SAPInterval).
+func (s *RTSPServer) AddServerMediaSession(sms *livemedia.ServerMediaSession) {
+	if sms == nil {
+		return
+	}
+	streamName := sms.StreamName()
+
+	var announcer *livemedia.SAPAnnouncer
+	if s.config.SAPInterval > 0 && sms.IsMulticast() {
+		var err error
+		announcer, err = livemedia.NewSAPAnnouncer(sms, livemedia.SAPAddress, livemedia.SAPPort,
+			time.Duration(s.config.SAPInterval)*time.Second)
+		if err != nil {
+			lg.Warn("[AddServerMediaSession] failed to announce \"%s\" with SAP: %v", streamName, err)
+		}
+	}
+
+	s.smsMutex.Lock()
+	replaced := s.liveSessions[streamName]
+	s.liveSessions[streamName] = sms
+	replacedAnnouncer := s.sapAnnouncers[streamName]
+	delete(s.sapAnnouncers, streamName)
+	if announcer != nil {
+		s.sapAnnouncers[streamName] = announcer
+	}
+	s.smsMutex.Unlock()
+
+	if replacedAnnouncer != nil {
+		replacedAnnouncer.Stop()
+	}
+	if replaced != nil && replaced != sms {
+		stopRecording(replaced)
+	}
+	if announcer != nil {
+		announcer.Start()
+	}
+}
+
+// RemoveServerMediaSession stops offering the stream that was added (or ANNOUNCEd)
+// under streamName to new clients.
+func (s *RTSPServer) RemoveServerMediaSession(streamName string) {
+	if sms, existed := s.getLiveSession(streamName); existed {
+		s.closeLiveSession(sms)
+	}
+}
+
+// ServerMediaSessions returns the sessions that have been added, or that clients
+// are currently publishing with ANNOUNCE and RECORD.
+func (s *RTSPServer) ServerMediaSessions() []*livemedia.ServerMediaSession {
+	s.smsMutex.Lock()
+	defer s.smsMutex.Unlock()
+
+	sessions := make([]*livemedia.ServerMediaSession, 0, len(s.liveSessions))
+	for _, sms := range s.liveSessions {
+		sessions = append(sessions, sms)
+	}
+	return sessions
+}
+
+func (s *RTSPServer) getLiveSession(streamName string) (sms *livemedia.ServerMediaSession, existed bool) {
+	s.smsMutex.Lock()
+	defer s.smsMutex.Unlock()
+	sms, existed = s.liveSessions[streamName]
+	return
+}
+
+// addLiveSession registers a stream that a client has ANNOUNCEd.
+// It fails if another session is already registered under the same name.
+func (s *RTSPServer) addLiveSession(sms *livemedia.ServerMediaSession) bool {
+	streamName := sms.StreamName()
+
+	s.smsMutex.Lock()
+	defer s.smsMutex.Unlock()
+	if _, existed := s.liveSessions[streamName]; existed {
+		return false
+	}
+	s.liveSessions[streamName] = sms
+	return true
+}
+
+// closeLiveSession unregisters a published stream, stops receiving it, and withdraws
+// its SAP announcements.
+func (s *RTSPServer) closeLiveSession(sms *livemedia.ServerMediaSession) {
+	streamName := sms.StreamName()
+
+	var announcer *livemedia.SAPAnnouncer
+	s.smsMutex.Lock()
+	if s.liveSessions[streamName] == sms {
+		delete(s.liveSessions, streamName)
+		announcer = s.sapAnnouncers[streamName]
+		delete(s.sapAnnouncers, streamName)
+	}
+	s.smsMutex.Unlock()
+
+	if announcer != nil {
+		announcer.Stop()
+	}
+	stopRecording(sms)
+}
+
+// stopRecording stops receiving a stream that a client publishes (if sms is one), which ends
+// it for its players.
+func stopRecording(sms *livemedia.ServerMediaSession) {
+	for i := 0; i < sms.SubsessionCounter; i++ {
+		if subsession, ok := sms.Subsessions[i].(*livemedia.RecordServerMediaSubsession); ok {
+			subsession.StopRecording()
+		}
+	}
+}
+
 func (s *RTSPServer) getClientSession(sessionID string) (clientSession *RTSPClientSession, existed bool) {
 	s.sessionMutex.Lock()
 	defer s.sessionMutex.Unlock()
@@ -187,35 +692,93 @@ func (s *RTSPServer) addClientSession(sessionID string, clientSession *RTSPClien
 	s.clientSessions[sessionID] = clientSession
 }
 
+func (s *RTSPServer) allClientSessions() []*RTSPClientSession {
+	s.sessionMutex.Lock()
+	defer s.sessionMutex.Unlock()
+
+	sessions := make([]*RTSPClientSession, 0, len(s.clientSessions))
+	for _, clientSession := range s.clientSessions {
+		sessions = append(sessions, clientSession)
+	}
+	return sessions
+}
+
 func (s *RTSPServer) removeClientSession(sessionID string) {
 	s.sessionMutex.Lock()
 	defer s.sessionMutex.Unlock()
 	delete(s.clientSessions, sessionID)
 }
 
-func (s *RTSPServer) createNewSMS(fileName string) (sms *livemedia.ServerMediaSession) {
-	array := strings.Split(fileName, ".")
-	if len(array) < 2 {
-		return
+// addHTTPConnection records a HTTP GET connection of RTSP-over-HTTP tunneling, so that the
+// POST connection with the same session cookie can find it. It fails if the cookie is in use.
+func (s *RTSPServer) addHTTPConnection(sessionCookie string, c *RTSPClientConnection) bool {
+	s.httpConnectionMutex.Lock()
+	defer s.httpConnectionMutex.Unlock()
+	if _, existed := s.clientHTTPConnections[sessionCookie]; existed {
+		return false
+	}
+	s.clientHTTPConnections[sessionCookie] = c
+	return true
+}
+
+// pairHTTPConnections links a HTTP POST connection to the GET connection with the same
+// session cookie. The cookie can be used only once.
+func (s *RTSPServer) pairHTTPConnections(sessionCookie string, postConnection *RTSPClientConnection) (
+	getConnection *RTSPClientConnection, existed bool) {
+	s.httpConnectionMutex.Lock()
+	defer s.httpConnectionMutex.Unlock()
+	if getConnection, existed = s.clientHTTPConnections[sessionCookie]; existed {
+		delete(s.clientHTTPConnections, sessionCookie)
+		getConnection.tunnelPOST = postConnection
+		postConnection.tunnelGET = getConnection
 	}
+	return
+}
+
+// unpairHTTPConnection forgets a GET or POST connection of RTSP-over-HTTP tunneling, and
+// returns the other connection of its pair, if it has one.
+func (s *RTSPServer) unpairHTTPConnection(c *RTSPClientConnection) *RTSPClientConnection {
+	s.httpConnectionMutex.Lock()
+	defer s.httpConnectionMutex.Unlock()
+	if s.clientHTTPConnections[c.sessionCookie] == c {
+		delete(s.clientHTTPConnections, c.sessionCookie)
+	}
+
+	if c.tunnelGET != nil {
+		return c.tunnelGET
+	}
+	return c.tunnelPOST
+}
 
-	extension := array[1]
+func (s *RTSPServer) createNewSMS(streamName, fileName string) (sms *livemedia.ServerMediaSession) {
+	extension := strings.TrimPrefix(filepath.Ext(streamName), ".")
 	switch extension {
 	case "264":
 		// Assumed to be a H.264 Video Elementary Stream file:
-		sms = livemedia.NewServerMediaSession("H.264 Video", fileName)
+		sms = livemedia.NewServerMediaSession("H.264 Video", streamName)
 		// allow for some possibly large H.264 frames
 		livemedia.OutPacketBufferMaxSize = 2000000
 		sms.AddSubsession(livemedia.NewH264FileMediaSubsession(fileName))
 	case "ts":
 		//indexFileName := fmt.Sprintf("%sx", fileName)
-		sms = livemedia.NewServerMediaSession("MPEG Transport Stream", fileName)
+		sms = livemedia.NewServerMediaSession("MPEG Transport Stream", streamName)
 		sms.AddSubsession(livemedia.NewM2TSFileMediaSubsession(fileName))
 	default:
 	}
-	return
-}
 
-func (s *RTSPServer) specialClientAccessCheck(clientSocket net.Conn, clientAddr, urlSuffix string) bool {
-	return true
+	if sms != nil && s.config.SRTP {
+		for i := 0; i < sms.SubsessionCounter; i++ {
+			subsession, ok := sms.Subsessions[i].(interface {
+				EnableSRTP() error
+			})
+			if !ok {
+				continue
+			}
+			if err := subsession.EnableSRTP(); err != nil {
+				lg.Error(0, "failed to enable SRTP for %s: %v", streamName, err)
+				return nil
+			}
+		}
+	}
+	return
 }
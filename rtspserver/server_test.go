@@ -0,0 +1,1216 @@
+package rtspserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djwackey/dorsvr/auth"
+	gs "github.com/djwackey/dorsvr/groupsock"
+	"github.com/djwackey/dorsvr/livemedia"
+	"github.com/djwackey/dorsvr/rtsp"
+)
+
+func TestResolveFileName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dorsvr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	root := filepath.Join(dir, "media")
+	os.MkdirAll(filepath.Join(root, "live"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "live", "test.264"), []byte{0}, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "secret.ts"), []byte{0}, 0644)
+	os.Symlink(filepath.Join(dir, "secret.ts"), filepath.Join(root, "escape.ts"))
+	os.Symlink(filepath.Join(root, "live", "test.264"), filepath.Join(root, "inside.264"))
+
+	server := New(nil)
+	if err := server.SetMediaRoot(root); err != nil {
+		t.Fatal(err)
+	}
+
+	accepted := []string{"live/test.264", "inside.264"}
+	for _, streamName := range accepted {
+		if _, ok := server.resolveFileName(streamName); ok {
+			t.Log("success")
+		} else {
+			t.Errorf("failed to resolve %s", streamName)
+		}
+	}
+
+	refused := []string{"", "../secret.ts", "live/../../secret.ts", "/etc/passwd", "escape.ts", "live", "missing.264"}
+	for _, streamName := range refused {
+		if _, ok := server.resolveFileName(streamName); !ok {
+			t.Log("success")
+		} else {
+			t.Errorf("failed to refuse %s", streamName)
+		}
+	}
+	// A media root that can't be used serves no files, not those of the working directory:
+	server = New(&Config{MediaRoot: filepath.Join(dir, "missing")})
+	if _, ok := server.resolveFileName("server_test.go"); !ok {
+		t.Log("success")
+	} else {
+		t.Error("failed to refuse server_test.go")
+	}
+}
+
+// startTestServer starts a server of the files in "../examples", on a free port.
+func startTestServer(t *testing.T, cfg *Config) *RTSPServer {
+	t.Helper()
+	server := New(cfg)
+	if err := server.SetMediaRoot("../examples"); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Listen(0); err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+	return server
+}
+
+// testConn is the RTSP connection of a test client.
+type testConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func newTestConn(conn net.Conn) *testConn {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return &testConn{Conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// dialTestServer connects to the RTSP port of a server, from 127.0.0.1.
+func dialTestServer(t *testing.T, server *RTSPServer) *testConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", server.rtspPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTestConn(conn)
+}
+
+// roundTrip sends a request, and returns the status, the header and the body of its response.
+func roundTrip(t *testing.T, conn *testConn, request string) (status int, header rtsp.Header, body []byte) {
+	t.Helper()
+	if _, err := conn.Write([]byte(request)); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rtsp.ReadResponse(conn.reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, resp.Header, resp.Body
+}
+
+// setupRequest returns a "SETUP" of a track, with the transport of the client.
+func setupRequest(url string, cseq int, transport string) string {
+	return fmt.Sprintf("SETUP %s RTSP/1.0\r\nCSeq: %d\r\nTransport: %s\r\n\r\n", url, cseq, transport)
+}
+
+// sessionRequest returns a request within a session.
+func sessionRequest(method, url string, cseq int, sessionID string) string {
+	return fmt.Sprintf("%s %s RTSP/1.0\r\nCSeq: %d\r\nSession: %s\r\n\r\n", method, url, cseq, sessionID)
+}
+
+// sessionID returns the session id of the response to a "SETUP".
+func sessionID(header rtsp.Header) string {
+	session, err := rtsp.ParseSession(header.Get("Session"))
+	if err != nil {
+		return ""
+	}
+	return session.ID
+}
+
+const interleavedTransport = "RTP/AVP/TCP;unicast;interleaved=0-1"
+
+func TestRequestFraming(t *testing.T) {
+	server := startTestServer(t, nil)
+	defer server.Destroy()
+	conn := dialTestServer(t, server)
+	defer conn.Close()
+
+	// a request split in two, pipelined requests, and a request with a body:
+	body := "position\r\n"
+	writes := []string{
+		"OPTIONS rtsp://127.0.0.1/test.264 RTSP/1.0\r\nCSeq: 1\r\n\r\nOPTIONS rtsp://127.0.0.1/test.264 RT",
+		"SP/1.0\r\nCSeq: 2\r\n\r\n",
+		fmt.Sprintf("GET_PARAMETER rtsp://127.0.0.1/test.264 RTSP/1.0\r\nCSeq: 3\r\nContent-Length: %d\r\n\r\n%s",
+			len(body), body[:4]),
+		body[4:] + "OPTIONS rtsp://127.0.0.1/test.264 RTSP/1.0\r\nCSeq: 4\r\n\r\n",
+	}
+	for _, data := range writes {
+		conn.Write([]byte(data))
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var cseqs []string
+	for len(cseqs) < 4 {
+		resp, err := rtsp.ReadResponse(conn.reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cseqs = append(cseqs, resp.Header.Get("CSeq"))
+	}
+
+	if strings.Join(cseqs, ",") == "1,2,3,4" {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: responses to %v", cseqs)
+	}
+}
+
+func TestBadContentLength(t *testing.T) {
+	server := startTestServer(t, nil)
+	defer server.Destroy()
+	conn := dialTestServer(t, server)
+	defer conn.Close()
+
+	// The body, which could be taken for another request, isn't handled:
+	status, _, _ := roundTrip(t, conn, "OPTIONS rtsp://127.0.0.1/test.264 RTSP/1.0\r\nCSeq: 1\r\n"+
+		"Content-Length: -42\r\n\r\nOPTIONS rtsp://127.0.0.1/test.264 RTSP/1.0\r\nCSeq: 2\r\n\r\n")
+	rest, err := ioutil.ReadAll(conn.reader)
+	if status == rtsp.StatusBadRequest && err == nil && len(rest) == 0 {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %d %v %q", status, err, rest)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	server := startTestServer(t, nil)
+	conn := dialTestServer(t, server)
+	defer conn.Close()
+
+	if status, _, _ := roundTrip(t, conn, setupRequest("rtsp://127.0.0.1/test.264/track1", 1,
+		interleavedTransport)); status != rtsp.StatusOK {
+		t.Fatalf("failed to SETUP: %d", status)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatalf("failed to shut down: %v", err)
+	}
+
+	// The connection has been closed, and the client session destroyed:
+	_, err := ioutil.ReadAll(conn.reader)
+	if err == nil && len(server.allClientSessions()) == 0 {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %v, %d sessions are left", err, len(server.allClientSessions()))
+	}
+}
+
+func TestShutdownRecording(t *testing.T) {
+	server := startTestServer(t, nil)
+	publisher := publish(t, server, "rtsp://127.0.0.1/live")
+	defer publisher.close()
+	for i := 0; i < 5; i++ {
+		publisher.push()
+	}
+
+	// A client plays the stream that is being published:
+	conn := dialTestServer(t, server)
+	defer conn.Close()
+	roundTrip(t, conn, "DESCRIBE rtsp://127.0.0.1/live RTSP/1.0\r\nCSeq: 1\r\n\r\n")
+	_, header, _ := roundTrip(t, conn, setupRequest("rtsp://127.0.0.1/live/track1", 2, interleavedTransport))
+	if status, _, _ := roundTrip(t, conn, sessionRequest("PLAY", "rtsp://127.0.0.1/live/", 3,
+		sessionID(header))); status != rtsp.StatusOK {
+		t.Fatalf("failed to PLAY: %d", status)
+	}
+	publisher.push()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatalf("failed to shut down: %v", err)
+	}
+
+	// Both connections have been closed (the publisher's may be reset, since it was sending),
+	// and the published stream is gone:
+	if isClosed(publisher.conn) && isClosed(conn) && len(server.ServerMediaSessions()) == 0 {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %d sessions are left", len(server.ServerMediaSessions()))
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	server := startTestServer(t, nil)
+	defer server.Destroy()
+	conn := dialTestServer(t, server)
+	defer conn.Close()
+
+	roundTrip(t, conn, "OPTIONS rtsp://127.0.0.1/test.264 RTSP/1.0\r\nCSeq: 1\r\n\r\n")
+	roundTrip(t, conn, setupRequest("rtsp://127.0.0.1/test.264/track1", 2, interleavedTransport))
+	roundTrip(t, conn, "DESCRIBE rtsp://127.0.0.1/missing.264 RTSP/1.0\r\nCSeq: 3\r\n\r\n")
+
+	recorder := httptest.NewRecorder()
+	server.monitorHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	metrics := recorder.Body.String()
+
+	expected := []string{
+		`dorsvr_rtsp_requests_total{method="OPTIONS",status="200"} 1`,
+		`dorsvr_rtsp_requests_total{method="SETUP",status="200"} 1`,
+		`dorsvr_rtsp_requests_total{method="DESCRIBE",status="404"} 1`,
+		"dorsvr_client_sessions 1",
+		"dorsvr_stream_states 1",
+		`dorsvr_rtp_packets_sent_total{stream="test.264",track="track1"} 0`,
+	}
+	for _, line := range expected {
+		if strings.Contains(metrics, line+"\n") {
+			t.Log("success")
+		} else {
+			t.Errorf("failed: no %s in\n%s", line, metrics)
+		}
+	}
+}
+
+func TestAdminTerminateSession(t *testing.T) {
+	server := startTestServer(t, nil)
+	defer server.Destroy()
+	conn := dialTestServer(t, server)
+	defer conn.Close()
+
+	if status, _, _ := roundTrip(t, conn, setupRequest("rtsp://127.0.0.1/test.264/track1", 1,
+		interleavedTransport)); status != rtsp.StatusOK {
+		t.Fatalf("failed to SETUP: %d", status)
+	}
+
+	admin := server.adminHandler()
+	recorder := httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest("GET", "/api/sessions", nil))
+	var sessions []SessionInfo
+	if err := json.Unmarshal(recorder.Body.Bytes(), &sessions); err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].StreamName != "test.264" || sessions[0].Transport != "RTP/AVP/TCP" ||
+		sessions[0].RemoteAddr != conn.LocalAddr().String() {
+		t.Fatalf("failed: %s", recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest("DELETE", "/api/sessions/"+sessions[0].ID, nil))
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("failed to DELETE: %d", recorder.Code)
+	}
+
+	// The connection has been closed, and the client session destroyed:
+	_, err := ioutil.ReadAll(conn.reader)
+	recorder = httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest("GET", "/api/sessions/"+sessions[0].ID, nil))
+	if err == nil && recorder.Code == http.StatusNotFound {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %v, %d", err, recorder.Code)
+	}
+}
+
+type capacityHooks struct {
+	DefaultHooks
+	events chan string
+}
+
+func (h *capacityHooks) OnConnect(remoteAddr string) bool {
+	h.events <- "connect"
+	return true
+}
+
+func (h *capacityHooks) OnDescribe(info *RequestInfo) int {
+	h.events <- "describe " + info.StreamName
+	return 0
+}
+
+func (h *capacityHooks) OnSetup(info *RequestInfo) int {
+	h.events <- "setup " + info.StreamName
+	return rtsp.StatusNotEnoughBandwidth
+}
+
+func TestHooks(t *testing.T) {
+	hooks := &capacityHooks{events: make(chan string, 10)}
+	server := startTestServer(t, &Config{Hooks: hooks})
+	defer server.Destroy()
+	conn := dialTestServer(t, server)
+	defer conn.Close()
+
+	roundTrip(t, conn, "DESCRIBE rtsp://127.0.0.1/test.264 RTSP/1.0\r\nCSeq: 1\r\n\r\n")
+	status, _, _ := roundTrip(t, conn, setupRequest("rtsp://127.0.0.1/test.264/track1", 2, interleavedTransport))
+
+	events := []string{<-hooks.events, <-hooks.events, <-hooks.events}
+	if status == rtsp.StatusNotEnoughBandwidth &&
+		strings.Join(events, ",") == "connect,describe test.264,setup test.264" {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: SETUP %d, events %v", status, events)
+	}
+}
+
+func TestAccessForbidden(t *testing.T) {
+	policy, _ := NewCIDRPolicy(nil, []string{"127.0.0.0/8", "::1"})
+	server := startTestServer(t, &Config{AccessPolicy: policy})
+	defer server.Destroy()
+	conn := dialTestServer(t, server)
+	defer conn.Close()
+
+	describe, _, _ := roundTrip(t, conn, "DESCRIBE rtsp://127.0.0.1/test.264 RTSP/1.0\r\nCSeq: 1\r\n\r\n")
+	setup, _, _ := roundTrip(t, conn, setupRequest("rtsp://127.0.0.1/test.264/track1", 2, interleavedTransport))
+	if describe == rtsp.StatusForbidden && setup == rtsp.StatusForbidden && len(server.allClientSessions()) == 0 {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: responses %d %d, %d sessions", describe, setup, len(server.allClientSessions()))
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	for _, mode := range []string{BasicAuthOn, BasicAuthTLS} {
+		server := startTestServer(t, &Config{Users: map[string]string{"alice": "secret"}, BasicAuth: mode})
+		conn := dialTestServer(t, server)
+		// "alice:secret"
+		status, header, _ := roundTrip(t, conn, "DESCRIBE rtsp://127.0.0.1/test.264 RTSP/1.0\r\nCSeq: 1\r\n"+
+			"Authorization: Basic YWxpY2U6c2VjcmV0\r\n\r\n")
+		conn.Close()
+		server.Destroy()
+
+		// Over a plain connection, "tls" neither accepts nor offers Basic:
+		challenges := strings.Join(header.Values("WWW-Authenticate"), "\n")
+		if mode == BasicAuthOn && status == rtsp.StatusOK ||
+			mode == BasicAuthTLS && status == rtsp.StatusUnauthorized &&
+				strings.HasPrefix(challenges, "Digest") && !strings.Contains(challenges, "Basic") {
+			t.Logf("success: %s", mode)
+		} else {
+			t.Errorf("failed: %s: %d %s", mode, status, challenges)
+		}
+	}
+}
+
+func TestSetupNotFound(t *testing.T) {
+	server := startTestServer(t, &Config{Users: map[string]string{"alice": "secret"}, BasicAuth: BasicAuthOn})
+	defer server.Destroy()
+	conn := dialTestServer(t, server)
+	defer conn.Close()
+
+	// A client that isn't authenticated doesn't learn whether a stream exists, and an
+	// authenticated one is left with no session:
+	unauthenticated, _, _ := roundTrip(t, conn, setupRequest("rtsp://127.0.0.1/missing.264/track1", 1,
+		interleavedTransport))
+	notFound, _, _ := roundTrip(t, conn, "SETUP rtsp://127.0.0.1/missing.264/track1 RTSP/1.0\r\nCSeq: 2\r\n"+
+		"Authorization: Basic YWxpY2U6c2VjcmV0\r\nTransport: "+interleavedTransport+"\r\n\r\n")
+	if unauthenticated == rtsp.StatusUnauthorized && notFound == rtsp.StatusNotFound &&
+		len(server.allClientSessions()) == 0 {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: responses %d %d, %d sessions", unauthenticated, notFound, len(server.allClientSessions()))
+	}
+}
+
+func TestSignedURL(t *testing.T) {
+	server := startTestServer(t, &Config{URLSigningKey: "secret"})
+	defer server.Destroy()
+
+	signer := auth.NewURLSigner([]byte("secret"))
+	signed, _ := signer.SignURL("rtsp://127.0.0.1/test.264", time.Now().Add(time.Minute), "127.0.0.1")
+	forged := strings.Replace(signed, "test.264", "other.264", 1)
+
+	// (from 127.0.0.1, the address that the URL is signed for)
+	conn := dialTestServer(t, server)
+	defer conn.Close()
+
+	// The SETUP, whose URL comes from "Content-Base:", has no token, but follows a signed DESCRIBE:
+	var statuses []int
+	for _, request := range []string{
+		"DESCRIBE rtsp://127.0.0.1/test.264 RTSP/1.0\r\nCSeq: 1\r\n\r\n",
+		"DESCRIBE " + forged + " RTSP/1.0\r\nCSeq: 2\r\n\r\n",
+		"DESCRIBE " + signed + " RTSP/1.0\r\nCSeq: 3\r\n\r\n",
+		setupRequest("rtsp://127.0.0.1/test.264/track1", 4, interleavedTransport),
+	} {
+		status, _, _ := roundTrip(t, conn, request)
+		statuses = append(statuses, status)
+	}
+	if fmt.Sprint(statuses) == "[403 403 200 200]" {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: responses %v", statuses)
+	}
+}
+
+func TestSignedURLPublish(t *testing.T) {
+	server := startTestServer(t, &Config{
+		URLSigningKey: "secret",
+		Users:         map[string]string{"alice": "secret"},
+		BasicAuth:     BasicAuthOn,
+	})
+	defer server.Destroy()
+
+	signer := auth.NewURLSigner([]byte("secret"))
+	signed, _ := signer.SignURL("rtsp://127.0.0.1/live", time.Now().Add(time.Minute), "127.0.0.1")
+	conn := dialTestServer(t, server)
+	defer conn.Close()
+
+	// A signed URL lets its holder play a stream, but not publish one:
+	sdp := "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=live\r\nt=0 0\r\nm=video 0 RTP/AVP 96\r\n" +
+		"a=rtpmap:96 H264/90000\r\na=control:track1\r\n"
+	status, _, _ := roundTrip(t, conn, fmt.Sprintf("ANNOUNCE %s RTSP/1.0\r\nCSeq: 1\r\n"+
+		"Content-Type: application/sdp\r\nContent-Length: %d\r\n\r\n%s", signed, len(sdp), sdp))
+	if status == rtsp.StatusUnauthorized {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: response %d", status)
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	server := startTestServer(t, &Config{
+		Users:     map[string]string{"alice": "secret", "bob": "secret"},
+		BasicAuth: BasicAuthOn,
+		Roles: map[string][]auth.Rule{
+			"viewer": {{Streams: "*.264", Permissions: []auth.Permission{auth.PermissionPlay}}},
+		},
+		UserRoles: map[string][]string{"alice": {"viewer"}},
+	})
+	defer server.Destroy()
+	conn := dialTestServer(t, server)
+	defer conn.Close()
+
+	// "alice:secret" and "bob:secret"; alice may play, but not publish, and bob has no roles
+	alice, bob := "Authorization: Basic YWxpY2U6c2VjcmV0\r\n", "Authorization: Basic Ym9iOnNlY3JldA==\r\n"
+	sdp := "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=Test\r\nt=0 0\r\nm=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\n"
+	var statuses []int
+	for _, request := range []string{
+		"DESCRIBE rtsp://127.0.0.1/test.264 RTSP/1.0\r\nCSeq: 1\r\n" + alice + "\r\n",
+		"ANNOUNCE rtsp://127.0.0.1/live.264 RTSP/1.0\r\nCSeq: 2\r\n" + alice +
+			fmt.Sprintf("Content-Type: application/sdp\r\nContent-Length: %d\r\n\r\n%s", len(sdp), sdp),
+		"DESCRIBE rtsp://127.0.0.1/test.264 RTSP/1.0\r\nCSeq: 3\r\n" + bob + "\r\n",
+	} {
+		status, _, _ := roundTrip(t, conn, request)
+		statuses = append(statuses, status)
+	}
+	if fmt.Sprint(statuses) == "[200 403 403]" {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: responses %v", statuses)
+	}
+}
+
+func TestRTSPS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "dorsvr"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	server := New(nil)
+	if err := server.SetMediaRoot("../examples"); err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	if err := server.ListenTLS(0, tlsConfig); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Destroy()
+	server.Start()
+
+	tlsConn, err := tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", server.rtspsPort), &tls.Config{RootCAs: roots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := newTestConn(tlsConn)
+	defer conn.Close()
+
+	status, header, _ := roundTrip(t, conn, "DESCRIBE rtsps://127.0.0.1/test.264 RTSP/1.0\r\nCSeq: 1\r\n\r\n")
+	if status == rtsp.StatusOK && strings.HasPrefix(header.Get("Content-Base"), "rtsps://") {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %d %s", status, header.Get("Content-Base"))
+	}
+
+	// The RTP packets come "interleaved" over TLS:
+	_, header, _ = roundTrip(t, conn, setupRequest("rtsps://127.0.0.1/test.264/track1", 2, interleavedTransport))
+	status, _, _ = roundTrip(t, conn, sessionRequest("PLAY", "rtsps://127.0.0.1/test.264/", 3, sessionID(header)))
+	if b, err := conn.reader.ReadByte(); err == nil && b == '$' && status == rtsp.StatusOK {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %d, %v", status, err)
+	}
+}
+
+func TestSRTP(t *testing.T) {
+	server := startTestServer(t, &Config{SRTP: true})
+	defer server.Destroy()
+	conn := dialTestServer(t, server)
+	defer conn.Close()
+
+	_, _, sdp := roundTrip(t, conn, "DESCRIBE rtsp://127.0.0.1/test.264 RTSP/1.0\r\nCSeq: 1\r\n\r\n")
+	var masterKey []byte
+	for _, line := range strings.Split(string(sdp), "\r\n") {
+		if strings.HasPrefix(line, "a=crypto:1 AES_CM_128_HMAC_SHA1_80 inline:") {
+			masterKey, _ = base64.StdEncoding.DecodeString(strings.TrimPrefix(line, "a=crypto:1 AES_CM_128_HMAC_SHA1_80 inline:"))
+		}
+	}
+	if strings.Contains(string(sdp), " RTP/SAVP 96\r\n") && len(masterKey) == 30 {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %s", sdp)
+	}
+
+	// The stream isn't sent in the clear:
+	if status, _, _ := roundTrip(t, conn, setupRequest("rtsp://127.0.0.1/test.264/track1", 2,
+		interleavedTransport)); status == rtsp.StatusUnsupportedTransport {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %d", status)
+	}
+
+	status, header, _ := roundTrip(t, conn, setupRequest("rtsp://127.0.0.1/test.264/track1", 3,
+		"RTP/SAVP/TCP;unicast;interleaved=0-1"))
+	transport, _ := rtsp.ParseTransport(header.Get("Transport"))
+	if status != rtsp.StatusOK || transport == nil || transport.Protocol != "RTP/SAVP" {
+		t.Fatalf("failed: %d %s", status, header.Get("Transport"))
+	}
+	roundTrip(t, conn, sessionRequest("PLAY", "rtsp://127.0.0.1/test.264/", 4, sessionID(header)))
+
+	// The first RTP packet is authenticated and decrypted with the key of the SDP:
+	frame := make([]byte, 4)
+	if _, err := io.ReadFull(conn.reader, frame); err != nil || frame[0] != '$' {
+		t.Fatalf("failed: %v", err)
+	}
+	packet := make([]byte, binary.BigEndian.Uint16(frame[2:]))
+	if _, err := io.ReadFull(conn.reader, packet); err != nil {
+		t.Fatal(err)
+	}
+	srtp, err := livemedia.NewSRTPContext(masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload, err := srtp.UnprotectRTP(packet); err == nil && len(payload) == len(packet)-10 {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %v", err)
+	}
+}
+
+func TestIPv6(t *testing.T) {
+	server := startTestServer(t, nil)
+	defer server.Destroy()
+
+	ipv6Conn, err := net.Dial("tcp", fmt.Sprintf("[::1]:%d", server.rtspPort))
+	if err != nil {
+		t.Skip("no IPv6 loopback")
+	}
+	conn := newTestConn(ipv6Conn)
+	defer conn.Close()
+
+	status, header, _ := roundTrip(t, conn, "DESCRIBE rtsp://[::1]/test.264 RTSP/1.0\r\nCSeq: 1\r\n\r\n")
+	contentBase := fmt.Sprintf("rtsp://[::1]:%d/test.264/", server.rtspPort)
+	if status == rtsp.StatusOK && header.Get("Content-Base") == contentBase {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %d %s", status, header.Get("Content-Base"))
+	}
+
+	// The stream is sent to our IPv6 address:
+	rtpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rtpConn.Close()
+	rtpPort := rtpConn.LocalAddr().(*net.UDPAddr).Port
+	status, header, _ = roundTrip(t, conn, setupRequest(contentBase+"track1", 2,
+		fmt.Sprintf("RTP/AVP;unicast;client_port=%d-%d", rtpPort, rtpPort+1)))
+	transport, _ := rtsp.ParseTransport(header.Get("Transport"))
+	if status != rtsp.StatusOK || transport == nil || transport.Destination != "::1" {
+		t.Fatalf("failed: %d %s", status, header.Get("Transport"))
+	}
+	roundTrip(t, conn, sessionRequest("PLAY", contentBase, 3, sessionID(header)))
+
+	rtpConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buffer := make([]byte, 2048)
+	if n, err := rtpConn.Read(buffer); err == nil && n > 12 && buffer[0]>>6 == 2 {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %v", err)
+	}
+}
+
+func TestMulticast(t *testing.T) {
+	// The test listens to the group, as a client would after the SETUP:
+	receiver, err := gs.NewMulticastGroupSock("232.1.2.3", 15010, 1)
+	if err != nil {
+		t.Skipf("no multicast: %v", err)
+	}
+	defer receiver.Close()
+
+	server := startTestServer(t, nil)
+	defer server.Destroy()
+
+	subsession, err := livemedia.NewMulticastServerMediaSubsession(
+		livemedia.NewH264FileMediaSubsession("../examples/test.264"), "232.1.2.3", 15010, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sms := livemedia.NewServerMediaSession("H.264 Video", "multicast")
+	sms.SetSSM(true)
+	sms.AddSubsession(subsession)
+	server.AddServerMediaSession(sms)
+
+	conn := dialTestServer(t, server)
+	defer conn.Close()
+
+	_, _, sdp := roundTrip(t, conn, "DESCRIBE rtsp://127.0.0.1/multicast RTSP/1.0\r\nCSeq: 1\r\n\r\n")
+	if strings.Contains(string(sdp), "m=video 15010 RTP/AVP 96\r\nc=IN IP4 232.1.2.3/1\r\n") &&
+		strings.Contains(string(sdp), "a=source-filter: incl IN IP4 * ") {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %s", sdp)
+	}
+
+	// Whatever the client asks for, the track is sent to the group:
+	status, header, _ := roundTrip(t, conn, setupRequest("rtsp://127.0.0.1/multicast/track1", 2,
+		"RTP/AVP;unicast;client_port=40000-40001"))
+	transport, _ := rtsp.ParseTransport(header.Get("Transport"))
+	if status != rtsp.StatusOK || transport == nil || !transport.Multicast ||
+		transport.Destination != "232.1.2.3" || transport.Port == nil || transport.Port.Start != 15010 ||
+		transport.Port.End != 15011 || transport.TTL != 1 {
+		t.Fatalf("failed: %d %s", status, header.Get("Transport"))
+	}
+	roundTrip(t, conn, sessionRequest("PLAY", "rtsp://127.0.0.1/multicast/", 3, sessionID(header)))
+
+	done := make(chan error, 1)
+	go func() {
+		buffer := make([]byte, 2048)
+		n, err := receiver.HandleRead(buffer)
+		if err == nil && (n <= 12 || buffer[0]>>6 != 2) {
+			err = fmt.Errorf("not a RTP packet")
+		}
+		done <- err
+	}()
+	select {
+	case err = <-done:
+	case <-time.After(5 * time.Second):
+		err = fmt.Errorf("timed out")
+	}
+	if err == nil {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %v", err)
+	}
+}
+
+// playUDP sets up a track to be sent to a UDP port of the test, and plays it. It returns the
+// session id.
+func playUDP(t *testing.T, conn *testConn, trackURL, streamURL string, rtpConn *net.UDPConn) string {
+	t.Helper()
+	rtpPort := rtpConn.LocalAddr().(*net.UDPAddr).Port
+	status, header, _ := roundTrip(t, conn, setupRequest(trackURL, 1,
+		fmt.Sprintf("RTP/AVP;unicast;client_port=%d-%d", rtpPort, rtpPort+1)))
+	if status != rtsp.StatusOK {
+		t.Fatalf("failed to SETUP: %d", status)
+	}
+	if status, _, _ = roundTrip(t, conn, sessionRequest("PLAY", streamURL, 2, sessionID(header))); status != rtsp.StatusOK {
+		t.Fatalf("failed to PLAY: %d", status)
+	}
+	return sessionID(header)
+}
+
+func TestSharedSource(t *testing.T) {
+	server := startTestServer(t, nil)
+	defer server.Destroy()
+
+	subsession := livemedia.NewH264FileMediaSubsession("../examples/test.264")
+	subsession.SetReuseFirstSource(true)
+	sms := livemedia.NewServerMediaSession("H.264 Video", "shared")
+	sms.AddSubsession(subsession)
+	server.AddServerMediaSession(sms)
+
+	// Two clients play the stream; they get the same stream:
+	type client struct {
+		conn      *testConn
+		rtpConn   *net.UDPConn
+		sessionID string
+	}
+	clients := make([]*client, 2)
+	for i := range clients {
+		c := &client{conn: dialTestServer(t, server)}
+		defer c.conn.Close()
+		rtpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rtpConn.Close()
+		c.rtpConn = rtpConn
+		c.sessionID = playUDP(t, c.conn, "rtsp://127.0.0.1/shared/track1", "rtsp://127.0.0.1/shared/", rtpConn)
+		clients[i] = c
+	}
+
+	receive := func(c *client, timeout time.Duration) (ssrc uint32, err error) {
+		c.rtpConn.SetReadDeadline(time.Now().Add(timeout))
+		buffer := make([]byte, 2048)
+		n, err := c.rtpConn.Read(buffer)
+		if err == nil && (n <= 12 || buffer[0]>>6 != 2) {
+			err = fmt.Errorf("not a RTP packet")
+		}
+		return binary.BigEndian.Uint32(buffer[8:12]), err
+	}
+	ssrc0, err0 := receive(clients[0], 5*time.Second)
+	ssrc1, err1 := receive(clients[1], 5*time.Second)
+	if err0 != nil || err1 != nil || ssrc0 != ssrc1 {
+		t.Fatalf("failed: %v %v %x %x", err0, err1, ssrc0, ssrc1)
+	}
+
+	// The second client isn't counted what was sent before it joined:
+	var sent [2]uint
+	for i, c := range clients {
+		clientSession, _ := server.getClientSession(c.sessionID)
+		sent[i] = clientSession.info().BytesSent
+	}
+	if sent[1] == 0 || sent[1] >= sent[0] {
+		t.Errorf("failed: %d bytes sent to the first client, %d to the second", sent[0], sent[1])
+	}
+
+	// The first client leaves, and the second goes on receiving the stream:
+	c := clients[0]
+	if status, _, _ := roundTrip(t, c.conn, sessionRequest("TEARDOWN", "rtsp://127.0.0.1/shared/", 3,
+		c.sessionID)); status != rtsp.StatusOK {
+		t.Fatalf("failed to TEARDOWN: %d", status)
+	}
+	// (Drain what was sent before the TEARDOWN.)
+	left := false
+	for i := 0; i < 50 && !left; i++ {
+		_, err := receive(c, 200*time.Millisecond)
+		left = err != nil
+	}
+	if _, err := receive(clients[1], 5*time.Second); left && err == nil {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %v %v", left, err)
+	}
+}
+
+func TestLiveSource(t *testing.T) {
+	server := startTestServer(t, nil)
+	defer server.Destroy()
+
+	source := livemedia.NewLiveH264Source()
+	defer source.Close()
+	sms := livemedia.NewServerMediaSession("H.264 Video", "live")
+	sms.AddSubsession(livemedia.NewLiveH264MediaSubsession(source))
+	server.AddServerMediaSession(sms)
+
+	// An encoder writes a key frame (bigger than a packet), then a picture in two slices:
+	sps, _ := base64.StdEncoding.DecodeString("Z01AM5p0FidCAAADAAIAAAMAZR4wZUA=")
+	pps, _ := base64.StdEncoding.DecodeString("aO48gA==")
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		idr := append([]byte{0, 0, 0, 1, 0x65}, bytes.Repeat([]byte{0xAB}, 3000)...)
+		slices := []byte{0, 0, 0, 1, 0x41, 1, 2, 3, 0, 0, 0, 1, 0x41, 4, 5, 6}
+		for i := 0; ; i++ {
+			accessUnit := slices
+			if i%10 == 0 {
+				accessUnit = append(append(append([]byte{0, 0, 0, 1}, sps...), append([]byte{0, 0, 0, 1}, pps...)...), idr...)
+			}
+			source.WriteFrame(accessUnit, time.Duration(i)*40*time.Millisecond)
+			select {
+			case <-done:
+				return
+			case <-time.After(40 * time.Millisecond):
+			}
+		}
+	}()
+
+	conn := dialTestServer(t, server)
+	defer conn.Close()
+
+	_, _, sdp := roundTrip(t, conn, "DESCRIBE rtsp://127.0.0.1/live RTSP/1.0\r\nCSeq: 1\r\n\r\n")
+	if !strings.Contains(string(sdp), "sprop-parameter-sets=Z01AM5p0FidCAAADAAIAAAMAZR4wZUA=,aO48gA==") {
+		t.Fatalf("failed: %s", sdp)
+	}
+
+	rtpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rtpConn.Close()
+	playUDP(t, conn, "rtsp://127.0.0.1/live/track1", "rtsp://127.0.0.1/live/", rtpConn)
+
+	// The stream starts with the key frame: the parameter sets, then the picture in FU-A fragments.
+	// The marker bit ends each picture:
+	rtpConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buffer := make([]byte, 2048)
+	var nalHeaders []byte
+	for len(nalHeaders) < 3 {
+		n, err := rtpConn.Read(buffer)
+		if err != nil || n <= 14 {
+			t.Fatalf("failed: %v", err)
+		}
+		nalHeaders = append(nalHeaders, buffer[12])
+		if buffer[12]&0x1F == 28 {
+			nalHeaders = append(nalHeaders, buffer[13])
+		}
+	}
+	if !bytes.Equal(nalHeaders[:4], []byte{0x67, 0x68, 0x60 | 28, 0x80 | 5}) {
+		t.Fatalf("failed: % x", nalHeaders)
+	}
+	markers := 0
+	for markers < 2 {
+		if n, err := rtpConn.Read(buffer); err != nil || n <= 12 {
+			t.Fatalf("failed: %v", err)
+		}
+		if buffer[1]&0x80 != 0 {
+			markers++
+		}
+	}
+	t.Log("success")
+}
+
+// testPublisher is a client that publishes a stream of two tracks, "track1" (H.264 video) over
+// UDP and "track2" (PCMU audio) interleaved on its RTSP connection.
+type testPublisher struct {
+	conn    *testConn
+	rtpConn *net.UDPConn
+	rtpAddr *net.UDPAddr
+	seqNo   uint16
+}
+
+// publish ANNOUNCEs a stream, SETUPs its tracks for recording, and RECORDs it.
+func publish(t *testing.T, server *RTSPServer, url string) *testPublisher {
+	t.Helper()
+	p := &testPublisher{conn: dialTestServer(t, server)}
+	rtpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.rtpConn = rtpConn
+
+	sdp := "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=live\r\nt=0 0\r\n" +
+		"m=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\na=control:track1\r\n" +
+		"m=audio 0 RTP/AVP 0\r\na=rtpmap:0 PCMU/8000\r\na=control:track2\r\n"
+	if status, _, _ := roundTrip(t, p.conn, fmt.Sprintf("ANNOUNCE %s RTSP/1.0\r\nCSeq: 1\r\n"+
+		"Content-Type: application/sdp\r\nContent-Length: %d\r\n\r\n%s", url, len(sdp), sdp)); status != rtsp.StatusOK {
+		t.Fatalf("failed to ANNOUNCE: %d", status)
+	}
+
+	rtpPort := rtpConn.LocalAddr().(*net.UDPAddr).Port
+	status, header, _ := roundTrip(t, p.conn, setupRequest(url+"/track1", 2,
+		fmt.Sprintf("RTP/AVP;unicast;client_port=%d-%d;mode=record", rtpPort, rtpPort+1)))
+	transport, err := rtsp.ParseTransport(header.Get("Transport"))
+	if status != rtsp.StatusOK || err != nil || transport.ServerPort == nil {
+		t.Fatalf("failed to SETUP the UDP track: %d %v", status, err)
+	}
+	p.rtpAddr = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: transport.ServerPort.Start}
+
+	id := sessionID(header)
+	if status, _, _ = roundTrip(t, p.conn, fmt.Sprintf("SETUP %s/track2 RTSP/1.0\r\nCSeq: 3\r\nSession: %s\r\n"+
+		"Transport: RTP/AVP/TCP;unicast;interleaved=0-1;mode=record\r\n\r\n", url, id)); status != rtsp.StatusOK {
+		t.Fatalf("failed to SETUP the interleaved track: %d", status)
+	}
+	if status, _, _ = roundTrip(t, p.conn, sessionRequest("RECORD", url, 4, id)); status != rtsp.StatusOK {
+		t.Fatalf("failed to RECORD: %d", status)
+	}
+	return p
+}
+
+// push sends a packet of each track: a H.264 IDR picture, and 160 samples of audio.
+func (p *testPublisher) push() {
+	p.seqNo++
+	packet := func(payloadType byte, payload []byte) []byte {
+		header := make([]byte, 12)
+		header[0], header[1] = 0x80, 0x80|payloadType
+		binary.BigEndian.PutUint16(header[2:], p.seqNo)
+		binary.BigEndian.PutUint32(header[4:], uint32(p.seqNo)*3600)
+		binary.BigEndian.PutUint32(header[8:], 0x1234+uint32(payloadType))
+		return append(header, payload...)
+	}
+
+	p.rtpConn.WriteToUDP(packet(96, []byte{0x65, 0x88, 0x84, 0x21}), p.rtpAddr)
+
+	audio := packet(0, bytes.Repeat([]byte{0x7F}, 160))
+	frame := []byte{'$', 0, byte(len(audio) >> 8), byte(len(audio))}
+	p.conn.Write(append(frame, audio...))
+}
+
+func (p *testPublisher) close() {
+	p.conn.Close()
+	p.rtpConn.Close()
+}
+
+func TestRecord(t *testing.T) {
+	server := startTestServer(t, nil)
+	defer server.Destroy()
+
+	publisher := publish(t, server, "rtsp://127.0.0.1/live")
+	defer publisher.close()
+	done := make(chan struct{})
+	pushed := make(chan struct{})
+	defer func() {
+		close(done)
+		<-pushed
+	}()
+	go func() {
+		defer close(pushed)
+		for {
+			publisher.push()
+			select {
+			case <-done:
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+	}()
+
+	// Another client plays the stream that is published:
+	conn := dialTestServer(t, server)
+	defer conn.Close()
+	_, _, sdp := roundTrip(t, conn, "DESCRIBE rtsp://127.0.0.1/live RTSP/1.0\r\nCSeq: 1\r\n\r\n")
+	if !strings.Contains(string(sdp), "a=rtpmap:96 H264/90000") || !strings.Contains(string(sdp), "a=rtpmap:0 PCMU/8000") {
+		t.Fatalf("failed: %s", sdp)
+	}
+	_, header, _ := roundTrip(t, conn, setupRequest("rtsp://127.0.0.1/live/track1", 2, interleavedTransport))
+	id := sessionID(header)
+	if status, _, _ := roundTrip(t, conn, fmt.Sprintf("SETUP rtsp://127.0.0.1/live/track2 RTSP/1.0\r\nCSeq: 3\r\n"+
+		"Session: %s\r\nTransport: RTP/AVP/TCP;unicast;interleaved=2-3\r\n\r\n", id)); status != rtsp.StatusOK {
+		t.Fatalf("failed to SETUP: %d", status)
+	}
+	if status, _, _ := roundTrip(t, conn, sessionRequest("PLAY", "rtsp://127.0.0.1/live/", 4, id)); status != rtsp.StatusOK {
+		t.Fatalf("failed to PLAY: %d", status)
+	}
+
+	// The player receives the frames of both tracks, as they were published:
+	var video, audio bool
+	for !video || !audio {
+		channelID, packet := readInterleaved(t, conn)
+		switch channelID {
+		case 0:
+			video = video || len(packet) == 16 && packet[1]&0x7F == 96 && bytes.Equal(packet[12:], []byte{0x65, 0x88, 0x84, 0x21})
+		case 2:
+			audio = audio || len(packet) == 172 && packet[1]&0x7F == 0 && bytes.Equal(packet[12:], bytes.Repeat([]byte{0x7F}, 160))
+		}
+	}
+	t.Log("success")
+}
+
+// readInterleaved reads a packet that is sent "interleaved" on a RTSP connection.
+func readInterleaved(t *testing.T, conn *testConn) (channelID byte, packet []byte) {
+	t.Helper()
+	frame := make([]byte, 4)
+	if _, err := io.ReadFull(conn.reader, frame); err != nil || frame[0] != '$' {
+		t.Fatalf("failed: %v % x", err, frame)
+	}
+	packet = make([]byte, binary.BigEndian.Uint16(frame[2:]))
+	if _, err := io.ReadFull(conn.reader, packet); err != nil {
+		t.Fatal(err)
+	}
+	return frame[1], packet
+}
+
+func TestReplacePublishedStream(t *testing.T) {
+	server := startTestServer(t, nil)
+	defer server.Destroy()
+
+	publisher := publish(t, server, "rtsp://127.0.0.1/live")
+	defer publisher.close()
+	done := make(chan struct{})
+	pushed := make(chan struct{})
+	defer func() {
+		close(done)
+		<-pushed
+	}()
+	go func() {
+		defer close(pushed)
+		for {
+			publisher.push()
+			select {
+			case <-done:
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+	}()
+
+	conn := dialTestServer(t, server)
+	defer conn.Close()
+	roundTrip(t, conn, "DESCRIBE rtsp://127.0.0.1/live RTSP/1.0\r\nCSeq: 1\r\n\r\n")
+	_, header, _ := roundTrip(t, conn, setupRequest("rtsp://127.0.0.1/live/track2", 2, interleavedTransport))
+	if status, _, _ := roundTrip(t, conn, sessionRequest("PLAY", "rtsp://127.0.0.1/live/", 3,
+		sessionID(header))); status != rtsp.StatusOK {
+		t.Fatalf("failed to PLAY: %d", status)
+	}
+	// (Wait for the stream to reach the player.)
+	for {
+		if channelID, _ := readInterleaved(t, conn); channelID == 0 {
+			break
+		}
+	}
+
+	// A session that is added under the name of the published stream stops it, though its
+	// publisher goes on sending:
+	sms := livemedia.NewServerMediaSession("H.264 Video", "live")
+	sms.AddSubsession(livemedia.NewH264FileMediaSubsession("../examples/test.264"))
+	server.AddServerMediaSession(sms)
+
+	// (Drain what was sent before.)
+	stopped := false
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline) && !stopped; {
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, err := conn.reader.ReadByte()
+		netErr, ok := err.(net.Error)
+		stopped = ok && netErr.Timeout()
+	}
+	if !stopped {
+		t.Fatal("failed: the stream goes on")
+	}
+	if sessions := server.ServerMediaSessions(); len(sessions) == 1 && sessions[0] == sms {
+		t.Log("success")
+	} else {
+		t.Error("failed")
+	}
+}
+
+// dialHTTPServer connects to the RTSP-over-HTTP port of a server.
+func dialHTTPServer(t *testing.T, server *RTSPServer) *testConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", server.HTTPServerPortNum()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTestConn(conn)
+}
+
+// openTunnel opens the GET connection of a RTSP-over-HTTP tunnel, and the POST connection
+// that is paired with it by the session cookie.
+func openTunnel(t *testing.T, server *RTSPServer, cookie string) (get, post *testConn) {
+	t.Helper()
+	get = dialHTTPServer(t, server)
+	status, header, _ := roundTrip(t, get, "GET /test.264 HTTP/1.0\r\nx-sessioncookie: "+cookie+
+		"\r\nAccept: application/x-rtsp-tunnelled\r\n\r\n")
+	if status != rtsp.StatusOK || header.Get("Content-Type") != "application/x-rtsp-tunnelled" {
+		t.Fatalf("failed to GET: %d", status)
+	}
+
+	post = dialHTTPServer(t, server)
+	post.Write([]byte("POST /test.264 HTTP/1.0\r\nx-sessioncookie: " + cookie +
+		"\r\nContent-Type: application/x-rtsp-tunnelled\r\nContent-Length: 32767\r\n\r\n"))
+	return get, post
+}
+
+// isClosed reports whether the server has closed a connection (or reset it).
+func isClosed(conn *testConn) bool {
+	_, err := ioutil.ReadAll(conn.reader)
+	netErr, ok := err.(net.Error)
+	return !ok || !netErr.Timeout()
+}
+
+func TestHTTPTunneling(t *testing.T) {
+	server := startTestServer(t, nil)
+	defer server.Destroy()
+	if !server.SetupTunnelingOverHTTP(0) {
+		t.Fatal("failed to set up tunneling")
+	}
+
+	get, post := openTunnel(t, server, "abc")
+	defer get.Close()
+	defer post.Close()
+
+	// A POST whose cookie has no GET isn't paired:
+	stray := dialHTTPServer(t, server)
+	defer stray.Close()
+	if status, _, _ := roundTrip(t, stray, "POST /test.264 HTTP/1.0\r\nx-sessioncookie: def\r\n\r\n"); status == rtsp.StatusNotFound {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %d", status)
+	}
+
+	// Pipelined requests, whose base64 text is split (with a line break) across the writes;
+	// the responses come on the GET connection:
+	requests := base64.StdEncoding.EncodeToString([]byte(
+		"OPTIONS rtsp://127.0.0.1/test.264 RTSP/1.0\r\nCSeq: 1\r\n\r\n" +
+			"DESCRIBE rtsp://127.0.0.1/test.264 RTSP/1.0\r\nCSeq: 2\r\n\r\n"))
+	for _, data := range []string{requests[:7], requests[7:30] + "\r\n", requests[30:]} {
+		post.Write([]byte(data))
+		time.Sleep(10 * time.Millisecond)
+	}
+	var cseqs []string
+	for len(cseqs) < 2 {
+		resp, err := rtsp.ReadResponse(get.reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cseqs = append(cseqs, resp.Header.Get("CSeq"))
+	}
+	if strings.Join(cseqs, ",") == "1,2" {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: responses to %v", cseqs)
+	}
+
+	// The RTP packets of a PLAY come on the GET connection:
+	tunnel := func(request string) {
+		post.Write([]byte(base64.StdEncoding.EncodeToString([]byte(request))))
+	}
+	tunnel(setupRequest("rtsp://127.0.0.1/test.264/track1", 3, interleavedTransport))
+	resp, err := rtsp.ReadResponse(get.reader)
+	if err != nil || resp.StatusCode != rtsp.StatusOK {
+		t.Fatalf("failed to SETUP: %v", err)
+	}
+	tunnel(sessionRequest("PLAY", "rtsp://127.0.0.1/test.264/", 4, sessionID(resp.Header)))
+	if resp, err = rtsp.ReadResponse(get.reader); err != nil || resp.StatusCode != rtsp.StatusOK {
+		t.Fatalf("failed to PLAY: %v", err)
+	}
+	if channelID, packet := readInterleaved(t, get); channelID == 0 && packet[0]>>6 == 2 {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: channel %d", channelID)
+	}
+
+	// Closing either connection of a tunnel closes the other, and ends its session:
+	post.Close()
+	if isClosed(get) && len(server.allClientSessions()) == 0 {
+		t.Log("success")
+	} else {
+		t.Errorf("failed: %d sessions are left", len(server.allClientSessions()))
+	}
+
+	get, post = openTunnel(t, server, "ghi")
+	defer post.Close()
+	get.Close()
+	if isClosed(post) {
+		t.Log("success")
+	} else {
+		t.Error("failed")
+	}
+}
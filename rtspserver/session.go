@@ -1,33 +1,47 @@
 package rtspserver
 
 import (
-	"fmt"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/djwackey/dorsvr/livemedia"
+	"github.com/djwackey/dorsvr/rtsp"
+	"github.com/djwackey/gitea/log"
 )
 
 type RTSPClientSession struct {
 	isMulticast          bool
-	isTimerRunning       bool
 	streamAfterSETUP     bool
 	numStreamStates      int
 	TCPStreamIDCount     uint
 	sessionID            string
-	streamStates         *StreamServerState
+	streamStates         []*StreamServerState
 	connection           *RTSPClientConnection
 	serverMediaSession   *livemedia.ServerMediaSession
 	livenessTimeoutTimer *time.Timer
+	recordSubsessions    []*livemedia.RecordServerMediaSubsession
+	startTime            time.Time
+	transport            string // of the latest "SETUP", e.g. "RTP/AVP/TCP"
+	destroyed            chan struct{}
+	destroyOnce          sync.Once
+	// guards the session: its requests come from its connection's goroutine, but it may be
+	// destroyed, or looked at by the metrics and the admin API, from others
+	mutex sync.Mutex
 }
 
 func newRTSPClientSession(connection *RTSPClientConnection, sessionID string) *RTSPClientSession {
 	s := &RTSPClientSession{
 		sessionID:  sessionID,
 		connection: connection,
+		startTime:  time.Now(),
+		destroyed:  make(chan struct{}),
 	}
-	s.noteLiveness()
+	s.livenessTimeoutTimer = time.NewTimer(time.Second * s.server().reclamationTestSeconds)
+
+	s.server().goroutines.Add(1)
+	go s.livenessTimeoutTask()
 	return s
 }
 
@@ -35,89 +49,241 @@ func (s *RTSPClientSession) server() *RTSPServer {
 	return s.connection.server
 }
 
+// destroy ends the session. It may be called more than once, e.g. after a "TEARDOWN"
+// and again when the connection closes.
 func (s *RTSPClientSession) destroy() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.destroyLocked()
+}
+
+// destroyLocked is destroy, for a caller that holds the session's mutex.
+func (s *RTSPClientSession) destroyLocked() {
+	s.destroyOnce.Do(s.doDestroy)
+}
+
+// isDestroyed reports whether the session has ended; a request that was on its way when it
+// did finds it so.
+func (s *RTSPClientSession) isDestroyed() bool {
+	select {
+	case <-s.destroyed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *RTSPClientSession) doDestroy() {
 	// turn off any liveness check:
+	close(s.destroyed)
 	s.livenessTimeoutTimer.Stop()
 
 	s.server().removeClientSession(s.sessionID)
 
+	// Stop the streams of every track that is still set up:
+	s.reclaimStreamStates()
+
 	if s.serverMediaSession != nil {
 		streamName := s.serverMediaSession.StreamName()
-		s.server().removeServerMediaSession(streamName)
+		s.server().removeFileSession(streamName)
+
+		// A client that was publishing the stream has gone away:
+		if len(s.recordSubsessions) > 0 {
+			s.server().closeLiveSession(s.serverMediaSession)
+		}
 	}
 }
 
-func (s *RTSPClientSession) handleCommandSetup(urlPreSuffix, urlSuffix, reqStr string) {
-	streamName, trackID := urlPreSuffix, urlSuffix
+// streams returns the name of the session's stream, if it has one yet, and the streams of
+// its tracks that are set up.
+func (s *RTSPClientSession) streams() (streamName string, streams []StreamServerState) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	sms := s.server().lookupServerMediaSession(streamName)
-	if sms == nil {
+	if s.serverMediaSession != nil {
+		streamName = s.serverMediaSession.StreamName()
+	}
+	for _, streamState := range s.streamStates {
+		if streamState.streamToken != nil {
+			streams = append(streams, *streamState)
+		}
+	}
+	return
+}
+
+// info describes the session for the admin API.
+func (s *RTSPClientSession) info() *SessionInfo {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	info := &SessionInfo{
+		ID:         s.sessionID,
+		RemoteAddr: s.connection.socket.RemoteAddr().String(),
+		Transport:  s.transport,
+		Recording:  len(s.recordSubsessions) > 0,
+		StartTime:  s.startTime,
+	}
+	if s.serverMediaSession != nil {
+		info.StreamName = s.serverMediaSession.StreamName()
+	}
+	for _, streamState := range s.streamStates {
+		if streamState.streamToken != nil {
+			info.BytesSent += streamState.octetsSent()
+		}
+	}
+	return info
+}
+
+// reclaimStreamStates deletes the stream of every track that has been set up.
+func (s *RTSPClientSession) reclaimStreamStates() {
+	for _, streamState := range s.streamStates {
+		if streamState.subsession != nil && streamState.streamToken != nil {
+			streamState.subsession.DeleteStream(s.sessionID, streamState.streamToken)
+			streamState.streamToken = nil
+		}
+	}
+}
+
+func (s *RTSPClientSession) handleCommandSetup(urlPreSuffix, urlSuffix string, req *rtsp.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.isDestroyed() {
+		s.connection.handleCommandSessionNotFound()
+		return
+	}
+
+	isFirstSetup := s.serverMediaSession == nil
+	defer func() {
+		// A session that doesn't get a stream is of no use to the client, which doesn't get its id:
 		if s.serverMediaSession == nil {
+			s.destroyLocked()
+		}
+	}()
+
+	// The URL is "<stream name>/<track id>", or just "<stream name>" for a single-track stream.
+	// The first "SETUP" of a session needs the same access as a "DESCRIBE", and the stream is
+	// looked up only once the client may have it, so that the names of the streams don't leak:
+	candidates := [][2]string{{urlPreSuffix, urlSuffix}}
+	if urlPreSuffix == "" {
+		candidates[0] = [2]string{urlSuffix, ""}
+	} else if urlSuffix != "" {
+		// "<urlPreSuffix>/<urlSuffix>" may be the stream name, with no track id:
+		candidates = append(candidates, [2]string{urlPreSuffix + "/" + urlSuffix, ""})
+	}
+
+	var sms *livemedia.ServerMediaSession
+	var streamName, trackID string
+	var authenticated bool
+	for _, candidate := range candidates {
+		if isFirstSetup && !s.connection.authenticationOK("SETUP", candidate[0], req) {
+			continue
+		}
+		authenticated = true
+		if sms = s.server().lookupServerMediaSession(candidate[0]); sms != nil {
+			streamName, trackID = candidate[0], candidate[1]
+			break
+		}
+	}
+	if sms == nil {
+		switch {
+		case !isFirstSetup:
+			s.connection.handleCommandBad()
+		case authenticated:
 			s.connection.handleCommandNotFound()
+		}
+		return
+	}
+
+	if status := s.server().config.Hooks.OnSetup(s.connection.requestInfo(req, streamName)); status != 0 {
+		if isFirstSetup {
+			s.connection.setRTSPResponse(status)
 		} else {
-			s.connection.handleCommandBad()
+			s.connection.setRTSPResponseWithSessionID(status, s.sessionID)
 		}
 		return
 	}
 
-	if s.serverMediaSession == nil {
+	if isFirstSetup {
 		s.serverMediaSession = sms
 	} else if sms != s.serverMediaSession {
 		s.connection.handleCommandBad()
 		return
 	}
 
+	// Look for a "Transport:" header in the request, to extract client parameters:
+	transport, err := rtsp.ParseTransport(req.Header.Get("Transport"))
+	if err != nil {
+		s.connection.handleCommandUnsupportedTransport()
+		return
+	}
+	if transport.Mode == "RECORD" {
+		s.handleCommandSetupRecord(trackID, transport)
+		return
+	}
+
 	if s.streamStates == nil {
+		// This is the first "SETUP" for this session.  Set up our array of states
+		// for all of this session's subsessions (tracks):
 		s.numStreamStates = s.serverMediaSession.SubsessionCounter
 
-		s.streamStates = new(StreamServerState)
+		s.streamStates = make([]*StreamServerState, s.numStreamStates)
 		for i := 0; i < s.numStreamStates; i++ {
-			s.streamStates.subsession = s.serverMediaSession.Subsessions[i]
+			s.streamStates[i] = &StreamServerState{
+				subsession: s.serverMediaSession.Subsessions[i],
+			}
 		}
 	}
 
 	// Look up information for the specified subsession (track):
+	var streamNum int
 	var subsession livemedia.IServerMediaSubsession
 	if trackID != "" {
-		for streamNum := 0; streamNum < s.numStreamStates; streamNum++ {
-			subsession = s.streamStates.subsession
+		for streamNum = 0; streamNum < s.numStreamStates; streamNum++ {
+			subsession = s.streamStates[streamNum].subsession
 			if subsession != nil && strings.EqualFold(trackID, subsession.TrackID()) {
 				break
 			}
 		}
+		if streamNum >= s.numStreamStates {
+			// The specified track id doesn't exist, so this request fails:
+			s.connection.handleCommandNotFound()
+			return
+		}
 	} else {
-		if s.numStreamStates != 1 && s.streamStates == nil {
+		// Aggregated operation in a "SETUP" request is valid only if this session has exactly one subsession:
+		if s.numStreamStates != 1 || s.streamStates[0].subsession == nil {
 			s.connection.handleCommandBad()
 			return
 		}
-		subsession = s.streamStates.subsession
+		streamNum = 0
+		subsession = s.streamStates[streamNum].subsession
 	}
 
-	// Look for a "Transport:" header in the request string, to extract client parameters:
-	transportHeader := livemedia.ParseTransportHeader(reqStr)
-	rtpChannelID := transportHeader.RTPChannelID
-	rtcpChannelID := transportHeader.RTCPChannelID
-	streamingMode := transportHeader.StreamingMode
-	clientRTPPort := transportHeader.ClientRTPPortNum
-	clientRTCPPort := transportHeader.ClientRTCPPortNum
-	streamingModeStr := transportHeader.StreamingModeStr
+	// A SRTP stream is sent with the "RTP/SAVP" profile only, and the others never are:
+	if (transport.Protocol == "RTP/SAVP") != subsession.SRTPEnabled() {
+		s.connection.handleCommandUnsupportedTransport()
+		return
+	}
+
+	if s.streamStates[streamNum].streamToken != nil {
+		// This track has already been set up; start it afresh:
+		subsession.DeleteStream(s.sessionID, s.streamStates[streamNum].streamToken)
+		s.streamStates[streamNum].streamToken = nil
+	}
+
+	streamingMode, clientRTPPort, clientRTCPPort, rtpChannelID, rtcpChannelID := transportParams(transport)
 
 	if streamingMode == livemedia.RTP_TCP && rtpChannelID == 0xFF {
+		// The client didn't choose its own channel ids; use the next free pair:
 		rtpChannelID = s.TCPStreamIDCount
 		rtcpChannelID = s.TCPStreamIDCount + 1
 	}
 	if streamingMode == livemedia.RTP_TCP {
-		rtcpChannelID = s.TCPStreamIDCount + 2
+		s.TCPStreamIDCount += 2
 	}
 
-	_, sawRangeHeader := livemedia.ParseRangeHeader(reqStr)
-	if sawRangeHeader {
-		s.streamAfterSETUP = true
-	} else if livemedia.ParsePlayNowHeader(reqStr) {
-		s.streamAfterSETUP = true
-	} else {
-		s.streamAfterSETUP = false
-	}
+	// The client may ask for the stream to start right away:
+	s.streamAfterSETUP = req.Header.Get("Range") != "" || req.Header.Get("x-playNow") != ""
 
 	sourceAddrStr := s.connection.localAddr
 	destAddrStr := s.connection.remoteAddr
@@ -134,90 +300,201 @@ func (s *RTSPClientSession) handleCommandSetup(urlPreSuffix, urlSuffix, reqStr s
 		clientRTCPPort,
 		rtpChannelID,
 		rtcpChannelID)
+	if streamParameter == nil {
+		// we've run out of ports
+		s.connection.setRTSPResponse(rtsp.StatusServiceUnavailable)
+		return
+	}
 	serverRTPPort := streamParameter.ServerRTPPort
 	serverRTCPPort := streamParameter.ServerRTCPPort
 
-	s.streamStates.streamToken = streamParameter.StreamToken
+	s.streamStates[streamNum] = &StreamServerState{subsession: subsession, streamToken: streamParameter.StreamToken}
 
-	if s.isMulticast {
-		switch streamingMode {
-		case livemedia.RTP_UDP:
-			s.connection.responseBuffer = fmt.Sprintf("RTSP/1.0 200 OK\r\n"+
-				"CSeq: %s\r\n"+
-				"%s"+
-				"Transport: RTP/AVP;multicast;destination=%s;source=%s;port=%d-%d;ttl=%d\r\n"+
-				"Session: %s\r\n\r\n", s.connection.currentCSeq,
-				livemedia.DateHeader(),
-				destAddrStr,
-				sourceAddrStr,
-				serverRTPPort,
-				serverRTCPPort,
-				transportHeader.DestinationTTL,
-				s.sessionID)
-		case livemedia.RTP_TCP:
+	// A multicast track is sent to its group, whatever the client asked for:
+	if streamParameter.IsMulticast {
+		s.isMulticast = true
+		destAddrStr = streamParameter.DestinationAddr
+	}
+
+	// The parameters of the stream, as we send it:
+	reply := &rtsp.Transport{
+		Protocol:    "RTP/AVP",
+		Multicast:   s.isMulticast,
+		Destination: destAddrStr,
+		Source:      sourceAddrStr,
+	}
+	if subsession.SRTPEnabled() {
+		reply.Protocol = "RTP/SAVP"
+	}
+	switch streamingMode {
+	case livemedia.RTP_TCP:
+		if s.isMulticast {
 			// multicast streams can't be sent via TCP
 			s.connection.handleCommandUnsupportedTransport()
-		case livemedia.RAW_UDP:
-			s.connection.responseBuffer = fmt.Sprintf("RTSP/1.0 200 OK\r\n"+
-				"CSeq: %s\r\n"+
-				"%s"+
-				"Transport: %s;multicast;destination=%s;source=%d;port=%d;ttl=%d\r\n"+
-				"Session: %s\r\n\r\n", s.connection.currentCSeq,
-				livemedia.DateHeader(),
-				destAddrStr,
-				sourceAddrStr,
-				serverRTPPort,
-				serverRTCPPort,
-				transportHeader.DestinationTTL,
-				s.sessionID)
-		default:
+			return
+		}
+		reply.LowerTransport = "TCP"
+		reply.Interleaved = &rtsp.PortRange{Start: int(rtpChannelID), End: int(rtcpChannelID)}
+	case livemedia.RAW_UDP:
+		reply.Protocol, reply.LowerTransport = transport.Protocol, transport.LowerTransport
+		if s.isMulticast {
+			reply.Port = &rtsp.PortRange{Start: int(serverRTPPort), End: int(serverRTPPort)}
+		} else {
+			reply.ClientPort = &rtsp.PortRange{Start: int(clientRTPPort), End: int(clientRTPPort)}
+			reply.ServerPort = &rtsp.PortRange{Start: int(serverRTPPort), End: int(serverRTPPort)}
+		}
+	default:
+		if s.isMulticast {
+			reply.Port = &rtsp.PortRange{Start: int(serverRTPPort), End: int(serverRTCPPort)}
+		} else {
+			reply.ClientPort = &rtsp.PortRange{Start: int(clientRTPPort), End: int(clientRTCPPort)}
+			reply.ServerPort = &rtsp.PortRange{Start: int(serverRTPPort), End: int(serverRTCPPort)}
+		}
+	}
+	if s.isMulticast {
+		reply.TTL = int(streamParameter.DestinationTTL)
+		if reply.TTL == 0 {
+			reply.TTL = 255
+		}
+	}
+
+	s.setSetupResponse(reply)
+}
+
+// setSetupResponse fills in the response to a successful "SETUP".
+func (s *RTSPClientSession) setSetupResponse(reply *rtsp.Transport) {
+	session := &rtsp.Session{
+		ID:      s.sessionID,
+		Timeout: int(s.server().reclamationTestSeconds),
+	}
+
+	s.transport = reply.Protocol
+	if reply.LowerTransport != "" {
+		s.transport += "/" + reply.LowerTransport
+	}
+
+	resp := s.connection.newResponse(rtsp.StatusOK)
+	resp.Header.Set("Transport", reply.String())
+	resp.Header.Set("Session", session.String())
+	s.connection.setResponse(resp)
+}
+
+// transportParams returns the streaming mode that a transport asks for, and the
+// client's ports (or channel ids, which are 0xFF when the client didn't choose them).
+func transportParams(transport *rtsp.Transport) (streamingMode int,
+	clientRTPPort, clientRTCPPort, rtpChannelID, rtcpChannelID uint) {
+	streamingMode = livemedia.RTP_UDP
+	switch {
+	case transport.IsTCP():
+		streamingMode = livemedia.RTP_TCP
+	case transport.Protocol == "RAW/RAW" || transport.Protocol == "MP2T/H2221":
+		streamingMode = livemedia.RAW_UDP
+	}
+
+	rtpChannelID, rtcpChannelID = 0xFF, 0xFF
+	if transport.Interleaved != nil {
+		rtpChannelID = uint(transport.Interleaved.Start)
+		rtcpChannelID = uint(transport.Interleaved.End)
+	}
+
+	// An RTCP port of 0 means a raw UDP stream, so the defaults are those of live555:
+	clientRTPPort, clientRTCPPort = 0, 1
+	if transport.ClientPort != nil {
+		clientRTPPort = uint(transport.ClientPort.Start)
+		clientRTCPPort = uint(transport.ClientPort.End)
+		if transport.ClientPort.End == transport.ClientPort.Start {
+			clientRTCPPort = clientRTPPort + 1
+		}
+	}
+	if streamingMode == livemedia.RAW_UDP {
+		// raw streams have no RTCP
+		clientRTCPPort = 0
+	}
+	return
+}
+
+// handleCommandSetupRecord sets up a track of a stream that the client has ANNOUNCEd,
+// so that we receive it, rather than send it.
+func (s *RTSPClientSession) handleCommandSetupRecord(trackID string, transport *rtsp.Transport) {
+	if s.connection.announcedSession != s.serverMediaSession {
+		s.connection.setRTSPResponse(rtsp.StatusMethodNotValidInThisState)
+		return
+	}
+
+	var subsession *livemedia.RecordServerMediaSubsession
+	for i := 0; i < s.serverMediaSession.SubsessionCounter; i++ {
+		recordSubsession, ok := s.serverMediaSession.Subsessions[i].(*livemedia.RecordServerMediaSubsession)
+		if ok && (trackID == "" || strings.EqualFold(trackID, recordSubsession.TrackID())) {
+			subsession = recordSubsession
+			break
 		}
+	}
+	if subsession == nil {
+		s.connection.handleCommandNotFound()
+		return
+	}
+
+	streamingMode, clientRTPPort, clientRTCPPort, rtpChannelID, rtcpChannelID := transportParams(transport)
+
+	var tcpSocketNum net.Conn
+	switch streamingMode {
+	case livemedia.RTP_UDP:
+	case livemedia.RTP_TCP:
+		if rtpChannelID == 0xFF {
+			rtpChannelID = s.TCPStreamIDCount
+			rtcpChannelID = s.TCPStreamIDCount + 1
+		}
+		s.TCPStreamIDCount += 2
+		tcpSocketNum = s.connection.socket
+	default:
+		s.connection.handleCommandUnsupportedTransport()
+		return
+	}
+
+	sourceAddrStr := s.connection.localAddr
+	destAddrStr := s.connection.remoteAddr
+
+	streamParameter := subsession.GetRecordParameters(tcpSocketNum,
+		destAddrStr,
+		clientRTPPort,
+		clientRTCPPort,
+		rtpChannelID,
+		rtcpChannelID)
+	if streamParameter == nil {
+		s.connection.setRTSPResponse(rtsp.StatusMethodNotValidInThisState)
+		return
+	}
+	s.recordSubsessions = append(s.recordSubsessions, subsession)
+
+	reply := &rtsp.Transport{
+		Protocol:    "RTP/AVP",
+		Destination: destAddrStr,
+		Source:      sourceAddrStr,
+		Mode:        "RECORD",
+	}
+	if tcpSocketNum != nil {
+		s.connection.addRecordChannels(rtpChannelID, rtcpChannelID, subsession)
+		reply.LowerTransport = "TCP"
+		reply.Interleaved = &rtsp.PortRange{Start: int(rtpChannelID), End: int(rtcpChannelID)}
 	} else {
-		switch streamingMode {
-		case livemedia.RTP_UDP:
-			s.connection.responseBuffer = fmt.Sprintf("RTSP/1.0 200 OK\r\n"+
-				"CSeq: %s\r\n"+
-				"%s"+
-				"Transport: RTP/AVP;unicast;destination=%s;source=%s;client_port=%d-%d;server_port=%d-%d\r\n"+
-				"Session: %s\r\n\r\n", s.connection.currentCSeq,
-				livemedia.DateHeader(),
-				destAddrStr,
-				sourceAddrStr,
-				clientRTPPort,
-				clientRTCPPort,
-				serverRTPPort,
-				serverRTCPPort,
-				s.sessionID)
-		case livemedia.RTP_TCP:
-			s.connection.responseBuffer = fmt.Sprintf("RTSP/1.0 200 OK\r\n"+
-				"CSeq: %s\r\n"+
-				"%s"+
-				"Transport: RTP/AVP/TCP;unicast;destination=%s;source=%s;interleaved=%d-%d\r\n"+
-				"Session: %s\r\n\r\n", s.connection.currentCSeq,
-				livemedia.DateHeader(),
-				destAddrStr,
-				sourceAddrStr,
-				rtpChannelID,
-				rtcpChannelID,
-				s.sessionID)
-		case livemedia.RAW_UDP:
-			s.connection.responseBuffer = fmt.Sprintf("RTSP/1.0 200 OK\r\n"+
-				"CSeq: %s\r\n"+
-				"%s"+
-				"Transport: %s;unicast;destination=%s;source=%s;client_port=%d;server_port=%d\r\n"+
-				"Session: %s\r\n\r\n", s.connection.currentCSeq,
-				livemedia.DateHeader(),
-				streamingModeStr,
-				destAddrStr,
-				sourceAddrStr,
-				clientRTPPort,
-				serverRTPPort,
-				s.sessionID)
+		reply.ClientPort = &rtsp.PortRange{Start: int(clientRTPPort), End: int(clientRTCPPort)}
+		reply.ServerPort = &rtsp.PortRange{
+			Start: int(streamParameter.ServerRTPPort),
+			End:   int(streamParameter.ServerRTCPPort),
 		}
 	}
+
+	s.setSetupResponse(reply)
 }
 
-func (s *RTSPClientSession) handleCommandWithinSession(cmdName, urlPreSuffix, urlSuffix, fullRequestStr string) {
+func (s *RTSPClientSession) handleCommandWithinSession(cmdName, urlPreSuffix, urlSuffix string, req *rtsp.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.isDestroyed() {
+		s.connection.handleCommandSessionNotFound()
+		return
+	}
+
 	s.noteLiveness()
 
 	var subsession livemedia.IServerMediaSubsession
@@ -228,9 +505,8 @@ func (s *RTSPClientSession) handleCommandWithinSession(cmdName, urlPreSuffix, ur
 		// Non-aggregated operation.
 		// Look up the media subsession whose track id is "urlSuffix":
 		for i := 0; i < s.serverMediaSession.SubsessionCounter; i++ {
-			subsession = s.serverMediaSession.Subsessions[i]
-
-			if strings.EqualFold(subsession.TrackID(), urlSuffix) {
+			if strings.EqualFold(s.serverMediaSession.Subsessions[i].TrackID(), urlSuffix) {
+				subsession = s.serverMediaSession.Subsessions[i]
 				break
 			}
 		}
@@ -245,9 +521,7 @@ func (s *RTSPClientSession) handleCommandWithinSession(cmdName, urlPreSuffix, ur
 		subsession = nil
 	} else if urlPreSuffix != "" && urlSuffix != "" {
 		// Aggregated operation, if <urlPreSuffix>/<urlSuffix> is the session (stream) name:
-		if strings.EqualFold(s.serverMediaSession.StreamName(), urlPreSuffix) &&
-			s.serverMediaSession.StreamName() == "" &&
-			strings.EqualFold(s.serverMediaSession.StreamName(), urlSuffix) {
+		if strings.EqualFold(s.serverMediaSession.StreamName(), urlPreSuffix+"/"+urlSuffix) {
 			subsession = nil
 		} else {
 			s.connection.handleCommandNotFound()
@@ -258,13 +532,28 @@ func (s *RTSPClientSession) handleCommandWithinSession(cmdName, urlPreSuffix, ur
 		return
 	}
 
+	// A client that is publishing the stream can't also play it, and vice versa:
+	isRecording := len(s.recordSubsessions) > 0
+	if (cmdName == "PLAY" || cmdName == "PAUSE") && (isRecording || s.streamStates == nil) ||
+		cmdName == "RECORD" && !isRecording {
+		s.connection.setRTSPResponse(rtsp.StatusMethodNotValidInThisState)
+		return
+	}
+
+	if status := s.callHooks(cmdName, req); status != 0 {
+		s.connection.setRTSPResponseWithSessionID(status, s.sessionID)
+		return
+	}
+
 	switch cmdName {
 	case "TEARDOWN":
-		s.handleCommandTearDown()
+		s.handleCommandTearDown(subsession)
 	case "PLAY":
-		s.handleCommandPlay(subsession, fullRequestStr)
+		s.handleCommandPlay(subsession, req)
 	case "PAUSE":
-		s.handleCommandPause()
+		s.handleCommandPause(subsession)
+	case "RECORD":
+		s.handleCommandRecord()
 	case "GET_PARAMETER":
 		s.handleCommandGetParameter()
 	case "SET_PARAMETER":
@@ -272,11 +561,38 @@ func (s *RTSPClientSession) handleCommandWithinSession(cmdName, urlPreSuffix, ur
 	}
 }
 
-func (s *RTSPClientSession) handleCommandPlay(subsession livemedia.IServerMediaSubsession, fullRequestStr string) {
-	rtspURL := s.server().RtspURL(s.serverMediaSession.StreamName())
+// callHooks tells the hooks about a request within the session. It returns the status
+// that the request is to be answered with instead, or 0.
+func (s *RTSPClientSession) callHooks(cmdName string, req *rtsp.Request) int {
+	hooks := s.server().config.Hooks
+	info := s.connection.requestInfo(req, s.serverMediaSession.StreamName())
+	switch cmdName {
+	case "PLAY":
+		return hooks.OnPlay(info)
+	case "PAUSE":
+		return hooks.OnPause(info)
+	case "RECORD":
+		return hooks.OnRecord(info)
+	case "TEARDOWN":
+		hooks.OnTeardown(info)
+	}
+	return 0
+}
+
+func (s *RTSPClientSession) handleCommandPlay(subsession livemedia.IServerMediaSubsession, req *rtsp.Request) {
+	rtspURL := s.connection.rtspURL(s.serverMediaSession.StreamName())
 
 	// Parse the client's "Scale:" header, if any:
-	scale, sawScaleHeader := livemedia.ParseScaleHeader(fullRequestStr)
+	var scale float32 = 1.0
+	sawScaleHeader := req.Header.Get("Scale") != ""
+	if sawScaleHeader {
+		value, err := rtsp.ParseScale(req.Header.Get("Scale"))
+		if err != nil {
+			s.connection.setRTSPResponse(rtsp.StatusBadRequest)
+			return
+		}
+		scale = float32(value)
+	}
 
 	// Try to set the stream's scale factor to this value:
 	if subsession == nil {
@@ -285,76 +601,76 @@ func (s *RTSPClientSession) handleCommandPlay(subsession livemedia.IServerMediaS
 		scale = subsession.TestScaleFactor(scale)
 	}
 
-	var buf string
-	if sawScaleHeader {
-		buf = fmt.Sprintf("Scale: %f\r\n", scale)
+	// Parse the client's "Range:" header, if any:
+	var rangeHeader *rtsp.Range
+	if value := req.Header.Get("Range"); value != "" {
+		var err error
+		if rangeHeader, err = rtsp.ParseRange(value); err != nil || rangeHeader.Unit == "smpte" ||
+			strings.HasPrefix(rangeHeader.Unit, "smpte-") {
+			s.connection.setRTSPResponse(rtsp.StatusInvalidRange)
+			return
+		}
 	}
-	scaleHeaderStr := buf
+	sawRangeHeader := rangeHeader != nil
 
-	buf = ""
 	var rangeStart, rangeEnd, duration float32
-	var absStartTime, absEndTime string
-
-	rangeHeader, sawRangeHeader := livemedia.ParseRangeHeader(fullRequestStr)
-	if sawRangeHeader && rangeHeader.AbsStartTime == "" {
-		if subsession == nil {
-			duration = s.serverMediaSession.Duration()
-		} else {
-			//duration = subsession.Duration()
-		}
-		if duration < 0 {
-			duration = -duration
-		}
+	var replyRange *rtsp.Range
+	if sawRangeHeader && rangeHeader.Unit == "clock" {
+		// We're seeking by 'absolute' time:
+		replyRange = &rtsp.Range{Unit: "clock", Start: rangeHeader.Start, End: rangeHeader.End}
+	} else {
+		if sawRangeHeader {
+			start, end, _ := rangeHeader.NPT()
+			rangeStart, rangeEnd = float32(start), float32(end)
+			if end < 0 {
+				rangeEnd = 0
+			}
 
-		rangeStart = rangeHeader.RangeStart
-		rangeEnd = rangeHeader.RangeEnd
-		absStartTime = rangeHeader.AbsStartTime
-		absEndTime = rangeHeader.AbsEndTime
+			if subsession == nil {
+				duration = s.serverMediaSession.Duration()
+			}
+			if duration < 0 {
+				duration = -duration
+			}
 
-		if rangeStart < 0 {
-			rangeStart = 0
-		} else if rangeStart > duration {
-			rangeStart = duration
-		}
-		if rangeEnd < 0 {
-			rangeEnd = 0
-		} else if rangeEnd > duration {
-			rangeEnd = duration
-		}
+			if rangeStart < 0 {
+				rangeStart = 0
+			} else if rangeStart > duration {
+				rangeStart = duration
+			}
+			if rangeEnd < 0 {
+				rangeEnd = 0
+			} else if rangeEnd > duration {
+				rangeEnd = duration
+			}
 
-		if (scale > 0.0 && rangeStart > rangeEnd && rangeEnd > 0.0) || (scale < 0.0 && rangeStart < rangeEnd) {
-			// "rangeStart" and "rangeEnd" were the wrong way around; swap them:
-			rangeStart, rangeEnd = rangeEnd, rangeStart
+			if (scale > 0.0 && rangeStart > rangeEnd && rangeEnd > 0.0) || (scale < 0.0 && rangeStart < rangeEnd) {
+				// "rangeStart" and "rangeEnd" were the wrong way around; swap them:
+				rangeStart, rangeEnd = rangeEnd, rangeStart
+			}
 		}
 
-		// We're seeking by 'absolute' time:
-		if absEndTime == "" {
-			buf = fmt.Sprintf("Range: clock=%s-\r\n", absStartTime)
-		} else {
-			buf = fmt.Sprintf("Range: clock=%s-%s\r\n", absStartTime, absEndTime)
-		}
-	} else {
 		// We're seeking by relative (NPT) time:
 		if rangeEnd == 0.0 && scale >= 0.0 {
-			buf = fmt.Sprintf("Range: npt=%.3f-\r\n", rangeStart)
+			replyRange = rtsp.NewNPTRange(float64(rangeStart), -1)
 		} else {
-			buf = fmt.Sprintf("Range: npt=%.3f-%.3f\r\n", rangeStart, rangeEnd)
+			replyRange = rtsp.NewNPTRange(float64(rangeStart), float64(rangeEnd))
 		}
 	}
 
 	for i := 0; i < s.numStreamStates; i++ {
-		if subsession == nil || s.numStreamStates == 1 {
+		streamState := s.streamStates[i]
+		if streamState.streamToken == nil {
+			continue
+		}
+		if subsession == nil /* aggregated operation */ || subsession == streamState.subsession {
 			if sawScaleHeader {
-				if s.streamStates.subsession != nil {
-					//s.streamStates.subsession.setStreamScale(s.ourSessionID, s.streamStates.streamToken, scale)
-				}
+				//streamState.subsession.setStreamScale(s.sessionID, streamState.streamToken, scale)
 			}
 			if sawRangeHeader {
 				// Special case handling for seeking by 'absolute' time:
-				if absStartTime != "" {
-					if s.streamStates.subsession != nil {
-						s.streamStates.subsession.SeekStream(s.sessionID, s.streamStates.streamToken, 0)
-					}
+				if rangeHeader.Unit == "clock" {
+					streamState.subsession.SeekStream(s.sessionID, streamState.streamToken, 0)
 				} else { // Seeking by relative (NPT) time:
 					var streamDuration float32 = 0.0                   // by default; means: stream until the end of the media
 					if rangeEnd > 0.0 && (rangeEnd+0.001) < duration { // the 0.001 is because we limited the values to 3 decimal places
@@ -364,91 +680,119 @@ func (s *RTSPClientSession) handleCommandPlay(subsession livemedia.IServerMediaS
 							streamDuration = -streamDuration // should happen only if scale < 0.0
 						}
 					}
-					if s.streamStates.subsession != nil {
-						s.streamStates.subsession.SeekStream(s.sessionID, s.streamStates.streamToken, streamDuration)
-					}
+					streamState.subsession.SeekStream(s.sessionID, streamState.streamToken, streamDuration)
 				}
 			}
 		}
 	}
 
-	rangeHeaderStr := buf
-
-	rtpSeqNum, rtpTimestamp := s.streamStates.subsession.StartStream(s.sessionID, s.streamStates.streamToken,
-		s.noteLiveness, s.connection.handleAlternativeRequestByte)
-	urlSuffix := s.streamStates.subsession.TrackID()
-
-	// Create a "RTP-INFO" line. It will get filled in from each subsession's state:
-	rtpInfoFmt := "RTP-INFO: " +
-		"%s" +
-		"url=%s/%s" +
-		";seq=%d" +
-		";rtptime=%d"
+	// Now, start streaming, and create a "RTP-Info" header. It will get filled in from each subsession's state:
+	var rtpInfo rtsp.RTPInfo
+	for i := 0; i < s.numStreamStates; i++ {
+		streamState := s.streamStates[i]
+		if streamState.streamToken == nil {
+			continue
+		}
+		if subsession != nil && subsession != streamState.subsession {
+			continue
+		}
 
-	rtpInfo := fmt.Sprintf(rtpInfoFmt, "", rtspURL, urlSuffix, rtpSeqNum, rtpTimestamp)
+		if !streamState.played {
+			if stats := streamState.streamToken.Stats(); stats != nil {
+				streamState.octetCountAtStart = stats.OctetCount
+			}
+			streamState.played = true
+		}
+		rtpSeqNum, rtpTimestamp := streamState.subsession.StartStream(s.sessionID, streamState.streamToken,
+			s.noteLiveness)
+		rtpInfo = append(rtpInfo, rtsp.RTPInfoEntry{
+			URL:        rtspURL + "/" + streamState.subsession.TrackID(),
+			Seq:        uint16(rtpSeqNum),
+			RTPTime:    uint32(rtpTimestamp),
+			HasSeq:     true,
+			HasRTPTime: true,
+		})
+	}
 
 	// Fill in the response:
-	s.connection.responseBuffer = fmt.Sprintf("RTSP/1.0 200 OK\r\n"+
-		"CSeq: %s\r\n"+
-		"%s"+
-		"%s"+
-		"%s"+
-		"Session: %s\r\n"+
-		"%s\r\n\r\n", s.connection.currentCSeq,
-		livemedia.DateHeader(),
-		scaleHeaderStr,
-		rangeHeaderStr,
-		s.sessionID,
-		rtpInfo)
+	resp := s.connection.newResponse(rtsp.StatusOK)
+	if sawScaleHeader {
+		resp.Header.Set("Scale", rtsp.FormatScale(float64(scale)))
+	}
+	resp.Header.Set("Range", replyRange.String())
+	resp.Header.Set("Session", s.sessionID)
+	if len(rtpInfo) > 0 {
+		resp.Header.Set("RTP-Info", rtpInfo.String())
+	}
+	s.connection.setResponse(resp)
 }
 
-func (s *RTSPClientSession) handleCommandPause() {
-	s.streamStates.subsession.PauseStream(s.streamStates.streamToken)
+func (s *RTSPClientSession) handleCommandRecord() {
+	for _, subsession := range s.recordSubsessions {
+		subsession.StartRecording()
+	}
 
-	//for i := 0; i < s.numStreamStates; i++ {
-	//	s.streamStates[i].subsession.PauseStream()
-	//}
+	s.connection.setRTSPResponseWithSessionID(rtsp.StatusOK, s.sessionID)
+}
 
-	s.connection.setRTSPResponseWithSessionID("200 OK", s.sessionID)
+func (s *RTSPClientSession) handleCommandPause(subsession livemedia.IServerMediaSubsession) {
+	for i := 0; i < s.numStreamStates; i++ {
+		streamState := s.streamStates[i]
+		if streamState.streamToken == nil {
+			continue
+		}
+		if subsession == nil /* aggregated operation */ || subsession == streamState.subsession {
+			streamState.subsession.PauseStream(streamState.streamToken)
+		}
+	}
+
+	s.connection.setRTSPResponseWithSessionID(rtsp.StatusOK, s.sessionID)
 }
 
 func (s *RTSPClientSession) handleCommandGetParameter() {
-	s.connection.setRTSPResponseWithSessionID("200 OK", s.sessionID)
+	s.connection.setRTSPResponseWithSessionID(rtsp.StatusOK, s.sessionID)
 }
 
 func (s *RTSPClientSession) handleCommandSetParameter() {
-	s.connection.setRTSPResponseWithSessionID("200 OK", s.sessionID)
+	s.connection.setRTSPResponseWithSessionID(rtsp.StatusOK, s.sessionID)
 }
 
-func (s *RTSPClientSession) handleCommandTearDown() {
-	s.streamStates.subsession.DeleteStream(s.sessionID, s.streamStates.streamToken)
+func (s *RTSPClientSession) handleCommandTearDown(subsession livemedia.IServerMediaSubsession) {
+	noSubsessionsRemain := true
+	for i := 0; i < s.numStreamStates; i++ {
+		streamState := s.streamStates[i]
+		if streamState.streamToken == nil {
+			continue
+		}
+		if subsession == nil /* aggregated operation */ || subsession == streamState.subsession {
+			streamState.subsession.DeleteStream(s.sessionID, streamState.streamToken)
+			streamState.streamToken = nil
+		} else {
+			noSubsessionsRemain = false
+		}
+	}
 
-	//for i := 0; i < s.numStreamStates; i++ {
-	//	s.streamStates[i].subsession.DeleteStream()
-	//}
+	s.connection.setRTSPResponse(rtsp.StatusOK)
 
-	s.connection.setRTSPResponse("200 OK")
-	s.destroy()
+	// Optimization: If all subsessions have now been torn down, then we know that we can reclaim our object now.
+	if noSubsessionsRemain {
+		s.destroyLocked()
+	}
 }
 
 func (s *RTSPClientSession) noteLiveness() {
-	if !s.isTimerRunning {
-		go s.livenessTimeoutTask(time.Second * s.server().reclamationTestSeconds)
-		s.isTimerRunning = true
-	} else {
-		//fmt.Println("noteLiveness", s.livenessTimeoutTimer)
-		s.livenessTimeoutTimer.Reset(time.Second * s.server().reclamationTestSeconds)
-	}
+	s.livenessTimeoutTimer.Reset(time.Second * s.server().reclamationTestSeconds)
 }
 
-func (s *RTSPClientSession) livenessTimeoutTask(d time.Duration) {
-	s.livenessTimeoutTimer = time.NewTimer(d)
+// livenessTimeoutTask destroys the session when the client hasn't been heard from
+// for a while, and ends when the session is destroyed.
+func (s *RTSPClientSession) livenessTimeoutTask() {
+	defer s.server().goroutines.Done()
 
-	for {
-		select {
-		case <-s.livenessTimeoutTimer.C:
-			fmt.Println("livenessTimeoutTask")
-			s.destroy()
-		}
+	select {
+	case <-s.livenessTimeoutTimer.C:
+		log.Info("the client session %s timed out", s.sessionID)
+		s.destroy()
+	case <-s.destroyed:
 	}
 }
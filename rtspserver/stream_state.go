@@ -5,4 +5,19 @@ import "github.com/djwackey/dorsvr/livemedia"
 type StreamServerState struct {
 	subsession  livemedia.IServerMediaSubsession
 	streamToken *livemedia.StreamState
+	// whether the client has played the stream, and what the stream had sent before it did:
+	// a stream that is shared by several clients counts what it sends to all of them
+	played            bool
+	octetCountAtStart uint
+}
+
+// octetsSent returns the RTP payload octets that the stream has sent to the client.
+func (s *StreamServerState) octetsSent() uint {
+	if !s.played {
+		return 0
+	}
+	if stats := s.streamToken.Stats(); stats != nil {
+		return stats.OctetCount - s.octetCountAtStart
+	}
+	return 0
 }